@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/ioncloud64/freemegb/core"
+)
+
+// Exit codes of the run command
+const (
+	ExitSuccess = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+const dapUsage = `Usage: freemegb dap [options]
+
+Serves the Debug Adapter Protocol on stdin and stdout, or on a TCP port,
+so editors can launch a ROM and debug it. The launch request takes:
+  program      the ROM to run
+  symbols      its .sym file, by default the ROM with a .sym extension
+  sourceRoot   a directory whose sources define the labels, for line breakpoints
+  stopOnEntry  stop before the first instruction
+
+Options:
+`
+
+const runUsage = `Usage: freemegb run --headless [options] rom.gb
+
+Runs a ROM without a display, for CI and remote machines.
+
+Options:
+`
+
+// RunCommand runs "freemegb run" with the arguments after "run", returning the exit code.
+// Flags may come before or after the ROM.
+func RunCommand(System *core.SystemType, args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	headless := flags.Bool("headless", false, "run without a window, required")
+	frames := flags.Int("frames", 60, "number of frames to run, with --movie until it ends unless given")
+	screenshot := flags.String("screenshot", "", "save the final frame to this PNG file")
+	serial := flags.Bool("serial", false, "write the serial port output to stdout")
+	movie := flags.String("movie", "", "play this movie, failing unless its last frame matches")
+	trace := flags.String("trace", "", "write the state before each instruction to this file, in the Gameboy Doctor format")
+	traceCycles := flags.Bool("trace-cycles", false, "append the cycle counter to each trace line")
+	traceLY := flags.Bool("trace-ly", false, "make LY read 0x90, as Gameboy Doctor logs expect")
+	verbose := flags.Bool("verbose", false, "log INFO messages, such as the loaded ROM and serial output")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), runUsage)
+		flags.PrintDefaults()
+	}
+
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return ExitSuccess
+			}
+			return ExitUsage
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if !*headless || len(positional) != 1 || *frames < 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	if *movie != "" {
+		framesSet := false
+		flags.Visit(func(f *flag.Flag) {
+			framesSet = framesSet || f.Name == "frames"
+		})
+		if !framesSet {
+			*frames = 0
+		}
+	}
+
+	// keep stdout for the serial output
+	core.Logger.Console = os.Stderr
+	core.Logger.Quiet = !*verbose
+
+	core.Trace.Cycles = *traceCycles
+	core.Trace.StubLY = *traceLY
+	err := System.RunHeadless(positional[0], core.HeadlessOptions{
+		Frames:     *frames,
+		Screenshot: *screenshot,
+		Movie:      *movie,
+		Trace:      *trace,
+	})
+	if *serial {
+		os.Stdout.WriteString(core.SerialPrinter.Output())
+	}
+	if err != nil {
+		core.Logger.Log(core.LogTypes.ERROR, "HEADLESS:", err)
+		return ExitFailure
+	}
+	return ExitSuccess
+}
+
+// DAPCommand runs "freemegb dap" with the arguments after "dap", returning the exit code.
+func DAPCommand(System *core.SystemType, args []string) int {
+	flags := flag.NewFlagSet("dap", flag.ContinueOnError)
+	port := flags.Int("port", 0, fmt.Sprintf("listen on this localhost port instead of stdin and stdout, usually %d", core.DAPPortDefault))
+	verbose := flags.Bool("verbose", false, "log INFO messages, such as the loaded ROM and serial output")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), dapUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitSuccess
+		}
+		return ExitUsage
+	}
+	if flags.NArg() != 0 || *port < 0 || *port > 0xFFFF {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	// keep stdout for the protocol
+	core.Logger.Console = os.Stderr
+	core.Logger.Quiet = !*verbose
+
+	var err error
+	if *port != 0 {
+		var conn net.Conn
+		if conn, err = core.ListenDAP(*port); err == nil {
+			err = core.DAPServer.Serve(System, conn, conn)
+			conn.Close()
+		}
+	} else {
+		err = core.DAPServer.Serve(System, os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		core.Logger.Log(core.LogTypes.ERROR, "DAP:", err)
+		return ExitFailure
+	}
+	return ExitSuccess
+}
@@ -0,0 +1,716 @@
+package core
+
+import (
+	"math"
+	"sync"
+)
+
+// ClockSpeed is the DMG CPU clock in cycles per second
+const ClockSpeed = 4194304
+
+// apuFrameSequencerPeriod is the number of cycles between frame sequencer steps (512Hz)
+const apuFrameSequencerPeriod = ClockSpeed / 512
+
+// apuMaxBufferedSamples caps the sample buffer when nothing drains it
+const apuMaxBufferedSamples = 1 << 16
+
+// APUScopeLength is the number of samples of each channel kept for the oscilloscope
+const APUScopeLength = 512
+
+// APUChannelNames are the channel names in channel order
+var APUChannelNames = [4]string{"Square 1", "Square 2", "Wave", "Noise"}
+
+var squareDutyNames = [4]string{"12.5%", "25%", "50%", "75%"}
+
+// apuSinkChunk is the number of int16 values handed to the Sink at a time
+const apuSinkChunk = 1024
+
+// apuRegisterMasks are ORed into register reads, unreadable bits read back as 1
+var apuRegisterMasks = [0x17]byte{
+	0x80, 0x3F, 0x00, 0xFF, 0xBF, // NR10-NR14
+	0xFF, 0x3F, 0x00, 0xFF, 0xBF, // NR20-NR24
+	0x7F, 0xFF, 0x9F, 0xFF, 0xBF, // NR30-NR34
+	0xFF, 0xFF, 0x00, 0x00, 0xBF, // NR40-NR44
+	0x00, 0x00, 0x70, // NR50-NR52
+}
+
+var squareDuties = [4][8]byte{
+	{0, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 1, 1, 1},
+	{0, 1, 1, 1, 1, 1, 1, 0},
+}
+
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+// envelopeType is the volume envelope shared by the square and noise channels
+type envelopeType struct {
+	initial  byte
+	increase bool
+	period   byte
+	timer    byte
+	volume   byte
+}
+
+func (envelope *envelopeType) write(value byte) {
+	envelope.initial = value >> 4
+	envelope.increase = value&0x08 != 0
+	envelope.period = value & 0x07
+}
+
+func (envelope *envelopeType) trigger() {
+	envelope.volume = envelope.initial
+	envelope.timer = envelope.period
+}
+
+func (envelope *envelopeType) clock() {
+	if envelope.period == 0 {
+		return
+	}
+	if envelope.timer > 0 {
+		envelope.timer--
+	}
+	if envelope.timer == 0 {
+		envelope.timer = envelope.period
+		if envelope.increase && envelope.volume < 15 {
+			envelope.volume++
+		} else if !envelope.increase && envelope.volume > 0 {
+			envelope.volume--
+		}
+	}
+}
+
+// lengthType is the length counter that silences a channel once it reaches zero
+type lengthType struct {
+	counter int
+	enabled bool
+}
+
+func (length *lengthType) clock(channelEnabled *bool) {
+	if length.enabled && length.counter > 0 {
+		length.counter--
+		if length.counter == 0 {
+			*channelEnabled = false
+		}
+	}
+}
+
+// SquareChannelType is a square wave channel, with a frequency sweep on channel 1
+type SquareChannelType struct {
+	Enabled    bool
+	dacEnabled bool
+	hasSweep   bool
+
+	Duty      byte
+	Frequency uint16
+	timer     int
+	position  byte
+	length    lengthType
+	Envelope  envelopeType
+
+	sweepPeriod  byte
+	sweepNegate  bool
+	sweepShift   byte
+	sweepTimer   byte
+	sweepShadow  uint16
+	sweepEnabled bool
+}
+
+func (channel *SquareChannelType) step(cycles int) {
+	channel.timer -= cycles
+	for channel.timer <= 0 {
+		channel.timer += (2048 - int(channel.Frequency)) * 4
+		channel.position = (channel.position + 1) & 0x07
+	}
+}
+
+// Output returns the channel's current 4-bit digital output
+func (channel *SquareChannelType) Output() byte {
+	if !channel.Enabled || !channel.dacEnabled {
+		return 0
+	}
+	return squareDuties[channel.Duty][channel.position] * channel.Envelope.volume
+}
+
+func (channel *SquareChannelType) trigger() {
+	channel.Enabled = channel.dacEnabled
+	if channel.length.counter == 0 {
+		channel.length.counter = 64
+	}
+	channel.timer = (2048 - int(channel.Frequency)) * 4
+	channel.Envelope.trigger()
+	if channel.hasSweep {
+		channel.sweepShadow = channel.Frequency
+		channel.reloadSweep()
+		channel.sweepEnabled = channel.sweepPeriod != 0 || channel.sweepShift != 0
+		if channel.sweepShift != 0 {
+			channel.sweepFrequency()
+		}
+	}
+}
+
+func (channel *SquareChannelType) reloadSweep() {
+	channel.sweepTimer = channel.sweepPeriod
+	if channel.sweepTimer == 0 {
+		channel.sweepTimer = 8
+	}
+}
+
+// sweepFrequency computes the next sweep frequency, disabling the channel on overflow
+func (channel *SquareChannelType) sweepFrequency() uint16 {
+	delta := channel.sweepShadow >> channel.sweepShift
+	frequency := channel.sweepShadow + delta
+	if channel.sweepNegate {
+		frequency = channel.sweepShadow - delta
+	}
+	if frequency > 2047 {
+		channel.Enabled = false
+	}
+	return frequency
+}
+
+func (channel *SquareChannelType) clockSweep() {
+	if channel.sweepTimer > 0 {
+		channel.sweepTimer--
+	}
+	if channel.sweepTimer != 0 {
+		return
+	}
+	channel.reloadSweep()
+	if !channel.sweepEnabled || channel.sweepPeriod == 0 {
+		return
+	}
+	frequency := channel.sweepFrequency()
+	if frequency <= 2047 && channel.sweepShift != 0 {
+		channel.sweepShadow = frequency
+		channel.Frequency = frequency
+		channel.sweepFrequency()
+	}
+}
+
+// WaveChannelType is the channel playing 32 4-bit samples from wave RAM
+type WaveChannelType struct {
+	Enabled    bool
+	dacEnabled bool
+
+	VolumeCode byte
+	Frequency  uint16
+	timer      int
+	position   byte
+	sample     byte
+	length     lengthType
+	RAM        [16]byte
+}
+
+func (channel *WaveChannelType) step(cycles int) {
+	channel.timer -= cycles
+	for channel.timer <= 0 {
+		channel.timer += (2048 - int(channel.Frequency)) * 2
+		channel.position = (channel.position + 1) & 0x1F
+		channel.sample = channel.RAM[channel.position/2]
+		if channel.position&0x01 == 0 {
+			channel.sample >>= 4
+		}
+		channel.sample &= 0x0F
+	}
+}
+
+// Output returns the channel's current 4-bit digital output
+func (channel *WaveChannelType) Output() byte {
+	if !channel.Enabled || !channel.dacEnabled || channel.VolumeCode == 0 {
+		return 0
+	}
+	return channel.sample >> (channel.VolumeCode - 1)
+}
+
+func (channel *WaveChannelType) trigger() {
+	channel.Enabled = channel.dacEnabled
+	if channel.length.counter == 0 {
+		channel.length.counter = 256
+	}
+	channel.timer = (2048 - int(channel.Frequency)) * 2
+	channel.position = 0
+}
+
+// NoiseChannelType is the channel producing noise from a linear feedback shift register
+type NoiseChannelType struct {
+	Enabled    bool
+	dacEnabled bool
+
+	Shift     byte
+	WidthMode bool
+	Divisor   byte
+	timer     int
+	lfsr      uint16
+	length    lengthType
+	Envelope  envelopeType
+}
+
+func (channel *NoiseChannelType) period() int {
+	return noiseDivisors[channel.Divisor] << channel.Shift
+}
+
+func (channel *NoiseChannelType) step(cycles int) {
+	channel.timer -= cycles
+	for channel.timer <= 0 {
+		channel.timer += channel.period()
+		bit := (channel.lfsr ^ channel.lfsr>>1) & 0x01
+		channel.lfsr = channel.lfsr>>1 | bit<<14
+		if channel.WidthMode {
+			channel.lfsr = channel.lfsr&^0x40 | bit<<6
+		}
+	}
+}
+
+// Output returns the channel's current 4-bit digital output
+func (channel *NoiseChannelType) Output() byte {
+	if !channel.Enabled || !channel.dacEnabled || channel.lfsr&0x01 != 0 {
+		return 0
+	}
+	return channel.Envelope.volume
+}
+
+func (channel *NoiseChannelType) trigger() {
+	channel.Enabled = channel.dacEnabled
+	if channel.length.counter == 0 {
+		channel.length.counter = 64
+	}
+	channel.timer = channel.period()
+	channel.lfsr = 0x7FFF
+	channel.Envelope.trigger()
+}
+
+// APUType is the structure to define what's inside the audio processing unit
+//
+//	APU Structure
+//	================
+//	---> Square 1 (with sweep), Square 2, Wave and Noise channels
+//	---> Frame sequencer clocking length, envelope and sweep at 512Hz
+//	---> NR50/NR51 master volume and panning
+//	---> Per channel mute and solo, and an oscilloscope history for the debugger
+//	---> Buffer of interleaved stereo samples at SampleRate, or a Sink they are sent to
+//	================
+type APUType struct {
+	Enabled bool
+
+	Square1 SquareChannelType
+	Square2 SquareChannelType
+	Wave    WaveChannelType
+	Noise   NoiseChannelType
+
+	registers      [0x17]byte
+	sequencerTimer int
+	sequencerStep  byte
+
+	// SampleRate is the number of stereo samples produced per second
+	SampleRate  int
+	sampleTimer int
+
+	// capacitor is the charge of the left and right high-pass capacitors,
+	// charge how much of it is kept per sample at chargeRate
+	capacitor  [2]float64
+	charge     float64
+	chargeRate int
+
+	// Sink receives the samples in chunks when set, otherwise they wait for ReadSamples
+	Sink SampleSink
+
+	// Muted and Solo remove channels from the mix, any soloed channel silences the rest
+	Muted [4]bool
+	Solo  [4]bool
+
+	// samplesLock guards samples and the oscilloscope history
+	samples     []int16
+	scope       [4][APUScopeLength]byte
+	scopeIndex  int
+	samplesLock sync.Mutex
+}
+
+// APU is the exported object used in the system
+//
+// APU is exported to become a shared variable in the System object
+var APU = APUType{
+	Square1:    SquareChannelType{hasSweep: true},
+	SampleRate: 44100,
+}
+
+// Reset powers the APU up with its registers cleared
+func (apu *APUType) Reset() {
+	apu.power(false)
+	apu.power(true)
+	apu.samplesLock.Lock()
+	apu.samples = apu.samples[:0]
+	apu.samplesLock.Unlock()
+	apu.capacitor = [2]float64{}
+}
+
+func (apu *APUType) power(on bool) {
+	if !on {
+		waveRAM := apu.Wave.RAM
+		apu.Square1 = SquareChannelType{hasSweep: true}
+		apu.Square2 = SquareChannelType{}
+		apu.Wave = WaveChannelType{RAM: waveRAM}
+		apu.Noise = NoiseChannelType{}
+		apu.registers = [0x17]byte{}
+	}
+	apu.Enabled = on
+	apu.sequencerStep = 0
+	apu.sequencerTimer = apuFrameSequencerPeriod
+}
+
+// Step advances the APU by the given number of CPU cycles,
+// producing a stereo sample every ClockSpeed/SampleRate cycles
+func (apu *APUType) Step(cycles int) {
+	if apu.Enabled {
+		apu.Square1.step(cycles)
+		apu.Square2.step(cycles)
+		apu.Wave.step(cycles)
+		apu.Noise.step(cycles)
+
+		apu.sequencerTimer -= cycles
+		for apu.sequencerTimer <= 0 {
+			apu.sequencerTimer += apuFrameSequencerPeriod
+			apu.clockSequencer()
+		}
+	}
+
+	apu.sampleTimer += cycles * apu.SampleRate
+	for apu.sampleTimer >= ClockSpeed {
+		apu.sampleTimer -= ClockSpeed
+		outputs := apu.Outputs()
+		left, right := apu.highPass(apu.mix(outputs))
+		apu.samplesLock.Lock()
+		if apu.Sink != nil || len(apu.samples) < apuMaxBufferedSamples {
+			apu.samples = append(apu.samples, left, right)
+		}
+		for i, output := range outputs {
+			apu.scope[i][apu.scopeIndex] = output
+		}
+		apu.scopeIndex = (apu.scopeIndex + 1) % APUScopeLength
+		apu.samplesLock.Unlock()
+	}
+
+	if apu.Sink != nil && len(apu.samples) >= apuSinkChunk {
+		apu.samplesLock.Lock()
+		chunk := apu.samples
+		apu.samples = make([]int16, 0, apuSinkChunk)
+		apu.samplesLock.Unlock()
+		apu.Sink.WriteSamples(chunk)
+	}
+}
+
+func (apu *APUType) clockSequencer() {
+	if apu.sequencerStep%2 == 0 {
+		apu.Square1.length.clock(&apu.Square1.Enabled)
+		apu.Square2.length.clock(&apu.Square2.Enabled)
+		apu.Wave.length.clock(&apu.Wave.Enabled)
+		apu.Noise.length.clock(&apu.Noise.Enabled)
+	}
+	if apu.sequencerStep == 2 || apu.sequencerStep == 6 {
+		apu.Square1.clockSweep()
+	}
+	if apu.sequencerStep == 7 {
+		apu.Square1.Envelope.clock()
+		apu.Square2.Envelope.clock()
+		apu.Noise.Envelope.clock()
+	}
+	apu.sequencerStep = (apu.sequencerStep + 1) & 0x07
+}
+
+// Outputs returns the 4-bit digital output of each channel, in channel order
+func (apu *APUType) Outputs() [4]byte {
+	return [4]byte{apu.Square1.Output(), apu.Square2.Output(), apu.Wave.Output(), apu.Noise.Output()}
+}
+
+// dacs returns whether each channel's DAC is powered, in channel order
+func (apu *APUType) dacs() [4]bool {
+	return [4]bool{apu.Square1.dacEnabled, apu.Square2.dacEnabled, apu.Wave.dacEnabled, apu.Noise.dacEnabled}
+}
+
+// Audible reports whether a channel is heard, taking Muted and Solo into account
+func (apu *APUType) Audible(channel int) bool {
+	if apu.Muted[channel] {
+		return false
+	}
+	for _, solo := range apu.Solo {
+		if solo {
+			return apu.Solo[channel]
+		}
+	}
+	return true
+}
+
+// Mix combines the channel outputs through NR51 panning and NR50 volume into a stereo sample
+func (apu *APUType) Mix() (int16, int16) {
+	left, right := apu.mix(apu.Outputs())
+	return clipSample(left), clipSample(right)
+}
+
+// mix returns the left and right analog levels from -1.0 to 1.0
+func (apu *APUType) mix(outputs [4]byte) (float64, float64) {
+	if !apu.Enabled {
+		return 0, 0
+	}
+	var left, right float64
+	panning := apu.registers[0x15]
+	dacs := apu.dacs()
+	for i, output := range outputs {
+		if !dacs[i] || !apu.Audible(i) {
+			continue
+		}
+		// each DAC maps 0-15 onto -1.0 to 1.0
+		analog := float64(output)/7.5 - 1.0
+		if panning&(0x10<<uint(i)) != 0 {
+			left += analog
+		}
+		if panning&(0x01<<uint(i)) != 0 {
+			right += analog
+		}
+	}
+	volume := apu.registers[0x14]
+	left *= float64((volume>>4)&0x07+1) / 8
+	right *= float64(volume&0x07+1) / 8
+	// four channels at full volume span -4.0 to 4.0
+	return left / 4, right / 4
+}
+
+// highPass removes the DC offset from the mix like the capacitors on the
+// hardware's outputs, so DACs left on with nothing playing fade to silence
+// instead of holding the output at one end of its range
+func (apu *APUType) highPass(left, right float64) (int16, int16) {
+	dacs := apu.dacs()
+	if !apu.Enabled || !(dacs[0] || dacs[1] || dacs[2] || dacs[3]) {
+		return 0, 0
+	}
+	if apu.chargeRate != apu.SampleRate {
+		// the capacitor keeps 0.999958 of its charge every CPU cycle
+		apu.chargeRate = apu.SampleRate
+		apu.charge = math.Pow(0.999958, float64(ClockSpeed)/float64(apu.SampleRate))
+	}
+	levels := [2]float64{left, right}
+	for i, level := range levels {
+		levels[i] = level - apu.capacitor[i]
+		apu.capacitor[i] = level - levels[i]*apu.charge
+	}
+	return clipSample(levels[0]), clipSample(levels[1])
+}
+
+// clipSample converts an analog level to a 16-bit sample, clipping outside -1.0 to 1.0
+func clipSample(level float64) int16 {
+	return int16(math.Max(-1, math.Min(1, level)) * 32767)
+}
+
+// Scope returns a channel's recent outputs for the oscilloscope, oldest first
+func (apu *APUType) Scope(channel int) []byte {
+	apu.samplesLock.Lock()
+	defer apu.samplesLock.Unlock()
+	history := make([]byte, 0, APUScopeLength)
+	history = append(history, apu.scope[channel][apu.scopeIndex:]...)
+	return append(history, apu.scope[channel][:apu.scopeIndex]...)
+}
+
+// APUChannelInfoType is a channel's NRxx registers decoded for the Audio debug window
+type APUChannelInfoType struct {
+	Name          string
+	Registers     [5]byte
+	Enabled       bool
+	Duty          string
+	Frequency     float64
+	Volume        byte
+	Envelope      string
+	Length        int
+	LengthEnabled bool
+	Left          bool
+	Right         bool
+}
+
+func describeEnvelope(envelope envelopeType) string {
+	if envelope.period == 0 {
+		return "off"
+	}
+	if envelope.increase {
+		return "up"
+	}
+	return "down"
+}
+
+// ChannelInfo decodes the registers and state of a channel, in channel order.
+// It reads live APU state, so the UI calls it inside CPU.Call
+func (apu *APUType) ChannelInfo(channel int) APUChannelInfoType {
+	info := APUChannelInfoType{
+		Name:  APUChannelNames[channel],
+		Left:  apu.registers[0x15]&(0x10<<uint(channel)) != 0,
+		Right: apu.registers[0x15]&(0x01<<uint(channel)) != 0,
+	}
+	copy(info.Registers[:], apu.registers[channel*5:channel*5+5])
+
+	switch channel {
+	case 0, 1:
+		square := &apu.Square1
+		if channel == 1 {
+			square = &apu.Square2
+		}
+		info.Enabled = square.Enabled
+		info.Duty = squareDutyNames[square.Duty]
+		info.Frequency = ClockSpeed / float64((2048-int(square.Frequency))*4*8)
+		info.Volume = square.Envelope.volume
+		info.Envelope = describeEnvelope(square.Envelope)
+		info.Length = square.length.counter
+		info.LengthEnabled = square.length.enabled
+	case 2:
+		info.Enabled = apu.Wave.Enabled
+		info.Frequency = ClockSpeed / float64((2048-int(apu.Wave.Frequency))*2*32)
+		if apu.Wave.VolumeCode != 0 {
+			info.Volume = 15 >> (apu.Wave.VolumeCode - 1)
+		}
+		info.Envelope = "off"
+		info.Length = apu.Wave.length.counter
+		info.LengthEnabled = apu.Wave.length.enabled
+	case 3:
+		info.Enabled = apu.Noise.Enabled
+		if apu.Noise.WidthMode {
+			info.Duty = "7-bit"
+		} else {
+			info.Duty = "15-bit"
+		}
+		info.Frequency = ClockSpeed / float64(apu.Noise.period())
+		info.Volume = apu.Noise.Envelope.volume
+		info.Envelope = describeEnvelope(apu.Noise.Envelope)
+		info.Length = apu.Noise.length.counter
+		info.LengthEnabled = apu.Noise.length.enabled
+	}
+	return info
+}
+
+// ReadSamples moves the buffered interleaved stereo samples into buffer,
+// returning the number of int16 values copied
+func (apu *APUType) ReadSamples(buffer []int16) int {
+	apu.samplesLock.Lock()
+	defer apu.samplesLock.Unlock()
+	n := copy(buffer, apu.samples)
+	apu.samples = apu.samples[:copy(apu.samples, apu.samples[n:])]
+	return n
+}
+
+// ReadRegister returns a sound register (0xFF10-0xFF26) or wave RAM (0xFF30-0xFF3F) value
+func (apu *APUType) ReadRegister(address uint16) byte {
+	if address >= 0xFF30 {
+		return apu.Wave.RAM[address-0xFF30]
+	}
+	if address > 0xFF26 {
+		return 0xFF
+	}
+	index := address - 0xFF10
+	value := apu.registers[index] | apuRegisterMasks[index]
+	if address == 0xFF26 {
+		value = 0x70
+		if apu.Enabled {
+			value |= 0x80
+		}
+		for i, enabled := range []bool{apu.Square1.Enabled, apu.Square2.Enabled, apu.Wave.Enabled, apu.Noise.Enabled} {
+			if enabled {
+				value |= 1 << uint(i)
+			}
+		}
+	}
+	return value
+}
+
+// WriteRegister stores a sound register (0xFF10-0xFF26) or wave RAM (0xFF30-0xFF3F) value
+func (apu *APUType) WriteRegister(address uint16, value byte) {
+	if address >= 0xFF30 {
+		apu.Wave.RAM[address-0xFF30] = value
+		return
+	}
+	if address > 0xFF26 {
+		return
+	}
+	if address == 0xFF26 {
+		if on := value&0x80 != 0; on != apu.Enabled {
+			apu.power(on)
+		}
+		return
+	}
+	if !apu.Enabled {
+		// on the DMG only the length counters can be written while powered off
+		switch address {
+		case 0xFF11:
+			apu.Square1.length.counter = 64 - int(value&0x3F)
+		case 0xFF16:
+			apu.Square2.length.counter = 64 - int(value&0x3F)
+		case 0xFF1B:
+			apu.Wave.length.counter = 256 - int(value)
+		case 0xFF20:
+			apu.Noise.length.counter = 64 - int(value&0x3F)
+		}
+		return
+	}
+	apu.registers[address-0xFF10] = value
+
+	switch address {
+	case 0xFF10:
+		apu.Square1.sweepPeriod = (value >> 4) & 0x07
+		apu.Square1.sweepNegate = value&0x08 != 0
+		apu.Square1.sweepShift = value & 0x07
+	case 0xFF11:
+		apu.Square1.Duty = value >> 6
+		apu.Square1.length.counter = 64 - int(value&0x3F)
+	case 0xFF12:
+		apu.Square1.Envelope.write(value)
+		apu.Square1.dacEnabled = value&0xF8 != 0
+		apu.Square1.Enabled = apu.Square1.Enabled && apu.Square1.dacEnabled
+	case 0xFF13:
+		apu.Square1.Frequency = apu.Square1.Frequency&0x0700 | uint16(value)
+	case 0xFF14:
+		apu.Square1.Frequency = apu.Square1.Frequency&0x00FF | uint16(value&0x07)<<8
+		apu.Square1.length.enabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			apu.Square1.trigger()
+		}
+	case 0xFF16:
+		apu.Square2.Duty = value >> 6
+		apu.Square2.length.counter = 64 - int(value&0x3F)
+	case 0xFF17:
+		apu.Square2.Envelope.write(value)
+		apu.Square2.dacEnabled = value&0xF8 != 0
+		apu.Square2.Enabled = apu.Square2.Enabled && apu.Square2.dacEnabled
+	case 0xFF18:
+		apu.Square2.Frequency = apu.Square2.Frequency&0x0700 | uint16(value)
+	case 0xFF19:
+		apu.Square2.Frequency = apu.Square2.Frequency&0x00FF | uint16(value&0x07)<<8
+		apu.Square2.length.enabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			apu.Square2.trigger()
+		}
+	case 0xFF1A:
+		apu.Wave.dacEnabled = value&0x80 != 0
+		apu.Wave.Enabled = apu.Wave.Enabled && apu.Wave.dacEnabled
+	case 0xFF1B:
+		apu.Wave.length.counter = 256 - int(value)
+	case 0xFF1C:
+		apu.Wave.VolumeCode = (value >> 5) & 0x03
+	case 0xFF1D:
+		apu.Wave.Frequency = apu.Wave.Frequency&0x0700 | uint16(value)
+	case 0xFF1E:
+		apu.Wave.Frequency = apu.Wave.Frequency&0x00FF | uint16(value&0x07)<<8
+		apu.Wave.length.enabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			apu.Wave.trigger()
+		}
+	case 0xFF20:
+		apu.Noise.length.counter = 64 - int(value&0x3F)
+	case 0xFF21:
+		apu.Noise.Envelope.write(value)
+		apu.Noise.dacEnabled = value&0xF8 != 0
+		apu.Noise.Enabled = apu.Noise.Enabled && apu.Noise.dacEnabled
+	case 0xFF22:
+		apu.Noise.Shift = value >> 4
+		apu.Noise.WidthMode = value&0x08 != 0
+		apu.Noise.Divisor = value & 0x07
+	case 0xFF23:
+		apu.Noise.length.enabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			apu.Noise.trigger()
+		}
+	}
+}
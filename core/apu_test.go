@@ -0,0 +1,158 @@
+package core
+
+import "testing"
+
+// testAPU returns a powered up APU of its own, leaving the shared APU alone
+func testAPU() *APUType {
+	apu := &APUType{Square1: SquareChannelType{hasSweep: true}, SampleRate: 44100}
+	apu.Reset()
+	return apu
+}
+
+// writeAPU writes sound registers in order
+func writeAPU(apu *APUType, registers ...ioRegisterType) {
+	for _, register := range registers {
+		apu.WriteRegister(register.address, register.value)
+	}
+}
+
+// TestAPUSweep triggers square 1 with a sweep and clocks the frame sequencer
+// to its first sweep step, expecting the channel disabled once the next
+// frequency would overflow 2047
+func TestAPUSweep(t *testing.T) {
+	tests := []struct {
+		name      string
+		sweep     byte
+		frequency uint16
+		enabled   bool
+		swept     uint16
+	}{
+		// 1792 + 896 overflows when triggered
+		{"overflow on trigger", 0x11, 1792, false, 1792},
+		// 1280 + 640 = 1920 is applied, the check of 1920 + 960 overflows
+		{"overflow after a step", 0x11, 1280, false, 1920},
+		{"increase", 0x12, 1024, true, 1280},
+		{"decrease", 0x19, 1280, true, 640},
+		// shift 0 checks 512 + 512 for overflow without changing the frequency
+		{"no shift", 0x10, 512, true, 512},
+		{"no shift overflow", 0x10, 1792, false, 1792},
+	}
+	for _, test := range tests {
+		apu := testAPU()
+		writeAPU(apu,
+			ioRegisterType{0xFF10, test.sweep},
+			ioRegisterType{0xFF12, 0xF0},
+			ioRegisterType{0xFF13, byte(test.frequency)},
+			ioRegisterType{0xFF14, 0x80 | byte(test.frequency>>8)})
+		// the sweep is clocked on the third frame sequencer step
+		apu.Step(apuFrameSequencerPeriod * 3)
+		if enabled := apu.ReadRegister(0xFF26)&0x01 != 0; enabled != test.enabled {
+			t.Errorf("APU: %s: square 1 enabled %v, expected %v", test.name, enabled, test.enabled)
+		}
+		if apu.Square1.Frequency != test.swept {
+			t.Errorf("APU: %s: frequency %d, expected %d", test.name, apu.Square1.Frequency, test.swept)
+		}
+	}
+}
+
+// TestAPULength expects a channel silenced when its length counter runs out,
+// only while NRx4 bit 6 enables the counter
+func TestAPULength(t *testing.T) {
+	tests := []struct {
+		name      string
+		registers []ioRegisterType
+		bit       byte
+		enabled   bool
+	}{
+		{"square 2", []ioRegisterType{{0xFF17, 0xF0}, {0xFF16, 0x3F}, {0xFF19, 0xC0}}, 0x02, false},
+		{"square 2 length disabled", []ioRegisterType{{0xFF17, 0xF0}, {0xFF16, 0x3F}, {0xFF19, 0x80}}, 0x02, true},
+		{"wave", []ioRegisterType{{0xFF1A, 0x80}, {0xFF1B, 0xFF}, {0xFF1E, 0xC0}}, 0x04, false},
+		{"wave length disabled", []ioRegisterType{{0xFF1A, 0x80}, {0xFF1B, 0xFF}, {0xFF1E, 0x80}}, 0x04, true},
+		{"noise", []ioRegisterType{{0xFF21, 0xF0}, {0xFF20, 0x3F}, {0xFF23, 0xC0}}, 0x08, false},
+		{"noise length disabled", []ioRegisterType{{0xFF21, 0xF0}, {0xFF20, 0x3F}, {0xFF23, 0x80}}, 0x08, true},
+	}
+	for _, test := range tests {
+		apu := testAPU()
+		writeAPU(apu, test.registers...)
+		if apu.ReadRegister(0xFF26)&test.bit == 0 {
+			t.Errorf("APU: %s: not enabled by the trigger", test.name)
+		}
+		// a length of 1 runs out on the first length clock
+		apu.Step(apuFrameSequencerPeriod)
+		if enabled := apu.ReadRegister(0xFF26)&test.bit != 0; enabled != test.enabled {
+			t.Errorf("APU: %s: enabled %v after a length clock, expected %v", test.name, enabled, test.enabled)
+		}
+	}
+}
+
+// TestAPUNoiseWidth steps the noise LFSR, expecting the 7-bit mode to repeat
+// every 127 steps and the 15-bit mode not to
+func TestAPUNoiseWidth(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		polynomial byte
+		repeats    bool
+	}{
+		{"7-bit", 0x08, true},
+		{"15-bit", 0x00, false},
+	} {
+		apu := testAPU()
+		writeAPU(apu,
+			ioRegisterType{0xFF21, 0xF0},
+			ioRegisterType{0xFF22, test.polynomial},
+			ioRegisterType{0xFF23, 0x80})
+		period := apu.Noise.period()
+		var outputs []byte
+		for i := 0; i < 1000; i++ {
+			apu.Step(period)
+			outputs = append(outputs, apu.Noise.Output())
+		}
+		repeats := true
+		for i := 200; i+127 < len(outputs); i++ {
+			repeats = repeats && outputs[i] == outputs[i+127]
+		}
+		if repeats != test.repeats {
+			t.Errorf("APU: %s noise repeats every 127 steps: %v, expected %v", test.name, repeats, test.repeats)
+		}
+	}
+}
+
+// TestAPUHighPass leaves square 1's DAC on at volume 0, which outputs the
+// bottom of its range, expecting the high-pass filter to pull the samples back
+// to silence within a second and the output to stay silent once every DAC is off
+func TestAPUHighPass(t *testing.T) {
+	apu := testAPU()
+	writeAPU(apu,
+		ioRegisterType{0xFF24, 0x77},
+		ioRegisterType{0xFF25, 0x11},
+		ioRegisterType{0xFF12, 0x08},
+		ioRegisterType{0xFF14, 0x80})
+	if left, _ := apu.Mix(); left >= 0 {
+		t.Fatalf("APU: silent DAC mixed to %d, expected a negative offset", left)
+	}
+
+	// last steps the APU and returns the last stereo sample it produced
+	last := func(cycles int) (left, right int16) {
+		buffer := make([]int16, 256)
+		for ; cycles > 0; cycles -= 1024 {
+			apu.Step(1024)
+			if n := apu.ReadSamples(buffer); n >= 2 {
+				left, right = buffer[n-2], buffer[n-1]
+			}
+		}
+		return left, right
+	}
+	first, _ := last(1024)
+	left, right := last(ClockSpeed)
+	if first >= 0 || left < first/100 || left > 0 {
+		t.Errorf("APU: left sample %d after a second from %d, expected it to decay to about 0", left, first)
+	}
+	if right != 0 {
+		t.Errorf("APU: right sample %d with square 1 panned left, expected 0", right)
+	}
+
+	writeAPU(apu, ioRegisterType{0xFF12, 0x00})
+	if left, right := last(1024); left != 0 || right != 0 {
+		t.Errorf("APU: sample %d,%d with every DAC off, expected silence", left, right)
+	}
+}
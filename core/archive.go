@@ -0,0 +1,154 @@
+package core
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ROMExtensions are the file extensions of Game Boy ROM images
+var ROMExtensions = []string{".gb", ".gbc", ".sgb"}
+
+// ArchiveExtensions are the archive formats ROMs are loaded from
+var ArchiveExtensions = []string{".zip", ".gz"}
+
+// maxROMFileSize is the largest ROM a cartridge header can declare, 8MB,
+// bounding how much is decompressed from an archive
+const maxROMFileSize = 8 * 1024 * 1024
+
+// Errors returned when reading a ROM out of an archive
+var (
+	ErrNoROMInArchive      = errors.New("archive contains no Game Boy ROM")
+	ErrUnsupportedArchive  = errors.New("unsupported archive format")
+	ErrArchiveFileTooLarge = errors.New("archived file is larger than any Game Boy ROM")
+)
+
+func hasExtension(name string, extensions []string) bool {
+	extension := strings.ToLower(path.Ext(name))
+	for _, e := range extensions {
+		if extension == e {
+			return true
+		}
+	}
+	return false
+}
+
+// IsArchive reports whether location is loaded through an archive, by its extension
+func IsArchive(location string) bool {
+	return hasExtension(location, ArchiveExtensions) || strings.ToLower(path.Ext(location)) == ".7z"
+}
+
+// ArchiveROMs lists the ROMs in an archive, sorted by name.
+// A gzip file holds a single ROM, named after the file without .gz.
+func ArchiveROMs(location string) ([]string, error) {
+	switch strings.ToLower(path.Ext(location)) {
+	case ".zip":
+		archive, err := zip.OpenReader(location)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		var names []string
+		for _, file := range archive.File {
+			if !file.FileInfo().IsDir() && hasExtension(file.Name, ROMExtensions) {
+				names = append(names, file.Name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, ErrNoROMInArchive
+		}
+		sort.Strings(names)
+		return names, nil
+	case ".gz":
+		return []string{strings.TrimSuffix(path.Base(location), path.Ext(location))}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedArchive, path.Ext(location))
+}
+
+// ReadROMFile reads a ROM image from a file or an archive.
+// For a zip archive entry names the ROM to read, "" reads the first one.
+func ReadROMFile(location string, entry string) ([]byte, error) {
+	switch strings.ToLower(path.Ext(location)) {
+	case ".zip":
+		return readZipROM(location, entry)
+	case ".gz":
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(&limitedReader{reader: reader, remaining: maxROMFileSize})
+	case ".7z":
+		// no 7-Zip decoder is available in the standard library
+		return nil, fmt.Errorf("%w: 7-Zip archives cannot be read, extract the ROM first", ErrUnsupportedArchive)
+	}
+	return ioutil.ReadFile(location)
+}
+
+func readZipROM(location string, entry string) ([]byte, error) {
+	if entry == "" {
+		names, err := ArchiveROMs(location)
+		if err != nil {
+			return nil, err
+		}
+		entry = names[0]
+	}
+	archive, err := zip.OpenReader(location)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		if file.Name != entry {
+			continue
+		}
+		if file.UncompressedSize64 > maxROMFileSize {
+			return nil, ErrArchiveFileTooLarge
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(&limitedReader{reader: reader, remaining: maxROMFileSize})
+	}
+	return nil, fmt.Errorf("%w: %s not found", ErrNoROMInArchive, entry)
+}
+
+// limitedReader fails once more than remaining bytes are read,
+// so a corrupt or malicious archive cannot decompress without bound
+type limitedReader struct {
+	reader interface {
+		Read([]byte) (int, error)
+	}
+	remaining int
+}
+
+func (limited *limitedReader) Read(p []byte) (int, error) {
+	if limited.remaining <= 0 {
+		// only the end of the stream is allowed past the limit
+		var probe [1]byte
+		n, err := limited.reader.Read(probe[:])
+		if n > 0 {
+			return 0, ErrArchiveFileTooLarge
+		}
+		return 0, err
+	}
+	if len(p) > limited.remaining {
+		p = p[:limited.remaining]
+	}
+	n, err := limited.reader.Read(p)
+	limited.remaining -= n
+	return n, err
+}
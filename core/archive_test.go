@@ -0,0 +1,137 @@
+package core
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeZip creates a zip archive at location holding files, directories end in /
+func writeZip(t *testing.T, location string, files map[string][]byte) {
+	file, err := os.Create(location)
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	for name, data := range files {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Archive: %v", err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			t.Fatalf("Archive: %v", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+}
+
+// TestArchiveZip expects only ROMs to be listed, in name order, the first to
+// load by default and any other to load by its entry name
+func TestArchiveZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := testROM([]byte{0x01})
+	second := testROM([]byte{0x02})
+	location := filepath.Join(dir, "roms.zip")
+	writeZip(t, location, map[string][]byte{
+		"readme.txt":     []byte("not a ROM"),
+		"games/":         nil,
+		"games/b.GB":     second,
+		"games/a.gbc":    first,
+		"games/c.gb.txt": []byte("not a ROM either"),
+	})
+
+	names, err := ArchiveROMs(location)
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	if len(names) != 2 || names[0] != "games/a.gbc" || names[1] != "games/b.GB" {
+		t.Errorf("Archive: lists %v, expected [games/a.gbc games/b.GB]", names)
+	}
+
+	for _, test := range []struct {
+		entry    string
+		expected []byte
+	}{
+		{"", first},
+		{"games/a.gbc", first},
+		{"games/b.GB", second},
+	} {
+		data, err := ReadROMFile(location, test.entry)
+		if err != nil {
+			t.Errorf("Archive: reading %q: %v", test.entry, err)
+			continue
+		}
+		if string(data) != string(test.expected) {
+			t.Errorf("Archive: reading %q returned the wrong ROM", test.entry)
+		}
+	}
+	if err := ROM.Load(first); err != nil {
+		t.Errorf("Archive: the archived ROM does not load: %v", err)
+	}
+
+	if _, err := ReadROMFile(location, "games/missing.gb"); !errors.Is(err, ErrNoROMInArchive) {
+		t.Errorf("Archive: reading a missing entry returned %v, expected ErrNoROMInArchive", err)
+	}
+}
+
+// TestArchiveEmpty expects archives holding no ROM to be refused
+func TestArchiveEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, files := range map[string]map[string][]byte{
+		"empty.zip":   {},
+		"no-roms.zip": {"readme.txt": []byte("not a ROM")},
+	} {
+		location := filepath.Join(dir, name)
+		writeZip(t, location, files)
+		if _, err := ArchiveROMs(location); !errors.Is(err, ErrNoROMInArchive) {
+			t.Errorf("Archive: listing %s returned %v, expected ErrNoROMInArchive", name, err)
+		}
+		if _, err := ReadROMFile(location, ""); !errors.Is(err, ErrNoROMInArchive) {
+			t.Errorf("Archive: reading %s returned %v, expected ErrNoROMInArchive", name, err)
+		}
+	}
+}
+
+// TestArchiveGzip expects a gzip file to hold the one ROM named after it
+func TestArchiveGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rom := testROM([]byte{0x03})
+	location := filepath.Join(dir, "game.gb.gz")
+	file, err := os.Create(location)
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	writer := gzip.NewWriter(file)
+	writer.Write(rom)
+	writer.Close()
+	file.Close()
+
+	if names, err := ArchiveROMs(location); err != nil || len(names) != 1 || names[0] != "game.gb" {
+		t.Errorf("Archive: lists %v, %v, expected [game.gb]", names, err)
+	}
+	if data, err := ReadROMFile(location, ""); err != nil || string(data) != string(rom) {
+		t.Errorf("Archive: reading the gzip ROM failed: %v", err)
+	}
+}
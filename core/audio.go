@@ -0,0 +1,245 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+// audioBufferFrames is the ring buffer size in stereo frames, about 90ms at 44.1kHz
+const audioBufferFrames = 4096
+
+// audioMaxRateDelta is the largest fraction the APU sample rate is nudged by to keep the buffer half full
+const audioMaxRateDelta = 0.005
+
+// SampleSink receives interleaved stereo samples from the APU
+type SampleSink interface {
+	WriteSamples(samples []int16)
+}
+
+// AudioBackend plays interleaved signed 16-bit stereo samples on a sound device.
+// Write blocks until the device has accepted the samples.
+type AudioBackend interface {
+	Name() string
+	Open(rate int) error
+	Write(samples []int16) error
+	Close() error
+}
+
+// RingBufferType is a fixed size sample queue between the emulation and the audio device.
+// Writes block while it is full, which is what locks emulation speed to audio.
+type RingBufferType struct {
+	data   []int16
+	head   int
+	count  int
+	closed bool
+	lock   sync.Mutex
+	cond   *sync.Cond
+}
+
+// NewRingBuffer creates a ring buffer holding size samples
+func NewRingBuffer(size int) *RingBufferType {
+	ring := &RingBufferType{data: make([]int16, size)}
+	ring.cond = sync.NewCond(&ring.lock)
+	return ring
+}
+
+// Write queues all samples, blocking while the buffer is full until it is closed
+func (ring *RingBufferType) Write(samples []int16) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	for len(samples) > 0 && !ring.closed {
+		for ring.count == len(ring.data) && !ring.closed {
+			ring.cond.Wait()
+		}
+		for len(samples) > 0 && ring.count < len(ring.data) {
+			ring.data[(ring.head+ring.count)%len(ring.data)] = samples[0]
+			samples = samples[1:]
+			ring.count++
+		}
+		ring.cond.Broadcast()
+	}
+}
+
+// Read moves up to len(buffer) samples out of the ring, blocking until some are queued.
+// It returns 0 once the buffer is closed and empty.
+func (ring *RingBufferType) Read(buffer []int16) int {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	for ring.count == 0 && !ring.closed {
+		ring.cond.Wait()
+	}
+	n := 0
+	for n < len(buffer) && ring.count > 0 {
+		buffer[n] = ring.data[ring.head]
+		ring.head = (ring.head + 1) % len(ring.data)
+		ring.count--
+		n++
+	}
+	ring.cond.Broadcast()
+	return n
+}
+
+// Fill returns how full the buffer is, from 0.0 to 1.0
+func (ring *RingBufferType) Fill() float64 {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	return float64(ring.count) / float64(len(ring.data))
+}
+
+// Close wakes any blocked readers and writers, later writes are dropped
+func (ring *RingBufferType) Close() {
+	ring.lock.Lock()
+	ring.closed = true
+	ring.cond.Broadcast()
+	ring.lock.Unlock()
+}
+
+// AudioOutputType connects the APU to an audio backend through a ring buffer,
+// applying the volume and nudging the APU sample rate so the buffer neither
+// underruns (crackles) nor stays full (latency)
+type AudioOutputType struct {
+	Backend AudioBackend
+
+	volume  int  // guarded by lock, set from the UI and read by WriteSamples
+	muted   bool // guarded by lock
+	rate    int
+	ring    *RingBufferType
+	done    chan bool
+	running bool
+	lock    sync.Mutex
+}
+
+// Audio is the exported object used in the system
+//
+// Audio is exported so the UI can change the volume and mute the output
+var Audio = AudioOutputType{
+	volume: 100,
+}
+
+// Start opens the first available audio backend and attaches the output to the APU
+func (audio *AudioOutputType) Start() error {
+	audio.lock.Lock()
+	defer audio.lock.Unlock()
+	if audio.running {
+		return nil
+	}
+	audio.volume = Settings.Volume
+	audio.muted = Settings.Muted
+	audio.rate = Settings.SampleRate
+
+	var err = errors.New("no audio backend available")
+	for _, backend := range audioBackends() {
+		if err = backend.Open(audio.rate); err == nil {
+			audio.Backend = backend
+			break
+		}
+		Logger.Logf(LogTypes.WARNING, "AUDIO: %s unavailable: %v\n", backend.Name(), err)
+	}
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "AUDIO:", err)
+		return err
+	}
+	Logger.Logf(LogTypes.INFO, "AUDIO: playing through %s at %dHz\n", audio.Backend.Name(), audio.rate)
+
+	audio.ring = NewRingBuffer(audioBufferFrames * 2)
+	audio.done = make(chan bool)
+	audio.running = true
+	go audio.play(audio.ring, audio.Backend, audio.done)
+
+	APU.SampleRate = audio.rate
+	APU.Sink = audio
+	return nil
+}
+
+// Stop detaches the output from the APU and closes the backend
+func (audio *AudioOutputType) Stop() {
+	audio.lock.Lock()
+	defer audio.lock.Unlock()
+	if !audio.running {
+		return
+	}
+	audio.running = false
+	APU.Sink = nil
+	APU.SampleRate = Settings.SampleRate
+	audio.ring.Close()
+	<-audio.done
+	if err := audio.Backend.Close(); err != nil {
+		Logger.Log(LogTypes.ERROR, "AUDIO:", err)
+	}
+}
+
+// Running reports whether samples are being played
+func (audio *AudioOutputType) Running() bool {
+	audio.lock.Lock()
+	defer audio.lock.Unlock()
+	return audio.running
+}
+
+// WriteSamples applies the volume and queues samples for playback,
+// blocking while the buffer is full
+func (audio *AudioOutputType) WriteSamples(samples []int16) {
+	audio.lock.Lock()
+	volume := audio.volume
+	if audio.muted {
+		volume = 0
+	}
+	audio.lock.Unlock()
+	if volume != 100 {
+		for i, sample := range samples {
+			samples[i] = int16(int(sample) * volume / 100)
+		}
+	}
+	audio.ring.Write(samples)
+
+	// Produce slightly more samples per emulated second when the buffer drains, fewer when it fills
+	fill := audio.ring.Fill()
+	APU.SampleRate = int(float64(audio.rate) * (1 + audioMaxRateDelta*(1-2*fill)))
+}
+
+func (audio *AudioOutputType) play(ring *RingBufferType, backend AudioBackend, done chan bool) {
+	buffer := make([]int16, 1024)
+	for {
+		n := ring.Read(buffer)
+		if n == 0 {
+			break
+		}
+		if err := backend.Write(buffer[:n]); err != nil {
+			Logger.Log(LogTypes.ERROR, "AUDIO:", err)
+			break
+		}
+	}
+	// keep draining so the emulation never blocks on a dead device
+	for ring.Read(buffer) != 0 {
+	}
+	close(done)
+}
+
+// Volume returns the output volume from 0 to 100
+func (audio *AudioOutputType) Volume() int {
+	audio.lock.Lock()
+	defer audio.lock.Unlock()
+	return audio.volume
+}
+
+// SetVolume sets the output volume from 0 to 100 and saves it
+func (audio *AudioOutputType) SetVolume(volume int) {
+	if volume < 0 {
+		volume = 0
+	} else if volume > 100 {
+		volume = 100
+	}
+	audio.lock.Lock()
+	audio.volume = volume
+	audio.lock.Unlock()
+	Settings.Volume = volume
+	Settings.Save()
+}
+
+// SetMuted mutes or unmutes the output and saves it
+func (audio *AudioOutputType) SetMuted(muted bool) {
+	audio.lock.Lock()
+	audio.muted = muted
+	audio.lock.Unlock()
+	Settings.Muted = muted
+	Settings.Save()
+}
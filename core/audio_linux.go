@@ -0,0 +1,80 @@
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// processBackendType plays raw PCM by piping it into a command line player
+type processBackendType struct {
+	name    string
+	command func(rate int) *exec.Cmd
+	cmd     *exec.Cmd
+	stdin   *os.File
+	buffer  []byte
+}
+
+func (backend *processBackendType) Name() string {
+	return backend.name
+}
+
+func (backend *processBackendType) Open(rate int) error {
+	cmd := backend.command(rate)
+	reader, stdin, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = reader
+	err = cmd.Start()
+	reader.Close()
+	if err != nil {
+		stdin.Close()
+		return err
+	}
+	backend.cmd = cmd
+	backend.stdin = stdin
+	return nil
+}
+
+func (backend *processBackendType) Write(samples []int16) error {
+	if cap(backend.buffer) < len(samples)*2 {
+		backend.buffer = make([]byte, len(samples)*2)
+	}
+	buffer := backend.buffer[:len(samples)*2]
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(buffer[i*2:], uint16(sample))
+	}
+	_, err := backend.stdin.Write(buffer)
+	return err
+}
+
+func (backend *processBackendType) Close() error {
+	backend.stdin.Close()
+	return backend.cmd.Wait()
+}
+
+// audioBackends returns the Linux audio backends in order of preference:
+// PulseAudio (also served by PipeWire) through pacat, then ALSA through aplay
+func audioBackends() []AudioBackend {
+	return []AudioBackend{
+		&processBackendType{
+			name: "PulseAudio",
+			command: func(rate int) *exec.Cmd {
+				return exec.Command("pacat", "--playback", "--raw", "--format=s16le",
+					"--rate="+strconv.Itoa(rate), "--channels=2", "--client-name=FreeMe!GB", "--latency-msec=50")
+			},
+		},
+		&processBackendType{
+			name: "ALSA",
+			command: func(rate int) *exec.Cmd {
+				return exec.Command("aplay", "-q", "-t", "raw", "-f", "S16_LE",
+					"-r", strconv.Itoa(rate), "-c", "2", "--buffer-time=50000")
+			},
+		},
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+// audioBackends returns the audio backends for this platform, none are implemented yet
+// so audio can only be written to a WAV file
+func audioBackends() []AudioBackend {
+	return nil
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRingBuffer fills a ring buffer, expecting the next Write to block until
+// Read makes room and the samples to come out in order across the wrap
+func TestRingBuffer(t *testing.T) {
+	ring := NewRingBuffer(4)
+	ring.Write([]int16{1, 2, 3})
+	if fill := ring.Fill(); fill != 0.75 {
+		t.Errorf("Ring Buffer: fill %v, expected 0.75", fill)
+	}
+
+	written := make(chan bool)
+	go func() {
+		ring.Write([]int16{4, 5, 6})
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatalf("Ring Buffer: Write did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var samples []int16
+	buffer := make([]int16, 2)
+	for len(samples) < 6 {
+		n := ring.Read(buffer)
+		samples = append(samples, buffer[:n]...)
+	}
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Ring Buffer: Write never finished after the reads")
+	}
+	for i, sample := range samples {
+		if sample != int16(i+1) {
+			t.Fatalf("Ring Buffer: read %v, expected 1 to 6 in order", samples)
+		}
+	}
+}
+
+// TestRingBufferClose expects Close to release a blocked reader and writer,
+// Read to drain what is left and later writes to be dropped
+func TestRingBufferClose(t *testing.T) {
+	ring := NewRingBuffer(2)
+	read := make(chan int)
+	go func() {
+		read <- ring.Read(make([]int16, 2))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ring.Close()
+	select {
+	case n := <-read:
+		if n != 0 {
+			t.Errorf("Ring Buffer: closed empty Read returned %d samples, expected 0", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Ring Buffer: Close did not release a blocked Read")
+	}
+
+	ring = NewRingBuffer(2)
+	ring.Write([]int16{1, 2})
+	written := make(chan bool)
+	go func() {
+		ring.Write([]int16{3})
+		close(written)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ring.Close()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Ring Buffer: Close did not release a blocked Write")
+	}
+	ring.Write([]int16{4})
+	buffer := make([]int16, 4)
+	if n := ring.Read(buffer); n != 2 || buffer[0] != 1 || buffer[1] != 2 {
+		t.Errorf("Ring Buffer: drained %v, expected [1 2]", buffer[:n])
+	}
+	if n := ring.Read(buffer); n != 0 {
+		t.Errorf("Ring Buffer: read %d samples after draining, expected 0", n)
+	}
+}
+
+// TestAudioOutputVolume changes the volume and mute from another goroutine
+// while samples are written, as the UI does during emulation, then expects
+// WriteSamples to scale by the volume and silence a muted output
+func TestAudioOutputVolume(t *testing.T) {
+	settings := Settings
+	defer func() { Settings = settings }()
+	audio := &AudioOutputType{volume: 100, rate: 44100, ring: NewRingBuffer(64)}
+	rate := APU.SampleRate
+	defer func() { APU.SampleRate = rate }()
+
+	changed := make(chan bool)
+	go func() {
+		for volume := 0; volume <= 100; volume += 10 {
+			audio.SetVolume(volume)
+			audio.SetMuted(volume%20 == 0)
+		}
+		close(changed)
+	}()
+	buffer := make([]int16, 64)
+	for i := 0; i < 100; i++ {
+		audio.WriteSamples([]int16{1000})
+		audio.ring.Read(buffer)
+	}
+	<-changed
+
+	audio.SetVolume(50)
+	audio.SetMuted(false)
+	audio.WriteSamples([]int16{1000, -1000})
+	if n := audio.ring.Read(buffer); n != 2 || buffer[0] != 500 || buffer[1] != -500 {
+		t.Errorf("Audio: wrote %v at 50%%, expected [500 -500]", buffer[:n])
+	}
+	audio.SetMuted(true)
+	audio.WriteSamples([]int16{1000})
+	if n := audio.ring.Read(buffer); n != 1 || buffer[0] != 0 {
+		t.Errorf("Audio: wrote %v muted, expected [0]", buffer[:n])
+	}
+	if volume := audio.Volume(); volume != 50 {
+		t.Errorf("Audio: volume %d after muting, expected 50", volume)
+	}
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// Boot ROM sizes. The DMG, MGB and SGB boot ROMs map over 0x0000-0x00FF,
+// the CGB boot ROM also maps over 0x0200-0x08FF, leaving the cartridge header visible.
+const (
+	BootROMSizeDMG = 0x100
+	BootROMSizeCGB = 0x900
+)
+
+// ioRegisterType is an I/O register and the value the boot ROM leaves in it
+type ioRegisterType struct {
+	address uint16
+	value   byte
+}
+
+// postBootIO is the I/O state left by the DMG boot ROM, written in order.
+// NR52 comes first since the other sound registers ignore writes while the APU is off.
+var postBootIO = []ioRegisterType{
+	{0xFF26, 0xF1},
+	{0xFF00, 0xCF}, {0xFF01, 0x00}, {0xFF02, 0x7E},
+	{0xFF05, 0x00}, {0xFF06, 0x00}, {0xFF07, 0xF8},
+	{0xFF10, 0x80}, {0xFF11, 0xBF}, {0xFF12, 0xF3}, {0xFF13, 0xFF}, {0xFF14, 0xBF},
+	{0xFF16, 0x3F}, {0xFF17, 0x00}, {0xFF18, 0xFF}, {0xFF19, 0xBF},
+	{0xFF1A, 0x7F}, {0xFF1B, 0xFF}, {0xFF1C, 0x9F}, {0xFF1D, 0xFF}, {0xFF1E, 0xBF},
+	{0xFF20, 0xFF}, {0xFF21, 0x00}, {0xFF22, 0x00}, {0xFF23, 0xBF},
+	{0xFF24, 0x77}, {0xFF25, 0xF3},
+	{0xFF40, 0x91}, {0xFF42, 0x00}, {0xFF43, 0x00}, {0xFF45, 0x00},
+	{0xFF47, 0xFC}, {0xFF48, 0xFF}, {0xFF49, 0xFF}, {0xFF4A, 0x00}, {0xFF4B, 0x00},
+	{0xFFFF, 0x00},
+}
+
+// postBootCGBIO is the state of the CGB registers left by the CGB boot ROM
+// when it starts a CGB game: normal speed and the first VRAM and WRAM banks
+var postBootCGBIO = []ioRegisterType{
+	{0xFF4D, 0x00}, {0xFF4F, 0x00}, {0xFF70, 0x01},
+}
+
+// MGB is set while a Game Boy Pocket is emulated. It runs DMG games as the DMG
+// does, only its boot ROM leaves 0xFF in A for games to tell them apart.
+var MGB = false
+
+// ReadBootROM reads a boot ROM file, checking it is a DMG/MGB/SGB or CGB sized image
+func ReadBootROM(location string) ([]byte, error) {
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != BootROMSizeDMG && len(data) != BootROMSizeCGB {
+		return nil, fmt.Errorf("%s is %d bytes, expected %d (DMG/MGB/SGB) or %d (CGB)",
+			location, len(data), BootROMSizeDMG, BootROMSizeCGB)
+	}
+	return data, nil
+}
+
+// BootROMLocation returns the boot ROM configured in Settings for the selected model,
+// or "" to skip the boot sequence
+func BootROMLocation() string {
+	if CGB.Enabled {
+		return Settings.BootROMCGB
+	}
+	return Settings.BootROM
+}
+
+// LoadBootROM maps the configured boot ROM, reporting whether it will run
+func (mmu *MMUType) LoadBootROM() bool {
+	mmu.bootROM = nil
+	location := BootROMLocation()
+	if location == "" {
+		return false
+	}
+	data, err := ReadBootROM(location)
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "BOOT ROM:", err)
+		return false
+	}
+	if CGB.Enabled != (len(data) == BootROMSizeCGB) {
+		Logger.Log(LogTypes.ERROR, "BOOT ROM: "+location+" does not match the selected model")
+		return false
+	}
+	mmu.bootROM = data
+	Logger.Log(LogTypes.INFO, "BOOT ROM: running "+location)
+	return true
+}
+
+// BootROMMapped reports whether the boot ROM is still mapped over the cartridge
+func (mmu *MMUType) BootROMMapped() bool {
+	return mmu.bootROM != nil
+}
+
+// readBootROM returns the boot ROM byte at address, ok is false where the cartridge shows through
+func (mmu *MMUType) readBootROM(address uint16) (byte, bool) {
+	if mmu.bootROM == nil {
+		return 0, false
+	}
+	if address < BootROMSizeDMG || (address >= 0x200 && int(address) < len(mmu.bootROM)) {
+		return mmu.bootROM[address], true
+	}
+	return 0, false
+}
+
+// PostBoot sets the registers and I/O to the state the boot ROM of the selected model leaves them in
+func PostBoot() {
+	registers := CPU.REGISTERS
+	switch {
+	case CGB.Enabled:
+		registers.AF = 0x1180
+		registers.BC = 0x0000
+		registers.DE = 0xFF56
+		registers.HL = 0x000D
+	case SGB.Enabled:
+		registers.AF = 0x0100
+		registers.BC = 0x0014
+		registers.DE = 0x0000
+		registers.HL = 0xC060
+	case MGB:
+		registers.AF = 0xFFB0
+		registers.BC = 0x0013
+		registers.DE = 0x00D8
+		registers.HL = 0x014D
+	default:
+		registers.AF = 0x01B0
+		registers.BC = 0x0013
+		registers.DE = 0x00D8
+		registers.HL = 0x014D
+	}
+	registers.SP = 0xFFFE
+	registers.PC = 0x0100
+
+	for _, register := range postBootIO {
+		MMU.WriteByte(register.address, register.value)
+	}
+	if CGB.Enabled {
+		for _, register := range postBootCGBIO {
+			MMU.WriteByte(register.address, register.value)
+		}
+		// every background and sprite colour is white, auto-incrementing from the first
+		MMU.WriteByte(0xFF68, 0x80)
+		MMU.WriteByte(0xFF6A, 0x80)
+		for i := 0; i < len(CGB.bgPaletteRAM)/2; i++ {
+			MMU.WriteByte(0xFF69, 0xFF)
+			MMU.WriteByte(0xFF69, 0x7F)
+			MMU.WriteByte(0xFF6B, 0xFF)
+			MMU.WriteByte(0xFF6B, 0x7F)
+		}
+	}
+	// the boot sound has faded out by the time the cartridge starts
+	APU.Square1.Envelope.volume = 0
+	if SGB.Enabled {
+		// the SGB boot ROM leaves the sound channels off
+		APU.Square1.Enabled = false
+	}
+	INTERRUPTS.flags = 0xE1
+}
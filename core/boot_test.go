@@ -0,0 +1,70 @@
+package core
+
+import "testing"
+
+// TestPostBoot resets each model without a boot ROM and expects the registers
+// and I/O its boot ROM leaves behind
+func TestPostBoot(t *testing.T) {
+	bootROM, bootROMCGB := Settings.BootROM, Settings.BootROMCGB
+	Settings.BootROM, Settings.BootROMCGB = "", ""
+	defer func() {
+		Settings.BootROM, Settings.BootROMCGB = bootROM, bootROMCGB
+		MGB, CGB.Enabled, SGB.Enabled = false, false, false
+	}()
+	if err := ROM.Load(testROM(nil)); err != nil {
+		t.Fatalf("Boot: %v", err)
+	}
+
+	models := []struct {
+		model          string
+		af, bc, de, hl uint16
+		io             []ioRegisterType
+	}{
+		{ModelDMG, 0x01B0, 0x0013, 0x00D8, 0x014D, []ioRegisterType{
+			{0xFF40, 0x91}, {0xFF47, 0xFC}, {0xFF26, 0xF1}, {0xFF0F, 0xE1}}},
+		{ModelMGB, 0xFFB0, 0x0013, 0x00D8, 0x014D, []ioRegisterType{
+			{0xFF40, 0x91}, {0xFF47, 0xFC}, {0xFF26, 0xF1}, {0xFF0F, 0xE1}}},
+		{ModelSGB, 0x0100, 0x0014, 0x0000, 0xC060, []ioRegisterType{
+			{0xFF40, 0x91}, {0xFF47, 0xFC}, {0xFF26, 0xF0}, {0xFF0F, 0xE1}}},
+		{ModelCGB, 0x1180, 0x0000, 0xFF56, 0x000D, []ioRegisterType{
+			{0xFF40, 0x91}, {0xFF47, 0xFC}, {0xFF26, 0xF1}, {0xFF0F, 0xE1},
+			{0xFF4D, 0x7E}, {0xFF4F, 0xFE}, {0xFF70, 0xF9}, {0xFF69, 0xFF}, {0xFF6B, 0xFF}}},
+	}
+	for _, model := range models {
+		Settings.Model = model.model
+		System.SelectModel()
+		CPU.Reset()
+		registers := CPU.REGISTERS
+		if registers.AF != model.af || registers.BC != model.bc || registers.DE != model.de || registers.HL != model.hl {
+			t.Errorf("Boot: %s AF=%04X BC=%04X DE=%04X HL=%04X, expected AF=%04X BC=%04X DE=%04X HL=%04X",
+				model.model, registers.AF, registers.BC, registers.DE, registers.HL,
+				model.af, model.bc, model.de, model.hl)
+		}
+		if registers.SP != 0xFFFE || registers.PC != 0x0100 {
+			t.Errorf("Boot: %s SP=%04X PC=%04X", model.model, registers.SP, registers.PC)
+		}
+		for _, register := range model.io {
+			if value := MMU.ReadByte(register.address); value != register.value {
+				t.Errorf("Boot: %s 0x%04X is 0x%02X, expected 0x%02X", model.model, register.address, value, register.value)
+			}
+		}
+	}
+	Settings.Model = ModelAuto
+}
+
+// TestBootROMUnmap keeps the boot ROM mapped until a nonzero value is written to 0xFF50
+func TestBootROMUnmap(t *testing.T) {
+	if err := ROM.Load(testROM(nil)); err != nil {
+		t.Fatalf("Boot: %v", err)
+	}
+	MMU.bootROM = make([]byte, BootROMSizeDMG)
+	defer func() { MMU.bootROM = nil }()
+	MMU.WriteByte(0xFF50, 0x00)
+	if !MMU.BootROMMapped() {
+		t.Errorf("Boot: writing 0 to 0xFF50 unmapped the boot ROM")
+	}
+	MMU.WriteByte(0xFF50, 0x01)
+	if MMU.BootROMMapped() {
+		t.Errorf("Boot: writing 1 to 0xFF50 left the boot ROM mapped")
+	}
+}
@@ -0,0 +1,173 @@
+package core
+
+// CGBType is the structure that holds the Game Boy Color only hardware state
+//
+//	CGB Structure
+//	================
+//	---> KEY1 double speed switch
+//	---> VBK VRAM bank and SVBK WRAM bank
+//	---> BG/OBJ colour palette RAM and their index registers
+//	---> HDMA source, destination and remaining length
+//	================
+type CGBType struct {
+	Enabled     bool
+	DoubleSpeed bool
+	prepare     bool
+
+	vRAMBank byte
+	wRAMBank byte
+
+	bgPaletteIndex  byte
+	objPaletteIndex byte
+	bgPaletteRAM    [64]byte
+	objPaletteRAM   [64]byte
+
+	hdmaSource      uint16
+	hdmaDestination uint16
+	hdmaBlocks      byte
+	hdmaActive      bool
+}
+
+// CGB is the exported object used in the system
+//
+// CGB is exported so the UI can report the hardware model in use
+var CGB = CGBType{
+	wRAMBank: 1,
+}
+
+// Reset sets the CGB registers to their power-on values
+func (cgb *CGBType) Reset() {
+	cgb.DoubleSpeed = false
+	cgb.prepare = false
+	cgb.vRAMBank = 0
+	cgb.wRAMBank = 1
+	cgb.bgPaletteIndex = 0
+	cgb.objPaletteIndex = 0
+	for i := range cgb.bgPaletteRAM {
+		cgb.bgPaletteRAM[i] = 0xFF
+		cgb.objPaletteRAM[i] = 0xFF
+	}
+	cgb.hdmaActive = false
+	cgb.hdmaBlocks = 0
+}
+
+// SpeedSwitch performs a pending KEY1 speed switch, called by STOP.
+// It reports whether a switch took place.
+func (cgb *CGBType) SpeedSwitch() bool {
+	if !cgb.Enabled || !cgb.prepare {
+		return false
+	}
+	cgb.prepare = false
+	cgb.DoubleSpeed = !cgb.DoubleSpeed
+	return true
+}
+
+// ReadKEY1 returns the current speed and the prepare bit
+func (cgb *CGBType) ReadKEY1() byte {
+	value := byte(0x7E)
+	if cgb.DoubleSpeed {
+		value |= 0x80
+	}
+	if cgb.prepare {
+		value |= 0x01
+	}
+	return value
+}
+
+// WriteKEY1 arms a speed switch for the next STOP
+func (cgb *CGBType) WriteKEY1(value byte) {
+	cgb.prepare = value&0x01 != 0
+}
+
+// WriteVBK selects VRAM bank 0 or 1
+func (cgb *CGBType) WriteVBK(value byte) {
+	cgb.vRAMBank = value & 0x01
+}
+
+// WriteSVBK selects WRAM bank 1-7 for 0xD000-0xDFFF, bank 0 selects bank 1
+func (cgb *CGBType) WriteSVBK(value byte) {
+	cgb.wRAMBank = value & 0x07
+	if cgb.wRAMBank == 0 {
+		cgb.wRAMBank = 1
+	}
+}
+
+// readPaletteData returns the palette RAM byte selected by BCPS/OCPS
+func readPaletteData(ram *[64]byte, index byte) byte {
+	return ram[index&0x3F]
+}
+
+// writePaletteData stores a palette RAM byte and applies auto-increment (bit 7 of the index)
+func writePaletteData(ram *[64]byte, index *byte, value byte) {
+	ram[*index&0x3F] = value
+	if *index&0x80 != 0 {
+		*index = 0x80 | (*index+1)&0x3F
+	}
+}
+
+// paletteColour returns a palette RAM entry as 15-bit RGB,
+// the palette number taken from the lower three bits of a tile's attributes
+func paletteColour(ram *[64]byte, palette byte, colour byte) uint16 {
+	i := (palette&0x07)*8 + colour*2
+	return uint16(ram[i]) | uint16(ram[i+1])<<8&0x7F00
+}
+
+// WriteHDMA handles writes to HDMA1-HDMA5 (0xFF51-0xFF55)
+func (cgb *CGBType) WriteHDMA(address uint16, value byte) {
+	switch address {
+	case 0xFF51:
+		cgb.hdmaSource = uint16(value)<<8 | cgb.hdmaSource&0x00F0
+	case 0xFF52:
+		cgb.hdmaSource = cgb.hdmaSource&0xFF00 | uint16(value&0xF0)
+	case 0xFF53:
+		cgb.hdmaDestination = uint16(value&0x1F)<<8 | cgb.hdmaDestination&0x00F0
+	case 0xFF54:
+		cgb.hdmaDestination = cgb.hdmaDestination&0xFF00 | uint16(value&0xF0)
+	case 0xFF55:
+		if cgb.hdmaActive && value&0x80 == 0 {
+			// writing bit 7 clear during an HDMA cancels it
+			cgb.hdmaActive = false
+			return
+		}
+		cgb.hdmaBlocks = value&0x7F + 1
+		if value&0x80 == 0 {
+			// General purpose DMA copies everything at once
+			for cgb.hdmaBlocks > 0 {
+				cgb.copyBlock()
+			}
+		} else {
+			cgb.hdmaActive = true
+		}
+	}
+}
+
+// ReadHDMA5 returns the remaining length of an HDMA with bit 7 clear while it
+// is active. A cancelled HDMA keeps its remaining length with bit 7 set, a
+// finished one reads 0xFF.
+func (cgb *CGBType) ReadHDMA5() byte {
+	remaining := (cgb.hdmaBlocks - 1) & 0x7F
+	if !cgb.hdmaActive {
+		return 0x80 | remaining
+	}
+	return remaining
+}
+
+// HBlank copies the next 16 byte block of an active HDMA, called as each HBlank starts
+func (cgb *CGBType) HBlank() {
+	if !cgb.hdmaActive {
+		return
+	}
+	cgb.copyBlock()
+	if cgb.hdmaBlocks == 0 {
+		cgb.hdmaActive = false
+	}
+}
+
+func (cgb *CGBType) copyBlock() {
+	for i := uint16(0); i < 0x10; i++ {
+		MMU.WriteByte(0x8000|(cgb.hdmaDestination+i)&0x1FFF, MMU.ReadByte(cgb.hdmaSource+i))
+	}
+	cgb.hdmaSource += 0x10
+	cgb.hdmaDestination += 0x10
+	cgb.hdmaBlocks--
+}
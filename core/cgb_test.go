@@ -0,0 +1,210 @@
+package core
+
+import "testing"
+
+// cgbProgram arms a speed switch through KEY1 and performs it with STOP
+var cgbProgram = []byte{
+	0x3E, 0x01, // LD A, 0x01
+	0xE0, 0x4D, // LDH (0x4D), A
+	0x10, 0x00, // STOP
+	0x00, //       NOP
+}
+
+// loadCGB switches a CGB on with cgbProgram as its ROM
+func loadCGB(t *testing.T) {
+	loadProgram(t, cgbProgram)
+	CGB.Enabled = true
+	CGB.Reset()
+	t.Cleanup(func() {
+		CGB.Enabled = false
+		CGB.Reset()
+	})
+}
+
+// executeCGB runs the next instructions, returning the cycles the last one took
+func executeCGB(t *testing.T, instructions int) int {
+	cycles := 0
+	for i := 0; i < instructions; i++ {
+		instruction, err := CPU.Fetch()
+		if err != nil {
+			t.Fatalf("CGB: %v", err)
+		}
+		cycles = CPU.Execute(instruction)
+	}
+	return cycles
+}
+
+// TestCGBSpeedSwitch arms KEY1 and expects STOP to switch to double speed,
+// keeping the CPU running and halving the cycles the GPU and APU see
+func TestCGBSpeedSwitch(t *testing.T) {
+	loadCGB(t)
+	running := CPU.RUNNING
+	CPU.RUNNING = true
+	defer func() { CPU.RUNNING = running }()
+
+	if key1 := MMU.ReadByte(0xFF4D); key1 != 0x7E {
+		t.Errorf("CGB: KEY1 0x%02X at power on, expected 0x7E", key1)
+	}
+	executeCGB(t, 3) // JR to the program, LD and LDH
+	if key1 := MMU.ReadByte(0xFF4D); key1 != 0x7F {
+		t.Errorf("CGB: KEY1 0x%02X once armed, expected 0x7F", key1)
+	}
+	executeCGB(t, 1)
+	if key1 := MMU.ReadByte(0xFF4D); key1 != 0xFE || !CGB.DoubleSpeed {
+		t.Errorf("CGB: KEY1 0x%02X after STOP, expected 0xFE at double speed", key1)
+	}
+	if !CPU.RUNNING {
+		t.Errorf("CGB: STOP performing a speed switch stopped the CPU")
+	}
+	if cycles := executeCGB(t, 1); cycles != 2 {
+		t.Errorf("CGB: NOP took %d cycles at double speed, expected 2", cycles)
+	}
+}
+
+// TestCGBBanks switches the VRAM bank through VBK and the upper WRAM bank
+// through SVBK, expecting each bank to keep its own bytes
+func TestCGBBanks(t *testing.T) {
+	loadCGB(t)
+
+	MMU.WriteByte(0x8000, 0x11)
+	MMU.WriteByte(0xFF4F, 0x01)
+	if vbk := MMU.ReadByte(0xFF4F); vbk != 0xFF {
+		t.Errorf("CGB: VBK 0x%02X with bank 1 selected, expected 0xFF", vbk)
+	}
+	MMU.WriteByte(0x8000, 0x22)
+	MMU.WriteByte(0xFF4F, 0xFE)
+	if value := MMU.ReadByte(0x8000); value != 0x11 {
+		t.Errorf("CGB: VRAM bank 0 holds 0x%02X, expected 0x11", value)
+	}
+	if vRAM[0x2000] != 0x22 {
+		t.Errorf("CGB: VRAM bank 1 holds 0x%02X, expected 0x22", vRAM[0x2000])
+	}
+
+	MMU.WriteByte(0xC000, 0xAA)
+	for bank := byte(1); bank < 8; bank++ {
+		MMU.WriteByte(0xFF70, bank)
+		MMU.WriteByte(0xD000, bank)
+	}
+	for bank := byte(0); bank < 8; bank++ {
+		MMU.WriteByte(0xFF70, bank)
+		expected := bank
+		if bank == 0 {
+			expected = 1
+		}
+		if svbk := MMU.ReadByte(0xFF70); svbk != 0xF8|expected {
+			t.Errorf("CGB: SVBK 0x%02X after writing %d, expected 0x%02X", svbk, bank, 0xF8|expected)
+		}
+		if value := MMU.ReadByte(0xD000); value != expected {
+			t.Errorf("CGB: WRAM bank %d holds 0x%02X at 0xD000, expected 0x%02X", bank, value, expected)
+		}
+		if value := MMU.ReadByte(0xC000); value != 0xAA {
+			t.Errorf("CGB: WRAM bank 0 holds 0x%02X with SVBK %d, expected 0xAA", value, bank)
+		}
+	}
+}
+
+// TestCGBPalettes writes BG and OBJ palette RAM through BCPS/BCPD and
+// OCPS/OCPD, expecting the index to auto-increment and wrap only with bit 7
+// set and reads to leave it alone
+func TestCGBPalettes(t *testing.T) {
+	registers := []struct {
+		name        string
+		index, data uint16
+		ram         *[64]byte
+	}{
+		{"BG", 0xFF68, 0xFF69, &CGB.bgPaletteRAM},
+		{"OBJ", 0xFF6A, 0xFF6B, &CGB.objPaletteRAM},
+	}
+	for _, register := range registers {
+		loadCGB(t)
+		MMU.WriteByte(register.index, 0x80|0x3E)
+		for _, value := range []byte{0x12, 0x34, 0x56} {
+			MMU.WriteByte(register.data, value)
+		}
+		if index := MMU.ReadByte(register.index); index != 0xC1 {
+			t.Errorf("CGB: %s index 0x%02X after wrapping, expected 0xC1", register.name, index)
+		}
+		if register.ram[0x3E] != 0x12 || register.ram[0x3F] != 0x34 || register.ram[0x00] != 0x56 {
+			t.Errorf("CGB: %s palette RAM %v, expected 0x12 0x34 at 0x3E and 0x56 at 0x00",
+				register.name, register.ram[:])
+		}
+
+		MMU.WriteByte(register.index, 0x3F)
+		MMU.WriteByte(register.data, 0x78)
+		MMU.WriteByte(register.data, 0x9A)
+		if value := MMU.ReadByte(register.data); value != 0x9A {
+			t.Errorf("CGB: %s data 0x%02X without auto-increment, expected 0x9A", register.name, value)
+		}
+		if index := MMU.ReadByte(register.index); index != 0x7F {
+			t.Errorf("CGB: %s index 0x%02X without auto-increment, expected 0x7F", register.name, index)
+		}
+	}
+}
+
+// TestCGBDMA copies from WRAM to VRAM with a general purpose DMA at once and
+// with an HBlank DMA a block per HBlank, expecting HDMA5 to report the
+// remaining length while active, after a cancel and once finished
+func TestCGBDMA(t *testing.T) {
+	loadCGB(t)
+	for i := uint16(0); i < 0x60; i++ {
+		MMU.WriteByte(0xC000+i, byte(i+1))
+	}
+	// copied reports whether length bytes from source were copied to destination
+	copied := func(source, destination, length uint16) bool {
+		for i := uint16(0); i < length; i++ {
+			if MMU.ReadByte(destination+i) != MMU.ReadByte(source+i) {
+				return false
+			}
+		}
+		return true
+	}
+	start := func(source, destination uint16, hdma5 byte) {
+		for _, register := range []ioRegisterType{
+			{0xFF51, byte(source >> 8)}, {0xFF52, byte(source)},
+			{0xFF53, byte(destination >> 8)}, {0xFF54, byte(destination)},
+			{0xFF55, hdma5},
+		} {
+			MMU.WriteByte(register.address, register.value)
+		}
+	}
+
+	start(0xC000, 0x8000, 0x01)
+	if !copied(0xC000, 0x8000, 0x20) {
+		t.Errorf("CGB: general purpose DMA did not copy 0x20 bytes")
+	}
+	if hdma5 := MMU.ReadByte(0xFF55); hdma5 != 0xFF {
+		t.Errorf("CGB: HDMA5 0x%02X after a general purpose DMA, expected 0xFF", hdma5)
+	}
+
+	start(0xC020, 0x8100, 0x82)
+	if MMU.ReadByte(0x8100) != 0x00 {
+		t.Errorf("CGB: HBlank DMA copied before an HBlank")
+	}
+	if hdma5 := MMU.ReadByte(0xFF55); hdma5 != 0x02 {
+		t.Errorf("CGB: HDMA5 0x%02X with 3 blocks left, expected 0x02", hdma5)
+	}
+	CGB.HBlank()
+	if !copied(0xC020, 0x8100, 0x10) || MMU.ReadByte(0x8110) != 0x00 {
+		t.Errorf("CGB: first HBlank did not copy exactly one block")
+	}
+	if hdma5 := MMU.ReadByte(0xFF55); hdma5 != 0x01 {
+		t.Errorf("CGB: HDMA5 0x%02X with 2 blocks left, expected 0x01", hdma5)
+	}
+	MMU.WriteByte(0xFF55, 0x00)
+	if hdma5 := MMU.ReadByte(0xFF55); hdma5 != 0x81 {
+		t.Errorf("CGB: HDMA5 0x%02X after cancelling with 2 blocks left, expected 0x81", hdma5)
+	}
+	CGB.HBlank()
+	if MMU.ReadByte(0x8110) != 0x00 {
+		t.Errorf("CGB: cancelled HBlank DMA kept copying")
+	}
+
+	start(0xC040, 0x8200, 0x80)
+	CGB.HBlank()
+	if !copied(0xC040, 0x8200, 0x10) {
+		t.Errorf("CGB: single block HBlank DMA did not copy")
+	}
+	if hdma5 := MMU.ReadByte(0xFF55); hdma5 != 0xFF {
+		t.Errorf("CGB: HDMA5 0x%02X after the HBlank DMA finished, expected 0xFF", hdma5)
+	}
+}
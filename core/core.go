@@ -25,4 +25,5 @@ func Init() {
 	LogFilename = UserHome + "/.freemegb/" + strings.ReplaceAll("freemegb_"+time.Now().Format("January 2, 2006")+".log", " ", "_")
 	LogFile, err = os.OpenFile(LogFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	Logger.InternalLogger = log.New(LogFile, "", 0)
+	Settings.Load()
 }
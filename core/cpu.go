@@ -2,6 +2,7 @@
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/gotk3/gotk3/gtk"
@@ -28,6 +29,7 @@ type INTERRUPTSType struct {
 //	---> Instructions Array
 //	---> Registers Structure
 //	---> DEBUG boolean value set with CPU.Run()
+//	---> CYCLES counter of machine clock cycles since reset
 //	================
 type CPUType struct {
 	INSTRUCTIONS []InstructionType
@@ -38,6 +40,10 @@ type CPUType struct {
 	RUNNING      bool
 	PAUSED       bool
 	BREAKPOINTS  map[uint16]bool
+	CYCLES       uint64
+
+	// lock is held by Run while an instruction executes, so Call can work between instructions
+	lock sync.Mutex
 }
 
 // CPU is the exported object used in the system
@@ -83,20 +89,26 @@ func (cpu *CPUType) Run(debug bool, registerTreeView *gtk.TreeView, registerList
 			cpu.STEP = true
 		}
 
-		if cpu.INSTRUCTIONS[ROM.data[cpu.REGISTERS.PC]].Name == "UNKNOWN" {
-			var PCString = cpu.REGISTERS.Register16toString(cpu.REGISTERS.PC)
-			Logger.Logf(LogTypes.ERROR, "UNKNOWN INSTRUCTION:\n\t\t\t\tINSTRUCTION: 0x%02X\n\t\t\t\tAt ROM Offset: %s\n",
-				cpu.INSTRUCTIONS[ROM.data[cpu.REGISTERS.PC]].Opcode, PCString)
-			Notify(fmt.Sprintf("INSTRUCTION: 0x%02X\nAt ROM Offset: %s",
-				cpu.INSTRUCTIONS[ROM.data[cpu.REGISTERS.PC]].Opcode, PCString))
-			break
-		}
-		Logger.Logf(LogTypes.INFO, "Instruction: %s\n", cpu.INSTRUCTIONS[ROM.data[cpu.REGISTERS.PC]].Name)
-
 		for cpu.PAUSED {
 			time.Sleep(400 * time.Millisecond)
 		}
 
+		if Rewind.Rewinding {
+			cpu.lock.Lock()
+			// a movie would no longer match its inputs after rewinding
+			rewinding := !Movie.Active()
+			if rewinding {
+				Rewind.Step()
+				Reverse.Clear()
+			}
+			cpu.lock.Unlock()
+			if rewinding {
+				cpu.REGISTERS.UpdateRegisterTable(registerTreeView, registerListStore)
+				time.Sleep(Rewind.StepDelay())
+				continue
+			}
+		}
+
 		if cpu.DEBUG {
 			for cpu.STEP && cpu.RUNNING {
 				time.Sleep(150 * time.Millisecond)
@@ -104,33 +116,123 @@ func (cpu *CPUType) Run(debug bool, registerTreeView *gtk.TreeView, registerList
 
 			cpu.REGISTERS.Print()
 			time.Sleep(500 * time.Millisecond)
-		} else {
+		} else if !Audio.Running() {
+			// with audio playing, the full sample buffer throttles the CPU instead
 			time.Sleep(80 * time.Millisecond)
 		}
-		var instruction = cpu.INSTRUCTIONS[ROM.data[cpu.REGISTERS.PC]]
-		cpu.REGISTERS.PC++
-		if instruction.NumOperands != 0 {
-			cpu.REGISTERS.ReadOperand(&instruction, &ROM)
+
+		// fetched after waiting, as Call may have changed the machine meanwhile
+		cpu.lock.Lock()
+		if cpu.PAUSED || cpu.DEBUG && cpu.STEP {
+			// stopped through Call after the wait, by reverse debugging or an attached debugger
+			cpu.lock.Unlock()
+			continue
+		}
+		instruction, err := cpu.Fetch()
+		if err != nil {
+			cpu.lock.Unlock()
+			var PCString = cpu.REGISTERS.Register16toString(cpu.REGISTERS.PC)
+			Logger.Logf(LogTypes.ERROR, "UNKNOWN INSTRUCTION:\n\t\t\t\tINSTRUCTION: 0x%02X\n\t\t\t\tAt ROM Offset: %s\n",
+				instruction.Opcode, PCString)
+			Notify(fmt.Sprintf("INSTRUCTION: 0x%02X\nAt ROM Offset: %s",
+				instruction.Opcode, PCString))
+			break
 		}
-		cpu.REGISTERS.PC += uint16(instruction.NumOperands)
-		instruction.Exec(instruction.Operand)
-		instruction.Operand = nil
+		Reverse.Record()
+		Trace.Record()
+		cpu.Execute(instruction)
+		Movie.Update()
+		Rewind.Update()
+		cpu.lock.Unlock()
 		cpu.REGISTERS.UpdateRegisterTable(registerTreeView, registerListStore)
 
 	}
+	Audio.Stop()
+	StopLinkCable()
+	if err := Trace.Stop(); err != nil {
+		Logger.Log(LogTypes.ERROR, "TRACE: Error closing", err)
+	}
 	//	finished <- true
 }
 
-// Reset will reset the CPU, INTERRUPTS and REGISTERS to their default values
+// Call runs f between two instructions, waiting for the one executing to finish.
+// The UI uses it to inspect or replace the machine state while Run is executing.
+func (cpu *CPUType) Call(f func()) {
+	cpu.lock.Lock()
+	defer cpu.lock.Unlock()
+	f()
+}
+
+// UnknownInstructionError is returned by Fetch for an opcode with no instruction
+type UnknownInstructionError struct {
+	Opcode byte
+	PC     uint16
+}
+
+func (e *UnknownInstructionError) Error() string {
+	return fmt.Sprintf("unknown instruction 0x%02X at 0x%04X", e.Opcode, e.PC)
+}
+
+// Fetch reads the instruction at PC through the MMU, so the boot ROM runs while it is mapped
+func (cpu *CPUType) Fetch() (InstructionType, error) {
+	opcode := MMU.ReadByte(cpu.REGISTERS.PC)
+	instruction := cpu.INSTRUCTIONS[opcode]
+	if instruction.Name == "UNKNOWN" {
+		return instruction, &UnknownInstructionError{opcode, cpu.REGISTERS.PC}
+	}
+	return instruction, nil
+}
+
+// Execute runs a fetched instruction and advances the rest of the hardware
+// by its cycles, returning them
+func (cpu *CPUType) Execute(instruction InstructionType) int {
+	cpu.REGISTERS.PC++
+	if instruction.NumOperands != 0 {
+		cpu.REGISTERS.ReadOperand(&instruction, &ROM)
+	}
+	cpu.REGISTERS.PC += uint16(instruction.NumOperands)
+	instruction.Exec(instruction.Operand)
+	instruction.Operand = nil
+	cycles := int(instruction.Cycles)
+	// the serial clock doubles with the CPU
+	Serial.Step(cycles)
+	if CGB.DoubleSpeed {
+		// the GPU and APU keep their clock while the CPU runs twice as fast
+		cycles /= 2
+	}
+	cpu.CYCLES += uint64(cycles)
+	GPU.Step(cycles)
+	APU.Step(cycles)
+	return cycles
+}
+
+// Reset will reset the CPU, INTERRUPTS and REGISTERS to their default values.
+// With a boot ROM configured execution starts at 0x0000 with everything cleared,
+// otherwise in the state the boot ROM of the selected model leaves behind.
 func (cpu *CPUType) Reset() {
-	cpu.REGISTERS.AF = 0x01B0
-	cpu.REGISTERS.BC = 0x0013
-	cpu.REGISTERS.DE = 0x00D8
-	cpu.REGISTERS.HL = 0x01B0
-	cpu.REGISTERS.SP = 0xFFFE
-	cpu.REGISTERS.PC = 0x0100
+	CGB.Reset()
+	SGB.Reset()
+	APU.Reset()
+	Serial.Reset()
+	SerialPrinter.Clear()
+	Reverse.Clear()
+	cpu.CYCLES = 0
+	MMU.divReset = 0
 	cpu.RUNNING = false
-	cpu.REGISTERS.FLAG_CLEAR(cpu.REGISTERS.FLAGS.ZERO)
+	if MMU.LoadBootROM() {
+		cpu.REGISTERS.AF = 0x0000
+		cpu.REGISTERS.BC = 0x0000
+		cpu.REGISTERS.DE = 0x0000
+		cpu.REGISTERS.HL = 0x0000
+		cpu.REGISTERS.SP = 0x0000
+		cpu.REGISTERS.PC = 0x0000
+		MMU.WriteByte(0xFF26, 0x00)
+		MMU.WriteByte(0xFF40, 0x00)
+		INTERRUPTS.flags = 0x00
+		INTERRUPTS.enable = 0x00
+	} else {
+		PostBoot()
+	}
 	for breakpoint := range cpu.BREAKPOINTS {
 		delete(cpu.BREAKPOINTS, breakpoint)
 	}
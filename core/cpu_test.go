@@ -5,6 +5,10 @@
 )
 
 func TestCPU(t *testing.T)  {
+  // without a boot ROM, Reset leaves the DMG post-boot state
+  CGB.Enabled = false
+  SGB.Enabled = false
+  CPU.Reset()
   if CPU.REGISTERS.AF != 0x01B0 {
     t.Errorf("CPU Register: AF is not initialized properly")
   }
@@ -0,0 +1,844 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	goio "io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DAPPortDefault is the TCP port of "freemegb dap --port"
+const DAPPortDefault = 4711
+
+// dapThread is the only thread, the CPU
+const dapThread = 1
+
+// variable references of the scopes
+const (
+	dapRegisters = iota + 1
+	dapFlags
+)
+
+// breakpoint kinds besides those of source files, as keys of breakpoints
+const (
+	dapFunctionBreakpoints    = "\x00function"
+	dapInstructionBreakpoints = "\x00instruction"
+)
+
+// run modes, how far resume runs
+const (
+	dapContinue = iota
+	dapStepIn
+	dapNext
+	dapStepOut
+)
+
+// source file extensions scanned for labels under sourceRoot
+var dapSourceExtensions = map[string]bool{".asm": true, ".s": true, ".inc": true, ".z80": true, ".sm83": true}
+
+// dapLabelPattern matches a label definition, Label:, Label::, .local: or Parent.local:
+var dapLabelPattern = regexp.MustCompile(`^\s*(\.?[A-Za-z_][\w@#$]*(?:\.[\w@#$]+)?)::?`)
+
+// dapLocalPattern matches a local label at the start of a line, which needs no colon
+var dapLocalPattern = regexp.MustCompile(`^(\.[A-Za-z_][\w@#$]*)(?:\s|;|$)`)
+
+// Errors returned by the DAP server
+var (
+	ErrDAPRunning    = errors.New("the machine is running")
+	ErrDAPNotStopped = errors.New("the machine is not running")
+)
+
+// dapMessage is a request read from the client
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapBreakpoint struct {
+	Verified             bool       `json:"verified"`
+	Message              string     `json:"message,omitempty"`
+	Source               *dapSource `json:"source,omitempty"`
+	Line                 int        `json:"line,omitempty"`
+	InstructionReference string     `json:"instructionReference,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+// dapLabel is a label defined in a source file
+type dapLabel struct {
+	name string
+	line int
+}
+
+// dapLocation is where a label is defined
+type dapLocation struct {
+	path string
+	line int
+}
+
+// DAPServerType is a Debug Adapter Protocol server, so editors can launch a
+// ROM, set breakpoints by source line, label or address, inspect registers
+// and memory, and step.
+//
+//	DAP Server Structure
+//	================
+//	---> Symbols of the ROM launched and the labels of its source files
+//	---> Breakpoints of each source, merged into CPU.BREAKPOINTS
+//	---> Run control, executing the ROM without a window
+//	================
+//
+// RGBDS writes no line information, so a line breakpoint moves to the label on
+// or above the line, found in the source file and addressed by the symbol file.
+type DAPServerType struct {
+	system *SystemType
+
+	out       goio.Writer
+	writeLock sync.Mutex
+	seq       int
+
+	// sourceLabels are the labels of each source file, by line
+	sourceLabels map[string][]dapLabel
+	// labels are where each label is defined
+	labels map[string]dapLocation
+	// breakpoints are the addresses set by each source file, or by function
+	// and instruction breakpoints
+	breakpoints map[string][]uint16
+	stopOnEntry bool
+
+	lock     sync.Mutex
+	running  bool
+	stopping bool
+	pause    chan struct{}
+	// serialLength is how much serial output was sent to the client
+	serialLength int
+}
+
+// DAPServer is the exported object used in the system
+//
+// DAPServer is exported so the dap command can serve editors
+var DAPServer = DAPServerType{}
+
+// ListenDAP waits on localhost for one editor to connect
+func ListenDAP(port int) (net.Conn, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	Logger.Logf(LogTypes.INFO, "DAP: waiting for an editor on %s\n", listener.Addr())
+	return listener.Accept()
+}
+
+// Serve answers the requests read from in until the client disconnects
+func (server *DAPServerType) Serve(system *SystemType, in goio.Reader, out goio.Writer) error {
+	server.system = system
+	server.out = out
+	server.seq = 0
+	server.sourceLabels = map[string][]dapLabel{}
+	server.labels = map[string]dapLocation{}
+	server.breakpoints = map[string][]uint16{}
+	server.serialLength = 0
+	defer func() {
+		server.stop()
+		server.breakpoints = map[string][]uint16{}
+		server.applyBreakpoints()
+	}()
+
+	reader := bufio.NewReader(in)
+	for {
+		data, err := readDAPMessage(reader)
+		if err == goio.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var request dapMessage
+		if err := json.Unmarshal(data, &request); err != nil {
+			return err
+		}
+		if request.Type != "request" {
+			continue
+		}
+		body, err := server.handle(&request)
+		response := dapResponse{
+			Type:       "response",
+			RequestSeq: request.Seq,
+			Success:    err == nil,
+			Command:    request.Command,
+			Body:       body,
+		}
+		if err != nil {
+			response.Message = err.Error()
+		}
+		server.send(&response)
+
+		switch request.Command {
+		case "launch":
+			if err == nil {
+				// breakpoints are set once the ROM and its symbols are loaded
+				server.event("initialized", nil)
+			}
+		case "configurationDone":
+			if server.stopOnEntry {
+				server.event("stopped", map[string]interface{}{
+					"reason": "entry", "threadId": dapThread, "allThreadsStopped": true,
+				})
+			} else {
+				server.resume(dapContinue)
+			}
+		case "terminate":
+			server.stop()
+			server.event("terminated", nil)
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// readDAPMessage reads the content of a message after its headers
+func readDAPMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if length >= 0 {
+				break
+			}
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) == 2 && strings.EqualFold(strings.TrimSpace(fields[0]), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(fields[1])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	data := make([]byte, length)
+	_, err := goio.ReadFull(reader, data)
+	return data, err
+}
+
+// send writes a response or event, numbering it
+func (server *DAPServerType) send(message interface{}) {
+	server.writeLock.Lock()
+	defer server.writeLock.Unlock()
+	server.seq++
+	switch message := message.(type) {
+	case *dapResponse:
+		message.Seq = server.seq
+	case *dapEvent:
+		message.Seq = server.seq
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "DAP: Error encoding", err)
+		return
+	}
+	fmt.Fprintf(server.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (server *DAPServerType) event(event string, body interface{}) {
+	server.send(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+// handle runs a request, returning the body of its response
+func (server *DAPServerType) handle(request *dapMessage) (interface{}, error) {
+	arguments := func(v interface{}) error {
+		if len(request.Arguments) == 0 {
+			return nil
+		}
+		return json.Unmarshal(request.Arguments, v)
+	}
+	switch request.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		var launch struct {
+			Program     string `json:"program"`
+			Symbols     string `json:"symbols"`
+			SourceRoot  string `json:"sourceRoot"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		if err := arguments(&launch); err != nil {
+			return nil, err
+		}
+		return nil, server.launch(launch.Program, launch.Symbols, launch.SourceRoot, launch.StopOnEntry)
+	case "setBreakpoints":
+		var set struct {
+			Source      dapSource `json:"source"`
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		if err := arguments(&set); err != nil {
+			return nil, err
+		}
+		var lines []int
+		for _, breakpoint := range set.Breakpoints {
+			lines = append(lines, breakpoint.Line)
+		}
+		return map[string]interface{}{"breakpoints": server.setLineBreakpoints(set.Source.Path, lines)}, nil
+	case "setFunctionBreakpoints":
+		var set struct {
+			Breakpoints []struct {
+				Name string `json:"name"`
+			} `json:"breakpoints"`
+		}
+		if err := arguments(&set); err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, breakpoint := range set.Breakpoints {
+			names = append(names, breakpoint.Name)
+		}
+		return map[string]interface{}{"breakpoints": server.setAddressBreakpoints(dapFunctionBreakpoints, names)}, nil
+	case "setInstructionBreakpoints":
+		var set struct {
+			Breakpoints []struct {
+				InstructionReference string `json:"instructionReference"`
+				Offset               int    `json:"offset"`
+			} `json:"breakpoints"`
+		}
+		if err := arguments(&set); err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, breakpoint := range set.Breakpoints {
+			name := breakpoint.InstructionReference
+			if address, ok := Symbols.Resolve(name); ok {
+				name = fmt.Sprintf("0x%04X", address+uint16(breakpoint.Offset))
+			}
+			names = append(names, name)
+		}
+		return map[string]interface{}{"breakpoints": server.setAddressBreakpoints(dapInstructionBreakpoints, names)}, nil
+	case "setExceptionBreakpoints":
+		return map[string]interface{}{"breakpoints": []dapBreakpoint{}}, nil
+	case "configurationDone", "disconnect", "terminate":
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThread, "name": "CPU"}},
+		}, nil
+	case "stackTrace":
+		return server.stackTrace(), nil
+	case "scopes":
+		return map[string]interface{}{"scopes": []map[string]interface{}{
+			{"name": "Registers", "variablesReference": dapRegisters, "expensive": false},
+			{"name": "Flags", "variablesReference": dapFlags, "expensive": false},
+		}}, nil
+	case "variables":
+		var variables struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := arguments(&variables); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": server.variables(variables.VariablesReference)}, nil
+	case "evaluate":
+		var evaluate struct {
+			Expression string `json:"expression"`
+		}
+		if err := arguments(&evaluate); err != nil {
+			return nil, err
+		}
+		return server.evaluate(evaluate.Expression)
+	case "readMemory":
+		var read struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Count           int    `json:"count"`
+		}
+		if err := arguments(&read); err != nil {
+			return nil, err
+		}
+		return server.readMemory(read.MemoryReference, read.Offset, read.Count)
+	case "writeMemory":
+		var write struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Data            string `json:"data"`
+		}
+		if err := arguments(&write); err != nil {
+			return nil, err
+		}
+		return server.writeMemory(write.MemoryReference, write.Offset, write.Data)
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true}, server.resume(dapContinue)
+	case "next":
+		return nil, server.resume(dapNext)
+	case "stepIn":
+		return nil, server.resume(dapStepIn)
+	case "stepOut":
+		return nil, server.resume(dapStepOut)
+	case "pause":
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		if !server.running {
+			return nil, ErrDAPNotStopped
+		}
+		close(server.pause)
+		server.pause = nil
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", request.Command)
+}
+
+// launch loads the ROM with its symbols and resets the machine, which waits
+// for configurationDone to run
+func (server *DAPServerType) launch(program, symbols, sourceRoot string, stopOnEntry bool) error {
+	if err := server.system.OpenROM(program, ""); err != nil {
+		return err
+	}
+	CPU.Call(func() {
+		CPU.Reset()
+		// serial output is sent to the client rather than down a link cable
+		Serial.Peer = &SerialPrinter
+	})
+	server.stopOnEntry = stopOnEntry
+
+	// OpenROM loaded the symbols beside the ROM
+	if symbols != "" {
+		table, err := ReadSymbols(symbols)
+		if err != nil {
+			return err
+		}
+		Symbols = table
+		Logger.Logf(LogTypes.INFO, "DAP: %d labels in %s\n", table.Len(), symbols)
+	}
+	if sourceRoot != "" {
+		err := filepath.Walk(sourceRoot, func(location string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && dapSourceExtensions[strings.ToLower(filepath.Ext(location))] {
+				server.scanSource(location)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanSource finds the labels defined in a source file, named as RGBDS names
+// them in symbol files
+func (server *DAPServerType) scanSource(location string) []dapLabel {
+	location = filepath.Clean(location)
+	if labels, ok := server.sourceLabels[location]; ok {
+		return labels
+	}
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		Logger.Log(LogTypes.WARNING, "DAP: Error reading source", err)
+		return nil
+	}
+	var labels []dapLabel
+	scope := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		match := dapLabelPattern.FindStringSubmatch(line)
+		if match == nil {
+			match = dapLocalPattern.FindStringSubmatch(line)
+		}
+		if match == nil {
+			continue
+		}
+		name := match[1]
+		if strings.HasPrefix(name, ".") {
+			name = scope + name
+		} else if dot := strings.IndexByte(name, '.'); dot >= 0 {
+			scope = name[:dot]
+		} else {
+			scope = name
+		}
+		labels = append(labels, dapLabel{name: name, line: i + 1})
+		server.labels[name] = dapLocation{path: location, line: i + 1}
+	}
+	server.sourceLabels[location] = labels
+	return labels
+}
+
+// setLineBreakpoints replaces the breakpoints of a source file, each moving to
+// the label on or above its line
+func (server *DAPServerType) setLineBreakpoints(location string, lines []int) []dapBreakpoint {
+	labels := server.scanSource(location)
+	source := &dapSource{Name: filepath.Base(location), Path: location}
+	breakpoints := []dapBreakpoint{}
+	var addresses []uint16
+	for _, line := range lines {
+		breakpoint := dapBreakpoint{Line: line, Source: source,
+			Message: "no label from the symbol file on or above this line"}
+		i := sort.Search(len(labels), func(i int) bool { return labels[i].line > line })
+		for i--; i >= 0; i-- {
+			if symbol, ok := Symbols.Lookup(labels[i].name); ok && symbolMapped(symbol) {
+				breakpoint = dapBreakpoint{Verified: true, Line: labels[i].line, Source: source,
+					InstructionReference: fmt.Sprintf("0x%04X", symbol.Address)}
+				addresses = append(addresses, symbol.Address)
+				break
+			}
+		}
+		breakpoints = append(breakpoints, breakpoint)
+	}
+	server.breakpoints[filepath.Clean(location)] = addresses
+	server.applyBreakpoints()
+	return breakpoints
+}
+
+// setAddressBreakpoints replaces the breakpoints of a kind, each a label or an
+// address such as 0x0150 or $0150
+func (server *DAPServerType) setAddressBreakpoints(kind string, names []string) []dapBreakpoint {
+	breakpoints := []dapBreakpoint{}
+	var addresses []uint16
+	for _, name := range names {
+		address, ok := Symbols.Resolve(name)
+		if !ok {
+			breakpoints = append(breakpoints, dapBreakpoint{Message: "unknown label or address " + name})
+			continue
+		}
+		breakpoints = append(breakpoints, dapBreakpoint{Verified: true,
+			InstructionReference: fmt.Sprintf("0x%04X", address)})
+		addresses = append(addresses, address)
+	}
+	server.breakpoints[kind] = addresses
+	server.applyBreakpoints()
+	return breakpoints
+}
+
+// applyBreakpoints replaces CPU.BREAKPOINTS with the breakpoints of every kind
+func (server *DAPServerType) applyBreakpoints() {
+	CPU.Call(func() {
+		for address := range CPU.BREAKPOINTS {
+			delete(CPU.BREAKPOINTS, address)
+		}
+		for _, addresses := range server.breakpoints {
+			for _, address := range addresses {
+				CPU.BREAKPOINTS[address] = true
+			}
+		}
+	})
+}
+
+func (server *DAPServerType) stackTrace() map[string]interface{} {
+	var pc uint16
+	CPU.Call(func() { pc = CPU.REGISTERS.PC })
+	frame := map[string]interface{}{
+		"id":                          1,
+		"name":                        fmt.Sprintf("0x%04X", pc),
+		"line":                        0,
+		"column":                      0,
+		"instructionPointerReference": fmt.Sprintf("0x%04X", pc),
+	}
+	if symbol, ok := Symbols.Nearest(pc); ok {
+		frame["name"] = Symbols.Label(pc)
+		if location, ok := server.labels[symbol.Name]; ok {
+			frame["source"] = dapSource{Name: filepath.Base(location.path), Path: location.path}
+			frame["line"] = location.line
+			frame["column"] = 1
+		}
+	}
+	return map[string]interface{}{"stackFrames": []interface{}{frame}, "totalFrames": 1}
+}
+
+func (server *DAPServerType) variables(reference int) []dapVariable {
+	variables := []dapVariable{}
+	CPU.Call(func() {
+		registers := CPU.REGISTERS
+		switch reference {
+		case dapRegisters:
+			for _, register := range []struct {
+				name  string
+				value uint16
+			}{{"AF", registers.AF}, {"BC", registers.BC}, {"DE", registers.DE},
+				{"HL", registers.HL}, {"SP", registers.SP}, {"PC", registers.PC}} {
+				variable := dapVariable{Name: register.name, Value: fmt.Sprintf("0x%04X", register.value)}
+				if register.name != "AF" {
+					variable.MemoryReference = variable.Value
+				}
+				if label := Symbols.Label(register.value); label != "" && register.name != "AF" {
+					variable.Value += " (" + label + ")"
+				}
+				variables = append(variables, variable)
+			}
+			for i, name := range []string{"A", "F", "B", "C", "D", "E", "H", "L"} {
+				value := []uint16{registers.AF, registers.BC, registers.DE, registers.HL}[i/2]
+				if i%2 == 0 {
+					value >>= 8
+				}
+				variables = append(variables, dapVariable{Name: name, Value: fmt.Sprintf("0x%02X", byte(value))})
+			}
+			variables = append(variables, dapVariable{Name: "Cycles", Value: strconv.FormatUint(CPU.CYCLES, 10)})
+		case dapFlags:
+			for i, name := range []string{"Z", "N", "H", "C"} {
+				variables = append(variables, dapVariable{Name: name,
+					Value: strconv.Itoa(int(registers.AF>>(7-uint(i))) & 1)})
+			}
+		}
+	})
+	return variables
+}
+
+// evaluate answers a register name, a label or an address with its value
+func (server *DAPServerType) evaluate(expression string) (interface{}, error) {
+	expression = strings.TrimSpace(expression)
+	var result string
+	var reference string
+	for _, variable := range server.variables(dapRegisters) {
+		if strings.EqualFold(variable.Name, expression) {
+			result, reference = variable.Value, variable.MemoryReference
+		}
+	}
+	if result == "" {
+		address, ok := Symbols.Resolve(expression)
+		if !ok {
+			return nil, fmt.Errorf("unknown register, label or address %q", expression)
+		}
+		var value byte
+		CPU.Call(func() { value = MMU.ReadByte(address) })
+		reference = fmt.Sprintf("0x%04X", address)
+		result = fmt.Sprintf("%s: 0x%02X", reference, value)
+	}
+	return map[string]interface{}{"result": result, "variablesReference": 0, "memoryReference": reference}, nil
+}
+
+// memoryRange parses a memory reference with its offset and clamps count to
+// the address space
+func memoryRange(reference string, offset, count int) (uint16, int, error) {
+	start, err := strconv.ParseUint(reference, 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid memory reference %q", reference)
+	}
+	address := int(start) + offset
+	if address < 0 || address > 0xFFFF {
+		return 0, 0, fmt.Errorf("address 0x%X is outside memory", address)
+	}
+	if count < 0 {
+		return 0, 0, fmt.Errorf("invalid byte count %d", count)
+	}
+	if count > 0x10000-address {
+		count = 0x10000 - address
+	}
+	return uint16(address), count, nil
+}
+
+func (server *DAPServerType) readMemory(reference string, offset, count int) (interface{}, error) {
+	address, length, err := memoryRange(reference, offset, count)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	CPU.Call(func() {
+		for i := range data {
+			data[i] = MMU.ReadByte(address + uint16(i))
+		}
+	})
+	return map[string]interface{}{
+		"address":         fmt.Sprintf("0x%04X", address),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": count - length,
+	}, nil
+}
+
+func (server *DAPServerType) writeMemory(reference string, offset int, encoded string) (interface{}, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	address, length, err := memoryRange(reference, offset, len(data))
+	if err != nil {
+		return nil, err
+	}
+	CPU.Call(func() {
+		for i := 0; i < length; i++ {
+			MMU.WriteByte(address+uint16(i), data[i])
+		}
+	})
+	return map[string]interface{}{"bytesWritten": length}, nil
+}
+
+// resume runs the machine until it stops, then sends the stopped event
+func (server *DAPServerType) resume(mode int) error {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if server.running {
+		return ErrDAPRunning
+	}
+	server.running = true
+	pause := make(chan struct{})
+	server.pause = pause
+	go func() {
+		reason, text := server.run(mode, pause)
+		server.lock.Lock()
+		stopping := server.stopping
+		server.running = false
+		server.pause = nil
+		server.lock.Unlock()
+		server.sendSerial()
+		if reason == "" || stopping {
+			return
+		}
+		body := map[string]interface{}{"reason": reason, "threadId": dapThread, "allThreadsStopped": true}
+		if text != "" {
+			body["text"] = text
+			body["description"] = text
+		}
+		server.event("stopped", body)
+	}()
+	return nil
+}
+
+// stop ends a run without sending the stopped event
+func (server *DAPServerType) stop() {
+	server.lock.Lock()
+	running := server.running
+	server.stopping = true
+	if server.pause != nil {
+		close(server.pause)
+		server.pause = nil
+	}
+	server.lock.Unlock()
+	for running {
+		time.Sleep(time.Millisecond)
+		server.lock.Lock()
+		running = server.running
+		server.lock.Unlock()
+	}
+	server.lock.Lock()
+	server.stopping = false
+	server.lock.Unlock()
+}
+
+// run executes instructions at the speed of the hardware, returning why it stopped
+func (server *DAPServerType) run(mode int, pause <-chan struct{}) (reason, text string) {
+	start := time.Now()
+	var cycles uint64
+	var target, sp uint16
+	CPU.Call(func() {
+		cycles = CPU.CYCLES
+		sp = CPU.REGISTERS.SP
+		if mode == dapNext {
+			// stepping over a call runs until it returns to the next instruction
+			instruction := CPU.INSTRUCTIONS[MMU.ReadByte(CPU.REGISTERS.PC)]
+			if strings.HasPrefix(instruction.Name, "CALL") || strings.HasPrefix(instruction.Name, "RST") {
+				target = CPU.REGISTERS.PC + 1 + uint16(instruction.NumOperands)
+			} else {
+				mode = dapStepIn
+			}
+		}
+	})
+	for {
+		CPU.Call(func() { reason, text = server.execute(mode, target, sp) })
+		if reason != "" {
+			return reason, text
+		}
+		select {
+		case <-pause:
+			return "pause", ""
+		default:
+		}
+		server.sendSerial()
+		var elapsed uint64
+		CPU.Call(func() { elapsed = CPU.CYCLES - cycles })
+		if ahead := time.Duration(elapsed)*time.Second/ClockSpeed - time.Since(start); ahead > 0 {
+			time.Sleep(ahead)
+		}
+	}
+}
+
+// execute runs instructions for up to a frame, returning why the machine
+// stopped or "" to carry on
+func (server *DAPServerType) execute(mode int, target, sp uint16) (reason, text string) {
+	defer func() {
+		if r := recover(); r != nil {
+			reason, text = "exception", fmt.Sprintf("instruction at 0x%04X failed: %v", CPU.REGISTERS.PC, r)
+		}
+	}()
+	end := CPU.CYCLES + FrameCycles
+	for {
+		instruction, err := CPU.Fetch()
+		if err != nil {
+			return "exception", err.Error()
+		}
+		Reverse.Record()
+		Trace.Record()
+		CPU.Execute(instruction)
+		Movie.Update()
+		Rewind.Update()
+
+		switch {
+		case mode == dapStepIn,
+			mode == dapNext && CPU.REGISTERS.PC == target,
+			mode == dapStepOut && strings.HasPrefix(instruction.Name, "RET") && CPU.REGISTERS.SP > sp:
+			return "step", ""
+		}
+		if enabled, ok := CPU.BREAKPOINTS[CPU.REGISTERS.PC]; ok && enabled {
+			return "breakpoint", ""
+		}
+		if CPU.CYCLES >= end {
+			return "", ""
+		}
+	}
+}
+
+// sendSerial sends the serial output written since the last call
+func (server *DAPServerType) sendSerial() {
+	output := SerialPrinter.Output()
+	if len(output) < server.serialLength {
+		server.serialLength = 0
+	}
+	if len(output) == server.serialLength {
+		return
+	}
+	server.event("output", map[string]interface{}{"category": "stdout", "output": output[server.serialLength:]})
+	server.serialLength = len(output)
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dapTestSource is the source of saveStateProgram, as RGBDS would assemble it
+const dapTestSource = `SECTION "Main", ROM0[$150]
+Start:
+	ld hl, $8000
+.loop
+	ld [hl+], a
+	inc a
+	ldh [$43], a
+	jp .loop
+`
+
+// dapClient sends requests to Serve and reads its messages
+type dapClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	seq    int
+}
+
+func (client *dapClient) request(command string, arguments interface{}) {
+	client.seq++
+	data, _ := json.Marshal(map[string]interface{}{
+		"seq": client.seq, "type": "request", "command": command, "arguments": arguments,
+	})
+	fmt.Fprintf(client.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// next reads a message, skipping output events
+func (client *dapClient) next() map[string]interface{} {
+	for {
+		data, err := readDAPMessage(client.reader)
+		if err != nil {
+			client.t.Fatalf("DAP: %v", err)
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			client.t.Fatalf("DAP: %v", err)
+		}
+		if message["event"] != "output" {
+			return message
+		}
+	}
+}
+
+// call sends a request and returns the body of its response, which must succeed
+func (client *dapClient) call(command string, arguments interface{}) map[string]interface{} {
+	client.request(command, arguments)
+	response := client.next()
+	if response["type"] != "response" || response["command"] != command || response["success"] != true {
+		client.t.Fatalf("DAP: %s replied %v", command, response)
+	}
+	body, _ := response["body"].(map[string]interface{})
+	return body
+}
+
+// expectEvent reads an event, returning its body
+func (client *dapClient) expectEvent(event string) map[string]interface{} {
+	message := client.next()
+	if message["type"] != "event" || message["event"] != event {
+		client.t.Fatalf("DAP: expected the %s event, got %v", event, message)
+	}
+	body, _ := message["body"].(map[string]interface{})
+	return body
+}
+
+func (client *dapClient) frame() map[string]interface{} {
+	frames := client.call("stackTrace", map[string]interface{}{"threadId": dapThread})["stackFrames"].([]interface{})
+	return frames[0].(map[string]interface{})
+}
+
+// TestDAPServer launches a ROM, stops at a line breakpoint moved to its label,
+// steps and reads the registers and memory
+func TestDAPServer(t *testing.T) {
+	defer func() { Symbols = ParseSymbols(nil) }()
+	directory := t.TempDir()
+	program := filepath.Join(directory, "main.gb")
+	source := filepath.Join(directory, "main.asm")
+	files := map[string]string{
+		program:                              string(programROM(saveStateProgram)),
+		filepath.Join(directory, "main.sym"): "; File generated by rgblink\n00:0150 Start\n00:0153 Start.loop\n",
+		source:                               dapTestSource,
+	}
+	for location, data := range files {
+		if err := ioutil.WriteFile(location, []byte(data), 0644); err != nil {
+			t.Fatalf("DAP: %v", err)
+		}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	served := make(chan error)
+	go func() {
+		served <- DAPServer.Serve(&System, serverConn, serverConn)
+		serverConn.Close()
+	}()
+	defer clientConn.Close()
+	clientConn.SetDeadline(time.Now().Add(10 * time.Second))
+	client := &dapClient{t: t, conn: clientConn, reader: bufio.NewReader(clientConn)}
+
+	if body := client.call("initialize", map[string]interface{}{"adapterID": "freemegb"}); body["supportsReadMemoryRequest"] != true {
+		t.Errorf("DAP: capabilities %v", body)
+	}
+	client.call("launch", map[string]interface{}{"program": program, "sourceRoot": directory, "stopOnEntry": true})
+	client.expectEvent("initialized")
+
+	breakpoints := client.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": source},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 6}, map[string]interface{}{"line": 1}},
+	})["breakpoints"].([]interface{})
+	moved := breakpoints[0].(map[string]interface{})
+	if moved["verified"] != true || moved["line"] != float64(4) || moved["instructionReference"] != "0x0153" {
+		t.Errorf("DAP: line 6 breakpoint %v, expected line 4 at 0x0153", moved)
+	}
+	if breakpoints[1].(map[string]interface{})["verified"] != false {
+		t.Errorf("DAP: line 1 breakpoint has no label and should not be verified")
+	}
+	client.call("setExceptionBreakpoints", map[string]interface{}{"filters": []string{}})
+	client.call("configurationDone", nil)
+	if body := client.expectEvent("stopped"); body["reason"] != "entry" {
+		t.Errorf("DAP: stopped for %v, expected entry", body["reason"])
+	}
+	if frame := client.frame(); frame["name"] != "0x0100" {
+		t.Errorf("DAP: frame %v, expected 0x0100", frame["name"])
+	}
+
+	client.call("continue", map[string]interface{}{"threadId": dapThread})
+	if body := client.expectEvent("stopped"); body["reason"] != "breakpoint" {
+		t.Errorf("DAP: stopped for %v, expected breakpoint", body["reason"])
+	}
+	frame := client.frame()
+	if frame["name"] != "Start.loop" || frame["line"] != float64(4) {
+		t.Errorf("DAP: frame %v at line %v, expected Start.loop at line 4", frame["name"], frame["line"])
+	}
+	if path := frame["source"].(map[string]interface{})["path"]; path != source {
+		t.Errorf("DAP: frame source %v, expected %s", path, source)
+	}
+
+	values := map[string]interface{}{}
+	for _, variable := range client.call("variables", map[string]interface{}{"variablesReference": dapRegisters})["variables"].([]interface{}) {
+		variable := variable.(map[string]interface{})
+		values[variable["name"].(string)] = variable["value"]
+	}
+	if values["PC"] != "0x0153 (Start.loop)" || values["HL"] != "0x8000" {
+		t.Errorf("DAP: registers %v", values)
+	}
+
+	client.call("next", map[string]interface{}{"threadId": dapThread})
+	if body := client.expectEvent("stopped"); body["reason"] != "step" {
+		t.Errorf("DAP: stopped for %v, expected step", body["reason"])
+	}
+	if frame := client.frame(); frame["name"] != "Start.loop+0x1" {
+		t.Errorf("DAP: frame %v, expected Start.loop+0x1", frame["name"])
+	}
+	if body := client.call("evaluate", map[string]interface{}{"expression": "hl"}); body["result"] != "0x8001" {
+		t.Errorf("DAP: HL evaluates to %v, expected 0x8001", body["result"])
+	}
+
+	client.call("writeMemory", map[string]interface{}{"memoryReference": "0x8000", "data": "q80="})
+	if body := client.call("readMemory", map[string]interface{}{"memoryReference": "0x7FFF", "offset": 1, "count": 2}); body["address"] != "0x8000" || body["data"] != "q80=" {
+		t.Errorf("DAP: read %v", body)
+	}
+	client.request("readMemory", map[string]interface{}{"memoryReference": "0x8000", "count": -1})
+	if response := client.next(); response["success"] != false {
+		t.Errorf("DAP: reading -1 bytes replied %v, expected an error", response)
+	}
+
+	client.call("disconnect", nil)
+	if err := <-served; err != nil {
+		t.Errorf("DAP: %v", err)
+	}
+}
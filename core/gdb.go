@@ -0,0 +1,598 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GDBPortDefault is the TCP port the GDB server listens on
+const GDBPortDefault = 2345
+
+// gdbPacketSize is the largest packet the server accepts, sent to gdb in qSupported
+const gdbPacketSize = 0x1000
+
+// gdbRegisters is the number of registers in a g packet.
+//
+// There is no Game Boy target in gdb, the registers follow its z80 target so
+// gdb-multiarch can attach with "set architecture z80": AF, BC, DE, HL, SP,
+// PC, IX, IY, AF', BC', DE', HL' and IR, 16 bits each in little endian.
+// The registers the Game Boy does not have read 0 and ignore writes.
+const gdbRegisters = 13
+
+// Stop replies, the signal that stopped the machine
+const (
+	gdbStopInterrupt = "S02"
+	gdbStopIllegal   = "S04"
+	gdbStopTrap      = "S05"
+	gdbStopFault     = "S0B"
+)
+
+// watchpoint kinds, as bits
+const (
+	watchWrite byte = 1 << iota
+	watchRead
+)
+
+// Errors returned when the GDB server cannot start
+var (
+	ErrGDBRunning = errors.New("the GDB server is already running")
+)
+
+// GDBServerType is a GDB remote serial protocol server on localhost, so gdb or
+// a debug adapter built on it can debug the ROM being run.
+//
+//	GDB Server Structure
+//	================
+//	---> Listener accepting one debugger at a time
+//	---> Connection of the attached debugger
+//	---> Watchpoints, checked by the MMU on every access
+//	================
+//
+// While a debugger is attached Run is paused and the server executes the
+// instructions itself, stopping at CPU.BREAKPOINTS, watchpoints and unknown
+// instructions. Detaching lets Run carry on from where the debugger left it.
+type GDBServerType struct {
+	lock     sync.Mutex
+	listener net.Listener
+	conn     net.Conn
+
+	// writeLock orders the packets and acknowledgements sent to the debugger
+	writeLock sync.Mutex
+	noAck     bool
+
+	// paused is CPU.PAUSED before the debugger attached
+	paused bool
+
+	watching    bool
+	watchpoints map[uint16]byte
+	// watchHit is the stop reply for the watchpoint hit by the executing instruction
+	watchHit string
+	// peeking stops the watchpoints firing for reads made by the server itself
+	peeking bool
+	// codeStart and codeEnd are the bytes of the executing instruction, whose
+	// reads are not data accesses
+	codeStart uint16
+	codeEnd   uint16
+}
+
+// GDBServer is the exported object used in the system
+//
+// GDBServer is exported so the UI can start and stop it
+var GDBServer = GDBServerType{}
+
+// Start listens for a debugger on localhost
+func (server *GDBServerType) Start(port int) error {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if server.listener != nil {
+		return ErrGDBRunning
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	server.listener = listener
+	Logger.Logf(LogTypes.INFO, "GDB: waiting for a debugger on %s\n", listener.Addr())
+	go server.serve(listener)
+	return nil
+}
+
+// Running reports whether the server is listening
+func (server *GDBServerType) Running() bool {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.listener != nil
+}
+
+// Addr returns the address the server listens on, nil when it is stopped
+func (server *GDBServerType) Addr() net.Addr {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Addr()
+}
+
+// Stop closes the listener and disconnects the debugger, letting Run carry on
+func (server *GDBServerType) Stop() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if server.listener != nil {
+		server.listener.Close()
+		server.listener = nil
+	}
+	if server.conn != nil {
+		server.conn.Close()
+	}
+}
+
+func (server *GDBServerType) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		server.lock.Lock()
+		if server.listener != listener {
+			server.lock.Unlock()
+			conn.Close()
+			return
+		}
+		server.conn = conn
+		server.lock.Unlock()
+
+		Logger.Logf(LogTypes.INFO, "GDB: %s attached\n", conn.RemoteAddr())
+		server.session(conn)
+		Logger.Logf(LogTypes.INFO, "GDB: %s detached\n", conn.RemoteAddr())
+
+		server.lock.Lock()
+		server.conn = nil
+		server.lock.Unlock()
+	}
+}
+
+// session runs the commands of one debugger until it detaches or disconnects
+func (server *GDBServerType) session(conn net.Conn) {
+	defer conn.Close()
+	server.noAck = false
+	CPU.Call(func() {
+		server.paused = CPU.PAUSED
+		CPU.PAUSED = true
+	})
+	defer CPU.Call(func() {
+		server.setWatchpoints(nil)
+		CPU.PAUSED = server.paused
+	})
+
+	packets := make(chan string)
+	interrupts := make(chan struct{}, 1)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go server.read(conn, packets, interrupts, done, quit)
+
+	for packet := range packets {
+		var reply string
+		switch {
+		case packet == "":
+		case packet == "D":
+			server.send(conn, "OK")
+			return
+		case packet == "k":
+			return
+		case packet[0] == 'c' || packet[0] == 's':
+			step := packet[0] == 's'
+			if packet[1:] != "" {
+				address, err := strconv.ParseUint(packet[1:], 16, 16)
+				if err != nil {
+					server.send(conn, "E01")
+					continue
+				}
+				CPU.Call(func() { CPU.REGISTERS.PC = uint16(address) })
+			}
+			// an interrupt sent while stopped has nothing to stop
+			select {
+			case <-interrupts:
+			default:
+			}
+			reply = server.resume(step, interrupts, done)
+		case packet == "QStartNoAckMode":
+			server.writeLock.Lock()
+			server.noAck = true
+			server.writeLock.Unlock()
+			reply = "OK"
+		default:
+			CPU.Call(func() { reply = server.handle(packet) })
+		}
+		server.send(conn, reply)
+	}
+}
+
+// read splits what the debugger sends into packets, acknowledging each one,
+// and interrupts, the 0x03 byte sent to stop a running machine, until the
+// connection closes or the session quits
+func (server *GDBServerType) read(conn net.Conn, packets chan<- string, interrupts chan<- struct{}, done, quit chan struct{}) {
+	defer close(done)
+	defer close(packets)
+	reader := bufio.NewReader(conn)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0x03:
+			select {
+			case interrupts <- struct{}{}:
+			default:
+			}
+		case '$':
+			data, err := reader.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			var checksum [2]byte
+			if checksum[0], err = reader.ReadByte(); err != nil {
+				return
+			}
+			if checksum[1], err = reader.ReadByte(); err != nil {
+				return
+			}
+			sum, err := strconv.ParseUint(string(checksum[:]), 16, 8)
+			server.writeLock.Lock()
+			if !server.noAck {
+				if err != nil || byte(sum) != gdbChecksum(data) {
+					conn.Write([]byte{'-'})
+					server.writeLock.Unlock()
+					continue
+				}
+				conn.Write([]byte{'+'})
+			}
+			server.writeLock.Unlock()
+			select {
+			case packets <- data:
+			case <-quit:
+				return
+			}
+		}
+		// acknowledgements from the debugger are not checked, the connection is reliable
+	}
+}
+
+// send writes a packet to the debugger
+func (server *GDBServerType) send(conn net.Conn, data string) {
+	server.writeLock.Lock()
+	defer server.writeLock.Unlock()
+	fmt.Fprintf(conn, "$%s#%02x", data, gdbChecksum(data))
+}
+
+func gdbChecksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// resume executes instructions at the speed of the hardware until the machine
+// stops, returning the stop reply. step stops it after one instruction.
+func (server *GDBServerType) resume(step bool, interrupts <-chan struct{}, done <-chan struct{}) string {
+	start := time.Now()
+	var cycles uint64
+	CPU.Call(func() { cycles = CPU.CYCLES })
+	for {
+		var reply string
+		CPU.Call(func() { reply = server.execute(step) })
+		if reply != "" {
+			return reply
+		}
+		select {
+		case <-interrupts:
+			return gdbStopInterrupt
+		case <-done:
+			return ""
+		default:
+		}
+		var elapsed uint64
+		CPU.Call(func() { elapsed = CPU.CYCLES - cycles })
+		if ahead := time.Duration(elapsed)*time.Second/ClockSpeed - time.Since(start); ahead > 0 {
+			time.Sleep(ahead)
+		}
+	}
+}
+
+// execute runs instructions for up to a frame, returning the stop reply when
+// the machine stops or "" to carry on
+func (server *GDBServerType) execute(step bool) (reply string) {
+	defer func() {
+		if r := recover(); r != nil {
+			Logger.Logf(LogTypes.ERROR, "GDB: instruction at 0x%04X failed: %v\n", CPU.REGISTERS.PC, r)
+			server.peeking = false
+			reply = gdbStopFault
+		}
+	}()
+	end := CPU.CYCLES + FrameCycles
+	for {
+		server.peeking = true
+		instruction, err := CPU.Fetch()
+		if err != nil {
+			server.peeking = false
+			Logger.Log(LogTypes.ERROR, "GDB:", err)
+			return gdbStopIllegal
+		}
+		Reverse.Record()
+		Trace.Record()
+		server.peeking = false
+		server.codeStart = CPU.REGISTERS.PC
+		server.codeEnd = CPU.REGISTERS.PC + 1 + uint16(instruction.NumOperands)
+		server.watchHit = ""
+		CPU.Execute(instruction)
+		server.peeking = true
+		Movie.Update()
+		Rewind.Update()
+		server.peeking = false
+
+		if server.watchHit != "" {
+			return server.watchHit
+		}
+		if step {
+			return gdbStopTrap
+		}
+		if enabled, ok := CPU.BREAKPOINTS[CPU.REGISTERS.PC]; ok && enabled {
+			return gdbStopTrap
+		}
+		if CPU.CYCLES >= end {
+			return ""
+		}
+	}
+}
+
+// handle answers a packet that does not run the machine, called between instructions
+func (server *GDBServerType) handle(packet string) (reply string) {
+	defer func() {
+		if r := recover(); r != nil {
+			server.peeking = false
+			reply = "E01"
+		}
+	}()
+	switch packet[0] {
+	case '?':
+		return gdbStopTrap
+	case 'g':
+		var registers strings.Builder
+		for i := 0; i < gdbRegisters; i++ {
+			value := gdbRegister(i)
+			fmt.Fprintf(&registers, "%02x%02x", byte(value), byte(value>>8))
+		}
+		return registers.String()
+	case 'G':
+		if len(packet)-1 < gdbRegisters*4 {
+			return "E01"
+		}
+		for i := 0; i < gdbRegisters; i++ {
+			value, err := parseGDBShort(packet[1+i*4 : 5+i*4])
+			if err != nil {
+				return "E01"
+			}
+			setGDBRegister(i, value)
+		}
+		return "OK"
+	case 'p':
+		index, err := strconv.ParseUint(packet[1:], 16, 8)
+		if err != nil || index >= gdbRegisters {
+			return "E01"
+		}
+		value := gdbRegister(int(index))
+		return fmt.Sprintf("%02x%02x", byte(value), byte(value>>8))
+	case 'P':
+		fields := strings.SplitN(packet[1:], "=", 2)
+		if len(fields) != 2 {
+			return "E01"
+		}
+		index, err := strconv.ParseUint(fields[0], 16, 8)
+		if err != nil || index >= gdbRegisters {
+			return "E01"
+		}
+		value, err := parseGDBShort(fields[1])
+		if err != nil {
+			return "E01"
+		}
+		setGDBRegister(int(index), value)
+		return "OK"
+	case 'm':
+		address, length, _, err := parseGDBRange(packet[1:])
+		if err != nil || length > gdbPacketSize/2 {
+			return "E01"
+		}
+		var memory strings.Builder
+		server.peeking = true
+		for i := 0; i < length; i++ {
+			fmt.Fprintf(&memory, "%02x", MMU.ReadByte(address+uint16(i)))
+		}
+		server.peeking = false
+		return memory.String()
+	case 'M':
+		address, length, data, err := parseGDBRange(packet[1:])
+		if err != nil || len(data) != length*2 {
+			return "E01"
+		}
+		server.peeking = true
+		for i := 0; i < length; i++ {
+			value, err := strconv.ParseUint(data[i*2:i*2+2], 16, 8)
+			if err != nil {
+				server.peeking = false
+				return "E01"
+			}
+			MMU.WriteByte(address+uint16(i), byte(value))
+		}
+		server.peeking = false
+		return "OK"
+	case 'Z', 'z':
+		return server.handleBreakpoint(packet)
+	case 'H':
+		return "OK"
+	case 'q':
+		switch {
+		case strings.HasPrefix(packet, "qSupported"):
+			return fmt.Sprintf("PacketSize=%x;QStartNoAckMode+", gdbPacketSize)
+		case packet == "qAttached":
+			return "1"
+		case packet == "qC":
+			return "QC1"
+		case packet == "qfThreadInfo":
+			return "m1"
+		case packet == "qsThreadInfo":
+			return "l"
+		}
+	}
+	// an empty reply tells the debugger the command is not supported
+	return ""
+}
+
+// handleBreakpoint inserts or removes a breakpoint or watchpoint,
+// Z<type>,<address>,<kind> and z<type>,<address>,<kind>
+func (server *GDBServerType) handleBreakpoint(packet string) string {
+	insert := packet[0] == 'Z'
+	fields := strings.Split(packet[1:], ",")
+	if len(fields) < 3 {
+		return "E01"
+	}
+	address, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	length, err := strconv.ParseUint(fields[2], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	var kind byte
+	switch fields[0] {
+	case "0", "1":
+		// software and hardware breakpoints are the same, neither changes memory
+		if insert {
+			CPU.BREAKPOINTS[uint16(address)] = true
+		} else {
+			delete(CPU.BREAKPOINTS, uint16(address))
+		}
+		return "OK"
+	case "2":
+		kind = watchWrite
+	case "3":
+		kind = watchRead
+	case "4":
+		kind = watchRead | watchWrite
+	default:
+		return ""
+	}
+	watchpoints := map[uint16]byte{}
+	for watched, kinds := range server.watchpoints {
+		watchpoints[watched] = kinds
+	}
+	for i := uint16(0); i < uint16(length) || i == 0; i++ {
+		if insert {
+			watchpoints[uint16(address)+i] |= kind
+		} else if watchpoints[uint16(address)+i] &^= kind; watchpoints[uint16(address)+i] == 0 {
+			delete(watchpoints, uint16(address)+i)
+		}
+	}
+	server.setWatchpoints(watchpoints)
+	return "OK"
+}
+
+func (server *GDBServerType) setWatchpoints(watchpoints map[uint16]byte) {
+	server.watchpoints = watchpoints
+	server.watching = len(watchpoints) != 0
+}
+
+// watch is called by the MMU on each access while there are watchpoints
+func (server *GDBServerType) watch(address uint16, kind byte) {
+	if server.peeking || server.watchHit != "" || server.watchpoints[address]&kind == 0 {
+		return
+	}
+	if kind == watchRead && address-server.codeStart < server.codeEnd-server.codeStart {
+		return
+	}
+	switch server.watchpoints[address] {
+	case watchWrite:
+		server.watchHit = fmt.Sprintf("T05watch:%04x;", address)
+	case watchRead:
+		server.watchHit = fmt.Sprintf("T05rwatch:%04x;", address)
+	default:
+		server.watchHit = fmt.Sprintf("T05awatch:%04x;", address)
+	}
+}
+
+func gdbRegister(index int) uint16 {
+	registers := CPU.REGISTERS
+	switch index {
+	case 0:
+		return registers.AF
+	case 1:
+		return registers.BC
+	case 2:
+		return registers.DE
+	case 3:
+		return registers.HL
+	case 4:
+		return registers.SP
+	case 5:
+		return registers.PC
+	}
+	return 0
+}
+
+func setGDBRegister(index int, value uint16) {
+	registers := CPU.REGISTERS
+	switch index {
+	case 0:
+		// the low bits of F always read 0
+		registers.AF = value & 0xFFF0
+	case 1:
+		registers.BC = value
+	case 2:
+		registers.DE = value
+	case 3:
+		registers.HL = value
+	case 4:
+		registers.SP = value
+	case 5:
+		registers.PC = value
+	}
+}
+
+// parseGDBShort parses a 16 bit register, sent in little endian
+func parseGDBShort(data string) (uint16, error) {
+	if len(data) != 4 {
+		return 0, strconv.ErrSyntax
+	}
+	value, err := strconv.ParseUint(data, 16, 16)
+	return uint16(value>>8 | value<<8), err
+}
+
+// parseGDBRange parses <address>,<length> with an optional :<data>
+func parseGDBRange(data string) (address uint16, length int, rest string, err error) {
+	if i := strings.IndexByte(data, ':'); i >= 0 {
+		data, rest = data[:i], data[i+1:]
+	}
+	fields := strings.Split(data, ",")
+	if len(fields) != 2 {
+		return 0, 0, "", strconv.ErrSyntax
+	}
+	start, err := strconv.ParseUint(fields[0], 16, 16)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	size, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return uint16(start), int(size), rest, nil
+}
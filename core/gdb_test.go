@@ -0,0 +1,98 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// gdbCommand sends a packet and returns the reply, checking both acknowledgements
+func gdbCommand(t *testing.T, conn net.Conn, reader *bufio.Reader, packet string) string {
+	fmt.Fprintf(conn, "$%s#%02x", packet, gdbChecksum(packet))
+	if ack, err := reader.ReadByte(); err != nil || ack != '+' {
+		t.Fatalf("GDB: %q was not acknowledged: %q %v", packet, ack, err)
+	}
+	if start, err := reader.ReadByte(); err != nil || start != '$' {
+		t.Fatalf("GDB: no reply to %q: %q %v", packet, start, err)
+	}
+	data, err := reader.ReadString('#')
+	if err != nil {
+		t.Fatalf("GDB: reply to %q: %v", packet, err)
+	}
+	checksum := make([]byte, 2)
+	if _, err := reader.Read(checksum); err != nil {
+		t.Fatalf("GDB: reply to %q: %v", packet, err)
+	}
+	data = data[:len(data)-1]
+	if fmt.Sprintf("%02x", gdbChecksum(data)) != string(checksum) {
+		t.Errorf("GDB: reply to %q has checksum %s", packet, checksum)
+	}
+	conn.Write([]byte{'+'})
+	return data
+}
+
+// TestGDBServer attaches to the running program, stops it at a breakpoint and
+// a watchpoint, steps and changes memory
+func TestGDBServer(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	if err := GDBServer.Start(0); err != nil {
+		t.Fatalf("GDB: %v", err)
+	}
+	defer GDBServer.Stop()
+	conn, err := net.Dial("tcp", GDBServer.Addr().String())
+	if err != nil {
+		t.Fatalf("GDB: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	commands := []struct {
+		packet string
+		reply  string
+	}{
+		{"?", "S05"},
+		{"p5", "0001"},
+		{"Z0,153,1", "OK"},
+		{"c", "S05"},
+		{"p5", "5301"},
+		{"p3", "0080"},
+		{"z0,153,1", "OK"},
+		// the first pass writes 0x8000, the second 0x8001
+		{"Z2,8001,1", "OK"},
+		{"c", "T05watch:8001;"},
+		{"p5", "5401"},
+		{"z2,8001,1", "OK"},
+		{"s", "S05"},
+		{"p5", "5501"},
+		{"M8000,2:abcd", "OK"},
+		{"m8000,2", "abcd"},
+		{"P3=3412", "OK"},
+		{"p3", "3412"},
+		{"p20", "E01"},
+		{"vMustReplyEmpty", ""},
+	}
+	for _, command := range commands {
+		if reply := gdbCommand(t, conn, reader, command.packet); reply != command.reply {
+			t.Errorf("GDB: %q replied %q, expected %q", command.packet, reply, command.reply)
+		}
+	}
+	if registers := gdbCommand(t, conn, reader, "g"); len(registers) != gdbRegisters*4 {
+		t.Errorf("GDB: g replied %d registers", len(registers)/4)
+	}
+	if CPU.REGISTERS.HL != 0x1234 {
+		t.Errorf("GDB: HL is 0x%04X, expected 0x1234", CPU.REGISTERS.HL)
+	}
+
+	if reply := gdbCommand(t, conn, reader, "D"); reply != "OK" {
+		t.Errorf("GDB: D replied %q", reply)
+	}
+	for i := 0; i < 100 && CPU.PAUSED; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if CPU.PAUSED {
+		t.Errorf("GDB: CPU still paused after detaching")
+	}
+}
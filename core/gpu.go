@@ -1,42 +1,66 @@
 package core
 
 import (
-	"fmt"
-
-	"github.com/go-gl/gl/v4.6-core/gl"
-	"github.com/gotk3/gotk3/gtk"
+	"sync"
 )
 
-const VertexSource string = `#version 460 core
-    in vec4 position;
+// ScreenWidth is the width of the Game Boy LCD in pixels
+const ScreenWidth = 160
 
-    void main()
-    {
-        gl_Position = position;
-    }`
+// ScreenHeight is the height of the Game Boy LCD in pixels
+const ScreenHeight = 144
 
-const FragmentSource string = `#version 460 core
-	in	vec4 inputColor;
-	out vec4 outputColor;
+// GPU modes as reported in the lower two bits of STAT
+const (
+	gpuModeHBlank   byte = 0
+	gpuModeVBlank   byte = 1
+	gpuModeOAM      byte = 2
+	gpuModeTransfer byte = 3
+)
 
-	void main()
-	{
-	    outputColor = vec4(1.0, 0.0, 0.0, 1.0);
-	}`
+// Dot clocks spent in each mode of a visible scanline
+const (
+	gpuCyclesOAM      = 80
+	gpuCyclesTransfer = 172
+	gpuCyclesLine     = 456
+	gpuLinesTotal     = 154
+)
 
 // GPUType is the structure to define what's inside a GPU
-//  GPU Structure
-//  ================
-//  ================
+//
+//	GPU Structure
+//	================
+//	---> LCD registers (LCDC, STAT, SCY, SCX, LY, LYC, WY, WX)
+//	---> Palette registers (BGP, OBP0, OBP1) mapping colours to shades
+//	---> Framebuffer being drawn and the last completed frame,
+//	     holding DMG shades or CGB 15-bit colours
+//	---> Palette mapping DMG shades to RGB
+//	================
 type GPUType struct {
 	control  byte
+	status   byte
 	scrollX  byte
 	scrollY  byte
 	scanline byte
-	tick     byte
+	compare  byte
+	windowX  byte
+	windowY  byte
+	tick     int
+	mode     byte
+
+	bgPalette   byte
+	objPalette0 byte
+	objPalette1 byte
+
+	framebuffer [ScreenHeight][ScreenWidth]uint16
+	frame       [ScreenHeight][ScreenWidth]uint16
+	frameCGB    bool
+	frameLock   sync.Mutex
+	frames      uint64
+	palette     [4][3]byte
 
-	pos_buffer uint32
-	program    uint32
+	// OnFrame is called from the CPU thread whenever a frame is completed
+	OnFrame func()
 }
 
 // GPU is the exported object used in the system
@@ -47,94 +71,307 @@ type GPUType struct {
 	scrollX:  0x00,
 	scrollY:  0x00,
 	scanline: 0x00,
-	tick:     0x00,
+	tick:     0,
 
-	pos_buffer: 0,
-	program:    0,
+	bgPalette:   0xFC,
+	objPalette0: 0xFF,
+	objPalette1: 0xFF,
+
+	palette: Palettes[0].Colours,
 }
 
-func (gpu *GPUType) Init(glarea *gtk.GLArea) {
-	glarea.MakeCurrent()
+// Step advances the GPU by the given number of CPU cycles,
+// drawing each scanline into the framebuffer as it completes
+func (gpu *GPUType) Step(cycles int) {
+	if gpu.control&0x80 == 0 {
+		gpu.tick = 0
+		gpu.scanline = 0
+		gpu.setMode(gpuModeHBlank)
+		return
+	}
 
-	// Init OpenGL
-	if err := gl.Init(); err != nil {
-		panic(err)
+	gpu.tick += cycles
+	for gpu.tick >= gpuCyclesLine {
+		gpu.tick -= gpuCyclesLine
+		gpu.scanline++
+		if gpu.scanline == ScreenHeight {
+			gpu.setMode(gpuModeVBlank)
+			INTERRUPTS.flags |= 0x01
+			gpu.completeFrame()
+		} else if gpu.scanline == gpuLinesTotal {
+			gpu.scanline = 0
+		}
+		gpu.compareLine()
 	}
-	version := gl.GoStr(gl.GetString(gl.VERSION))
-	fmt.Println("OpenGL version:", version)
 
-	// Init Shaders
-	var fragmentShader, vertexShader uint32
-	fragmentShader = gl.CreateShader(gl.FRAGMENT_SHADER)
-	vertexShader = gl.CreateShader(gl.VERTEX_SHADER)
-	fragmentSrc, fragmentFree := gl.Strs(FragmentSource)
-	vertexSrc, vertexFree := gl.Strs(VertexSource)
-	gl.ShaderSource(fragmentShader, 1, fragmentSrc, nil)
-	gl.ShaderSource(vertexShader, 1, vertexSrc, nil)
+	if gpu.scanline >= ScreenHeight {
+		return
+	}
+	if gpu.tick < gpuCyclesOAM {
+		gpu.setMode(gpuModeOAM)
+	} else if gpu.tick < gpuCyclesOAM+gpuCyclesTransfer {
+		gpu.setMode(gpuModeTransfer)
+	} else if gpu.mode != gpuModeHBlank {
+		gpu.renderScanline()
+		gpu.setMode(gpuModeHBlank)
+		CGB.HBlank()
+	}
+}
 
-	gl.CompileShader(fragmentShader)
-	gl.CompileShader(vertexShader)
+func (gpu *GPUType) setMode(mode byte) {
+	if gpu.mode == mode {
+		return
+	}
+	gpu.mode = mode
+	// STAT interrupt sources for HBlank, VBlank and OAM are bits 3, 4 and 5
+	if mode != gpuModeTransfer && gpu.status&(0x08<<mode) != 0 {
+		INTERRUPTS.flags |= 0x02
+	}
+}
 
-	// Free GLSL shader strings
-	fragmentFree()
-	vertexFree()
+// compareLine updates the LY=LYC coincidence flag, requesting the STAT
+// interrupt when it becomes set
+func (gpu *GPUType) compareLine() {
+	if gpu.scanline == gpu.compare {
+		if gpu.status&0x04 == 0 && gpu.status&0x40 != 0 {
+			INTERRUPTS.flags |= 0x02
+		}
+		gpu.status |= 0x04
+	} else {
+		gpu.status &^= 0x04
+	}
+}
 
-	// Init Shader Program
-	gpu.program = gl.CreateProgram()
-	gl.AttachShader(gpu.program, vertexShader)
-	gl.AttachShader(gpu.program, fragmentShader)
-	gl.LinkProgram(gpu.program)
-	gl.DeleteShader(vertexShader)
-	gl.DeleteShader(fragmentShader)
+// WriteCompare sets LYC, which is compared with LY at once while the LCD is on
+func (gpu *GPUType) WriteCompare(value byte) {
+	gpu.compare = value
+	if gpu.control&0x80 != 0 {
+		gpu.compareLine()
+	}
 }
 
-func (gpu *GPUType) Run(glarea *gtk.GLArea) bool {
+// ReadStatus returns the STAT register with the current mode and coincidence flag
+func (gpu *GPUType) ReadStatus() byte {
+	return 0x80 | gpu.status&0x7C | gpu.mode
+}
 
-	err := glarea.GetError()
-	if err != nil {
-		panic(err)
+// WriteStatus sets the writable interrupt source bits of STAT
+func (gpu *GPUType) WriteStatus(value byte) {
+	gpu.status = gpu.status&0x07 | value&0x78
+}
+
+func (gpu *GPUType) completeFrame() {
+	if SGB.Enabled {
+		SGB.VBlank()
+	}
+	gpu.frameLock.Lock()
+	gpu.frame = gpu.framebuffer
+	gpu.frameCGB = CGB.Enabled
+	gpu.frameLock.Unlock()
+	gpu.frames++
+	if gpu.OnFrame != nil {
+		gpu.OnFrame()
 	}
+}
 
-	// OpenGL is initialized and ready to go
-	gl.ClearColor(0.0, 0.0, 0.0, 1.0)
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+// reset stops the LCD timing and clears the screen, as when the machine is switched on
+func (gpu *GPUType) reset() {
+	gpu.tick = 0
+	gpu.scanline = 0
+	gpu.mode = gpuModeHBlank
+	gpu.status = 0
+	gpu.framebuffer = [ScreenHeight][ScreenWidth]uint16{}
+	gpu.frameLock.Lock()
+	gpu.frame = gpu.framebuffer
+	gpu.frameLock.Unlock()
+}
 
-	var vertexData = []float32{
-		-0.6, -0.6, 0.0,
-		0.0, 0.6, 0.0,
-		0.6, -0.6, 0.0,
-		0.7, -0.1, 0.0,
-		0.8, 0.1, 0.0,
-		0.9, -0.1, 0.0,
+// SetPalette changes the RGB values the four shades are displayed with
+func (gpu *GPUType) SetPalette(palette PaletteType) {
+	gpu.frameLock.Lock()
+	gpu.palette = palette.Colours
+	gpu.frameLock.Unlock()
+	Logger.Log(LogTypes.INFO, "PALETTE: using "+palette.Name)
+	if gpu.OnFrame != nil {
+		gpu.OnFrame()
 	}
+}
 
-	// Init Buffers
-	var vao, vbo uint32         // declare vao & vbo
-	gl.GenVertexArrays(1, &vao) // allocate vao in vram
-	gl.BindVertexArray(vao)     // bind vao to opengl
+// Frame returns the last completed frame as packed RGBA pixels, row by row,
+// and its width and height which include the border in SGB mode
+func (gpu *GPUType) Frame() ([]byte, int, int) {
+	gpu.frameLock.Lock()
+	defer gpu.frameLock.Unlock()
+	if SGB.Enabled && !gpu.frameCGB {
+		return SGB.Compose(&gpu.frame)
+	}
+	pixels := make([]byte, ScreenWidth*ScreenHeight*4)
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x++ {
+			i := (y*ScreenWidth + x) * 4
+			if gpu.frameCGB {
+				copy(pixels[i:], rgb15(gpu.frame[y][x]))
+			} else {
+				copy(pixels[i:], gpu.palette[gpu.frame[y][x]&0x03][:])
+			}
+			pixels[i+3] = 0xFF
+		}
+	}
+	return pixels, ScreenWidth, ScreenHeight
+}
 
-	gl.GenBuffers(1, &vbo)
-	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
-	gl.BufferData(gl.ARRAY_BUFFER, len(vertexData), gl.Ptr(vertexData), gl.STATIC_DRAW)
-	var position_loc int32 = gl.GetAttribLocation(gpu.program, gl.Str("position\000"))
-	if position_loc < 0 {
-		fmt.Println(position_loc)
+// rgb15 expands a CGB 15-bit colour to 8 bits per channel
+func rgb15(colour uint16) []byte {
+	expand := func(c uint16) byte {
+		c &= 0x1F
+		return byte(c<<3 | c>>2)
 	}
-	gl.EnableVertexAttribArray(uint32(position_loc))
-	gl.VertexAttribPointer(uint32(position_loc), 3, gl.FLOAT, false, 0, nil)
+	return []byte{expand(colour), expand(colour >> 5), expand(colour >> 10)}
+}
 
-	gl.BindVertexArray(0)
-	gl.DisableVertexAttribArray(uint32(position_loc))
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+// shade maps a colour index through a BGP/OBP palette register
+func shade(palette byte, colour byte) byte {
+	return (palette >> (colour * 2)) & 0x03
+}
+
+// tilePixel returns the colour index of a pixel within the tile data at address in a VRAM bank
+func tilePixel(bank byte, address uint16, x byte, y byte) byte {
+	offset := uint16(bank)*0x2000 + address - OFFSETvRAM + uint16(y)*2
+	low := vRAM[offset]
+	high := vRAM[offset+1]
+	bit := 7 - x
+	return (high>>bit)&1<<1 | (low>>bit)&1
+}
+
+// tileAddress returns the address of a background/window tile's data per LCDC bit 4
+func (gpu *GPUType) tileAddress(tile byte) uint16 {
+	if gpu.control&0x10 != 0 {
+		return 0x8000 + uint16(tile)*16
+	}
+	return uint16(int(0x9000) + int(int8(tile))*16)
+}
 
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-	gl.UseProgram(gpu.program)
-	gl.BindVertexArray(vao)
-	gl.DrawArrays(gl.TRIANGLES, 0, 3)
-	gl.DrawArrays(gl.TRIANGLES, 4, 3)
-	gl.BindVertexArray(0)
-	gl.UseProgram(0)
-	return true
+// mapPixel returns the colour index and CGB attributes of a pixel in a background/window tile map
+func (gpu *GPUType) mapPixel(mapBase uint16, x byte, y byte) (byte, byte) {
+	index := mapBase - OFFSETvRAM + uint16(y/8)*32 + uint16(x/8)
+	tile := vRAM[index]
+	var attributes byte
+	if CGB.Enabled {
+		attributes = vRAM[0x2000+index]
+	}
+	column, row := x%8, y%8
+	if attributes&0x20 != 0 {
+		column = 7 - column
+	}
+	if attributes&0x40 != 0 {
+		row = 7 - row
+	}
+	return tilePixel((attributes>>3)&0x01, gpu.tileAddress(tile), column, row), attributes
 }
 
-func (gpu *GPUType) Destroy(glarea *gtk.GLArea) {}
+func (gpu *GPUType) renderScanline() {
+	var line [ScreenWidth]uint16
+	var background [ScreenWidth]byte
+	var bgAttributes [ScreenWidth]byte
+	y := gpu.scanline
+
+	// Background, on CGB LCDC bit 0 only affects priority
+	if gpu.control&0x01 != 0 || CGB.Enabled {
+		mapBase := uint16(0x9800)
+		if gpu.control&0x08 != 0 {
+			mapBase = 0x9C00
+		}
+		bgY := y + gpu.scrollY
+		for x := 0; x < ScreenWidth; x++ {
+			background[x], bgAttributes[x] = gpu.mapPixel(mapBase, byte(x)+gpu.scrollX, bgY)
+		}
+
+		// Window
+		if gpu.control&0x20 != 0 && y >= gpu.windowY && gpu.windowX <= 166 {
+			mapBase = 0x9800
+			if gpu.control&0x40 != 0 {
+				mapBase = 0x9C00
+			}
+			winY := y - gpu.windowY
+			for x := 0; x < ScreenWidth; x++ {
+				if x+7 < int(gpu.windowX) {
+					continue
+				}
+				background[x], bgAttributes[x] = gpu.mapPixel(mapBase, byte(x+7-int(gpu.windowX)), winY)
+			}
+		}
+	}
+	for x := range line {
+		if CGB.Enabled {
+			line[x] = paletteColour(&CGB.bgPaletteRAM, bgAttributes[x], background[x])
+		} else {
+			line[x] = uint16(shade(gpu.bgPalette, background[x]))
+		}
+	}
+
+	// Sprites
+	if gpu.control&0x02 != 0 {
+		height := byte(8)
+		if gpu.control&0x04 != 0 {
+			height = 16
+		}
+		drawn := 0
+		var owner [ScreenWidth]int
+		for i := 0; i < 40 && drawn < 10; i++ {
+			spriteY := int(oam[i*4]) - 16
+			spriteX := int(oam[i*4+1]) - 8
+			tile := oam[i*4+2]
+			attributes := oam[i*4+3]
+			if int(y) < spriteY || int(y) >= spriteY+int(height) {
+				continue
+			}
+			drawn++
+			row := byte(int(y) - spriteY)
+			if attributes&0x40 != 0 {
+				row = height - 1 - row
+			}
+			if height == 16 {
+				tile &= 0xFE
+			}
+			bank := byte(0)
+			if CGB.Enabled {
+				bank = (attributes >> 3) & 0x01
+			}
+			for px := byte(0); px < 8; px++ {
+				x := spriteX + int(px)
+				if x < 0 || x >= ScreenWidth {
+					continue
+				}
+				// on DMG lower X wins, ties and CGB go to the earlier OAM entry
+				if owner[x] != 0 && (CGB.Enabled || int(oam[(owner[x]-1)*4+1]) <= spriteX+8) {
+					continue
+				}
+				column := px
+				if attributes&0x20 != 0 {
+					column = 7 - px
+				}
+				colour := tilePixel(bank, 0x8000+uint16(tile)*16, column, row)
+				if colour == 0 {
+					continue
+				}
+				owner[x] = i + 1
+				behind := attributes&0x80 != 0 || bgAttributes[x]&0x80 != 0
+				if CGB.Enabled && gpu.control&0x01 == 0 {
+					behind = false
+				}
+				if behind && background[x] != 0 {
+					continue
+				}
+				if CGB.Enabled {
+					line[x] = paletteColour(&CGB.objPaletteRAM, attributes, colour)
+				} else if attributes&0x10 != 0 {
+					line[x] = uint16(shade(gpu.objPalette1, colour))
+				} else {
+					line[x] = uint16(shade(gpu.objPalette0, colour))
+				}
+			}
+		}
+	}
+
+	gpu.framebuffer[y] = line
+}
@@ -0,0 +1,183 @@
+package core
+
+import "testing"
+
+// testGPU returns an LCD of its own switched on with control, after clearing
+// VRAM, OAM and the interrupt flags. The palettes map each colour to its own shade.
+func testGPU(t *testing.T, control byte) *GPUType {
+	vRAM = [len(vRAM)]byte{}
+	oam = [len(oam)]byte{}
+	INTERRUPTS.flags = 0x00
+	t.Cleanup(func() {
+		vRAM = [len(vRAM)]byte{}
+		oam = [len(oam)]byte{}
+		INTERRUPTS.flags = 0x00
+	})
+	CGB.Enabled = false
+	return &GPUType{control: control, bgPalette: 0xE4, objPalette0: 0xE4, objPalette1: 0xE4}
+}
+
+// fillTile sets every pixel of tile at 0x8000 to colour
+func fillTile(tile int, colour byte) {
+	for row := 0; row < 8; row++ {
+		vRAM[tile*16+row*2] = 0xFF * (colour & 0x01)
+		vRAM[tile*16+row*2+1] = 0xFF * (colour >> 1)
+	}
+}
+
+// TestGPUModes steps through a scanline and into VBlank, expecting the STAT
+// mode and the interrupts each mode requests when its STAT source is enabled
+func TestGPUModes(t *testing.T) {
+	gpu := testGPU(t, 0x80)
+	gpu.WriteStatus(0x28) // HBlank and OAM interrupt sources
+
+	steps := []struct {
+		name   string
+		cycles int
+		mode   byte
+		flags  byte
+	}{
+		{"OAM search", 0, gpuModeOAM, 0x02},
+		{"pixel transfer", gpuCyclesOAM, gpuModeTransfer, 0x00},
+		{"HBlank", gpuCyclesTransfer, gpuModeHBlank, 0x02},
+		{"next line", gpuCyclesLine - gpuCyclesOAM - gpuCyclesTransfer, gpuModeOAM, 0x02},
+		{"VBlank", gpuCyclesLine * (ScreenHeight - 1), gpuModeVBlank, 0x01},
+		{"next frame", gpuCyclesLine * (gpuLinesTotal - ScreenHeight), gpuModeOAM, 0x02},
+	}
+	for _, step := range steps {
+		INTERRUPTS.flags = 0x00
+		gpu.Step(step.cycles)
+		if mode := gpu.ReadStatus() & 0x03; mode != step.mode {
+			t.Errorf("GPU: %s: mode %d, expected %d", step.name, mode, step.mode)
+		}
+		if INTERRUPTS.flags != step.flags {
+			t.Errorf("GPU: %s: interrupt flags 0x%02X, expected 0x%02X", step.name, INTERRUPTS.flags, step.flags)
+		}
+	}
+	if gpu.frames != 1 {
+		t.Errorf("GPU: completed %d frames, expected 1", gpu.frames)
+	}
+
+	gpu.control = 0x00
+	gpu.Step(4)
+	if gpu.scanline != 0 || gpu.ReadStatus()&0x03 != gpuModeHBlank {
+		t.Errorf("GPU: LCD off at LY %d mode %d, expected LY 0 in HBlank", gpu.scanline, gpu.ReadStatus()&0x03)
+	}
+}
+
+// TestGPUCompare expects the LY=LYC interrupt when LY reaches LYC and as soon
+// as LYC is written with the current LY, once per match
+func TestGPUCompare(t *testing.T) {
+	gpu := testGPU(t, 0x80)
+	gpu.WriteStatus(0x40)
+	gpu.WriteCompare(2)
+	gpu.Step(gpuCyclesLine)
+	if gpu.ReadStatus()&0x04 != 0 || INTERRUPTS.flags&0x02 != 0 {
+		t.Errorf("GPU: coincidence at LY 1 with LYC 2")
+	}
+	gpu.Step(gpuCyclesLine)
+	if gpu.ReadStatus()&0x04 == 0 || INTERRUPTS.flags&0x02 == 0 {
+		t.Errorf("GPU: no coincidence interrupt at LY 2 with LYC 2")
+	}
+
+	INTERRUPTS.flags = 0x00
+	gpu.Step(gpuCyclesLine)
+	if gpu.ReadStatus()&0x04 != 0 {
+		t.Errorf("GPU: coincidence flag still set at LY 3")
+	}
+	gpu.WriteCompare(3)
+	if gpu.ReadStatus()&0x04 == 0 || INTERRUPTS.flags&0x02 == 0 {
+		t.Errorf("GPU: no coincidence interrupt writing LYC 3 at LY 3")
+	}
+	INTERRUPTS.flags = 0x00
+	gpu.WriteCompare(3)
+	if INTERRUPTS.flags&0x02 != 0 {
+		t.Errorf("GPU: rewriting the matching LYC requested the interrupt again")
+	}
+
+	gpu.WriteStatus(0x00)
+	gpu.WriteCompare(4)
+	gpu.Step(gpuCyclesLine)
+	if gpu.ReadStatus()&0x04 == 0 || INTERRUPTS.flags&0x02 != 0 {
+		t.Errorf("GPU: coincidence with its interrupt source disabled should only set the flag")
+	}
+}
+
+// TestGPUWindow draws the window over the background from WX-7 and WY onwards
+func TestGPUWindow(t *testing.T) {
+	// background from the 0x9800 map, window from 0x9C00, tiles at 0x8000
+	gpu := testGPU(t, 0x80|0x40|0x20|0x10|0x01)
+	fillTile(1, 3)
+	for i := 0; i < 0x400; i++ {
+		vRAM[0x1C00+i] = 1
+	}
+	gpu.windowY = 10
+	gpu.windowX = 7 + 40
+
+	for _, y := range []byte{9, 10, 100} {
+		gpu.scanline = y
+		gpu.renderScanline()
+		for _, x := range []int{0, 39, 40, 159} {
+			expected := uint16(0)
+			if y >= 10 && x >= 40 {
+				expected = 3
+			}
+			if shade := gpu.framebuffer[y][x]; shade != expected {
+				t.Errorf("GPU: pixel %d,%d is shade %d, expected %d", x, y, shade, expected)
+			}
+		}
+	}
+
+	gpu.control &^= 0x20
+	gpu.scanline = 100
+	gpu.renderScanline()
+	if shade := gpu.framebuffer[100][80]; shade != 0 {
+		t.Errorf("GPU: window drawn while disabled in LCDC")
+	}
+}
+
+// TestGPUSpritePriority overlaps sprites and background, expecting the lower X
+// to win on DMG, then the earlier OAM entry, and sprites with the priority
+// bit to only show over background colour 0
+func TestGPUSpritePriority(t *testing.T) {
+	gpu := testGPU(t, 0x80|0x10|0x02|0x01)
+	fillTile(1, 1)
+	fillTile(2, 2)
+	fillTile(3, 3)
+	// background tile 3 over the left half of the first row of tiles
+	for i := 0; i < 10; i++ {
+		vRAM[0x1800+i] = 3
+	}
+
+	sprite := func(i int, x byte, tile byte, attributes byte) {
+		copy(oam[i*4:], []byte{16, x + 8, tile, attributes})
+	}
+	sprite(0, 100, 1, 0x00) // X 100
+	sprite(1, 96, 2, 0x00)  // X 96 is lower, wins over 100-103
+	sprite(2, 120, 1, 0x00) // same X, earlier entry wins
+	sprite(3, 120, 2, 0x00)
+	sprite(4, 40, 1, 0x80)  // behind background colour 3
+	sprite(5, 130, 2, 0x80) // over background colour 0
+
+	gpu.scanline = 0
+	gpu.renderScanline()
+	for _, pixel := range []struct {
+		x     int
+		shade uint16
+	}{
+		{96, 2}, {103, 2}, {104, 1}, {107, 1},
+		{120, 1}, {127, 1},
+		{40, 3},
+		{130, 2},
+	} {
+		if shade := gpu.framebuffer[0][pixel.x]; shade != pixel.shade {
+			t.Errorf("GPU: pixel %d is shade %d, expected %d", pixel.x, shade, pixel.shade)
+		}
+	}
+
+	gpu.control &^= 0x02
+	gpu.renderScanline()
+	if shade := gpu.framebuffer[0][130]; shade != 0 {
+		t.Errorf("GPU: sprite drawn while disabled in LCDC")
+	}
+}
@@ -0,0 +1,463 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Cartridge header fields not covered by the ROM_OFFSET constants in rom.go
+const (
+	ROM_OFFSET_LOGO            = 0x0104
+	ROM_OFFSET_MANUFACTURER    = 0x013F
+	ROM_OFFSET_NEW_LICENSEE    = 0x0144
+	ROM_OFFSET_DESTINATION     = 0x014A
+	ROM_OFFSET_VERSION         = 0x014C
+	ROM_OFFSET_HEADER_CHECKSUM = 0x014D
+	ROM_OFFSET_GLOBAL_CHECKSUM = 0x014E
+	ROM_HEADER_SIZE            = 0x0150
+)
+
+// ErrHeaderTooSmall is returned when the data ends before the cartridge header does
+var ErrHeaderTooSmall = errors.New("ROM is smaller than the cartridge header")
+
+// nintendoLogo is the bitmap the boot ROM compares against 0x0104-0x0133 before starting a cartridge
+var nintendoLogo = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// ramSizes maps the RAM size code to the cartridge RAM size in KB
+var ramSizes = map[byte]int{
+	0x00: 0,
+	0x01: 2,
+	0x02: 8,
+	0x03: 32,
+	0x04: 128,
+	0x05: 64,
+}
+
+// oldLicensees names the common old licensee codes (0x014B)
+var oldLicensees = map[byte]string{
+	0x00: "None",
+	0x01: "Nintendo",
+	0x08: "Capcom",
+	0x09: "Hot-B",
+	0x0A: "Jaleco",
+	0x0B: "Coconuts",
+	0x0C: "Elite Systems",
+	0x13: "Electronic Arts",
+	0x18: "Hudson Soft",
+	0x19: "ITC Entertainment",
+	0x1A: "Yanoman",
+	0x1D: "Clary",
+	0x1F: "Virgin",
+	0x24: "PCM Complete",
+	0x25: "San-X",
+	0x28: "Kotobuki Systems",
+	0x29: "SETA",
+	0x30: "Infogrames",
+	0x31: "Nintendo",
+	0x32: "Bandai",
+	0x34: "Konami",
+	0x35: "Hector",
+	0x38: "Capcom",
+	0x39: "Banpresto",
+	0x3C: "Entertainment i",
+	0x3E: "Gremlin",
+	0x41: "Ubisoft",
+	0x42: "Atlus",
+	0x44: "Malibu",
+	0x46: "Angel",
+	0x47: "Spectrum Holobyte",
+	0x49: "Irem",
+	0x4A: "Virgin",
+	0x4D: "Malibu",
+	0x4F: "U.S. Gold",
+	0x50: "Absolute",
+	0x51: "Acclaim",
+	0x52: "Activision",
+	0x53: "American Sammy",
+	0x54: "GameTek",
+	0x55: "Park Place",
+	0x56: "LJN",
+	0x57: "Matchbox",
+	0x59: "Milton Bradley",
+	0x5A: "Mindscape",
+	0x5B: "Romstar",
+	0x5C: "Naxat Soft",
+	0x5D: "Tradewest",
+	0x60: "Titus",
+	0x61: "Virgin",
+	0x67: "Ocean",
+	0x69: "Electronic Arts",
+	0x6E: "Elite Systems",
+	0x6F: "Electro Brain",
+	0x70: "Infogrames",
+	0x71: "Interplay",
+	0x72: "Broderbund",
+	0x73: "Sculptured Soft",
+	0x75: "The Sales Curve",
+	0x78: "THQ",
+	0x79: "Accolade",
+	0x7A: "Triffix Entertainment",
+	0x7C: "Microprose",
+	0x7F: "Kemco",
+	0x80: "Misawa Entertainment",
+	0x83: "Lozc",
+	0x86: "Tokuma Shoten",
+	0x8B: "Bullet-Proof Software",
+	0x8C: "Vic Tokai",
+	0x8E: "Ape",
+	0x8F: "I'Max",
+	0x91: "Chunsoft",
+	0x92: "Video System",
+	0x93: "Tsubaraya Productions",
+	0x95: "Varie",
+	0x96: "Yonezawa/S'Pal",
+	0x97: "Kaneko",
+	0x99: "Arc",
+	0x9A: "Nihon Bussan",
+	0x9B: "Tecmo",
+	0x9C: "Imagineer",
+	0x9D: "Banpresto",
+	0x9F: "Nova",
+	0xA1: "Hori Electric",
+	0xA2: "Bandai",
+	0xA4: "Konami",
+	0xA6: "Kawada",
+	0xA7: "Takara",
+	0xA9: "Technos Japan",
+	0xAA: "Broderbund",
+	0xAC: "Toei Animation",
+	0xAD: "Toho",
+	0xAF: "Namco",
+	0xB0: "Acclaim",
+	0xB1: "ASCII or Nexsoft",
+	0xB2: "Bandai",
+	0xB4: "Square Enix",
+	0xB6: "HAL Laboratory",
+	0xB7: "SNK",
+	0xB9: "Pony Canyon",
+	0xBA: "Culture Brain",
+	0xBB: "Sunsoft",
+	0xBD: "Sony Imagesoft",
+	0xBF: "Sammy",
+	0xC0: "Taito",
+	0xC2: "Kemco",
+	0xC3: "Squaresoft",
+	0xC4: "Tokuma Shoten",
+	0xC5: "Data East",
+	0xC6: "Tonkin House",
+	0xC8: "Koei",
+	0xC9: "UFL",
+	0xCA: "Ultra",
+	0xCB: "Vap",
+	0xCC: "Use Corporation",
+	0xCD: "Meldac",
+	0xCE: "Pony Canyon",
+	0xCF: "Angel",
+	0xD0: "Taito",
+	0xD1: "Sofel",
+	0xD2: "Quest",
+	0xD3: "Sigma Enterprises",
+	0xD4: "ASK Kodansha",
+	0xD6: "Naxat Soft",
+	0xD7: "Copya System",
+	0xD9: "Banpresto",
+	0xDA: "Tomy",
+	0xDB: "LJN",
+	0xDD: "NCS",
+	0xDE: "Human",
+	0xDF: "Altron",
+	0xE0: "Jaleco",
+	0xE1: "Towa Chiki",
+	0xE2: "Yutaka",
+	0xE3: "Varie",
+	0xE5: "Epcoh",
+	0xE7: "Athena",
+	0xE8: "Asmik",
+	0xE9: "Natsume",
+	0xEA: "King Records",
+	0xEB: "Atlus",
+	0xEC: "Epic/Sony Records",
+	0xEE: "IGS",
+	0xF0: "A Wave",
+	0xF3: "Extreme Entertainment",
+	0xFF: "LJN",
+}
+
+// newLicensees names the common new licensee codes (0x0144-0x0145), used when the old code is 0x33
+var newLicensees = map[string]string{
+	"00": "None",
+	"01": "Nintendo",
+	"08": "Capcom",
+	"13": "Electronic Arts",
+	"18": "Hudson Soft",
+	"19": "B-AI",
+	"20": "KSS",
+	"22": "POW",
+	"24": "PCM Complete",
+	"25": "San-X",
+	"28": "Kemco Japan",
+	"29": "SETA",
+	"30": "Viacom",
+	"31": "Nintendo",
+	"32": "Bandai",
+	"33": "Ocean/Acclaim",
+	"34": "Konami",
+	"35": "Hector",
+	"37": "Taito",
+	"38": "Hudson",
+	"39": "Banpresto",
+	"41": "Ubisoft",
+	"42": "Atlus",
+	"44": "Malibu",
+	"46": "Angel",
+	"47": "Bullet-Proof Software",
+	"49": "Irem",
+	"50": "Absolute",
+	"51": "Acclaim",
+	"52": "Activision",
+	"53": "American Sammy",
+	"54": "Konami",
+	"55": "Hi Tech Entertainment",
+	"56": "LJN",
+	"57": "Matchbox",
+	"58": "Mattel",
+	"59": "Milton Bradley",
+	"60": "Titus",
+	"61": "Virgin",
+	"64": "LucasArts",
+	"67": "Ocean",
+	"69": "Electronic Arts",
+	"70": "Infogrames",
+	"71": "Interplay",
+	"72": "Broderbund",
+	"73": "Sculptured",
+	"75": "SCI",
+	"78": "THQ",
+	"79": "Accolade",
+	"80": "Misawa",
+	"83": "Lozc",
+	"86": "Tokuma Shoten",
+	"87": "Tsukuda Original",
+	"91": "Chunsoft",
+	"92": "Video System",
+	"93": "Ocean/Acclaim",
+	"95": "Varie",
+	"96": "Yonezawa/S'Pal",
+	"97": "Kaneko",
+	"99": "Pack-In-Video",
+	"A4": "Konami (Yu-Gi-Oh!)",
+}
+
+// CartridgeHeader is the decoded cartridge header at 0x0100-0x014F
+type CartridgeHeader struct {
+	Title          string
+	Manufacturer   string
+	CGBFlag        byte
+	SGBFlag        byte
+	CartridgeType  byte
+	ROMSizeCode    byte
+	RAMSizeCode    byte
+	Destination    byte
+	OldLicensee    byte
+	NewLicensee    string
+	Version        byte
+	HeaderChecksum byte
+	GlobalChecksum uint16
+
+	// LogoValid, HeaderChecksumValid and GlobalChecksumValid compare the stored values
+	// with the Nintendo logo and the checksums computed over the ROM
+	LogoValid           bool
+	HeaderChecksumValid bool
+	GlobalChecksumValid bool
+}
+
+// ParseCartridgeHeader decodes and verifies the header of a ROM image
+func ParseCartridgeHeader(data []byte) (CartridgeHeader, error) {
+	var header CartridgeHeader
+	if len(data) < ROM_HEADER_SIZE {
+		return header, ErrHeaderTooSmall
+	}
+
+	header.CGBFlag = data[ROM_OFFSET_CGB]
+	titleEnd := ROM_OFFSET_CGB + 1
+	if header.CGBFlag&0x80 != 0 {
+		// the last title byte became the CGB flag
+		titleEnd = ROM_OFFSET_CGB
+		if isManufacturerCode(data[ROM_OFFSET_MANUFACTURER:ROM_OFFSET_CGB]) {
+			// and later cartridges shortened it again for a manufacturer code
+			header.Manufacturer = string(data[ROM_OFFSET_MANUFACTURER:ROM_OFFSET_CGB])
+			titleEnd = ROM_OFFSET_MANUFACTURER
+		}
+	}
+	header.Title = headerString(data[ROM_OFFSET_NAME:titleEnd])
+
+	header.NewLicensee = headerString(data[ROM_OFFSET_NEW_LICENSEE : ROM_OFFSET_NEW_LICENSEE+2])
+	header.SGBFlag = data[ROM_OFFSET_SGB]
+	header.CartridgeType = data[ROM_OFFSET_TYPE]
+	header.ROMSizeCode = data[ROM_OFFSET_ROM_SIZE]
+	header.RAMSizeCode = data[ROM_OFFSET_RAM_SIZE]
+	header.Destination = data[ROM_OFFSET_DESTINATION]
+	header.OldLicensee = data[ROM_OFFSET_LICENSEE]
+	header.Version = data[ROM_OFFSET_VERSION]
+	header.HeaderChecksum = data[ROM_OFFSET_HEADER_CHECKSUM]
+	header.GlobalChecksum = uint16(data[ROM_OFFSET_GLOBAL_CHECKSUM])<<8 | uint16(data[ROM_OFFSET_GLOBAL_CHECKSUM+1])
+
+	header.LogoValid = string(data[ROM_OFFSET_LOGO:ROM_OFFSET_LOGO+len(nintendoLogo)]) == string(nintendoLogo)
+	header.HeaderChecksumValid = ComputeHeaderChecksum(data) == header.HeaderChecksum
+	header.GlobalChecksumValid = ComputeGlobalChecksum(data) == header.GlobalChecksum
+	return header, nil
+}
+
+// headerString returns a header text field up to its first NUL
+func headerString(field []byte) string {
+	if end := strings.IndexByte(string(field), 0); end >= 0 {
+		field = field[:end]
+	}
+	return strings.TrimRight(string(field), " ")
+}
+
+func isManufacturerCode(code []byte) bool {
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ComputeHeaderChecksum computes the checksum of 0x0134-0x014C the boot ROM verifies
+func ComputeHeaderChecksum(data []byte) byte {
+	var checksum byte
+	for _, b := range data[ROM_OFFSET_NAME:ROM_OFFSET_HEADER_CHECKSUM] {
+		checksum = checksum - b - 1
+	}
+	return checksum
+}
+
+// ComputeGlobalChecksum sums every byte of the ROM except the global checksum itself
+func ComputeGlobalChecksum(data []byte) uint16 {
+	var checksum uint16
+	for i, b := range data {
+		if i != ROM_OFFSET_GLOBAL_CHECKSUM && i != ROM_OFFSET_GLOBAL_CHECKSUM+1 {
+			checksum += uint16(b)
+		}
+	}
+	return checksum
+}
+
+// TypeName returns the cartridge type as named in romTypeMap
+func (header CartridgeHeader) TypeName() string {
+	if name, ok := romTypeMap[header.CartridgeType]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_0x%02X", header.CartridgeType)
+}
+
+// ROMSize returns the ROM size in KB the header declares, or 0 for an unknown code
+func (header CartridgeHeader) ROMSize() int {
+	switch {
+	case header.ROMSizeCode <= 0x08:
+		return 32 << header.ROMSizeCode
+	case header.ROMSizeCode == 0x52:
+		return 72 * 16
+	case header.ROMSizeCode == 0x53:
+		return 80 * 16
+	case header.ROMSizeCode == 0x54:
+		return 96 * 16
+	}
+	return 0
+}
+
+// RAMSize returns the cartridge RAM size in KB the header declares.
+// MBC2 cartridges declare none but have 512 half-bytes built in.
+func (header CartridgeHeader) RAMSize() int {
+	return ramSizes[header.RAMSizeCode]
+}
+
+// CGBSupport describes the CGB flag
+func (header CartridgeHeader) CGBSupport() string {
+	switch {
+	case header.CGBFlag == 0xC0:
+		return "CGB only"
+	case header.CGBFlag&0x80 != 0:
+		return "CGB enhanced"
+	}
+	return "DMG"
+}
+
+// SupportsSGB reports whether the cartridge uses Super Game Boy functions,
+// which also requires the new licensee code to be in use
+func (header CartridgeHeader) SupportsSGB() bool {
+	return header.SGBFlag == 0x03 && header.OldLicensee == 0x33
+}
+
+// Licensee returns the publisher's name from the old or, when the old code is 0x33, new licensee code
+func (header CartridgeHeader) Licensee() string {
+	if header.OldLicensee == 0x33 {
+		if name, ok := newLicensees[header.NewLicensee]; ok {
+			return name
+		}
+		return "Unknown (" + header.NewLicensee + ")"
+	}
+	if name, ok := oldLicensees[header.OldLicensee]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown (0x%02X)", header.OldLicensee)
+}
+
+// DestinationName describes the destination code
+func (header CartridgeHeader) DestinationName() string {
+	if header.Destination == 0x00 {
+		return "Japan"
+	}
+	return "Overseas"
+}
+
+// CompatibilityIssue is a problem found by CompatibilityCheck.
+// Fatal issues are those ROM.Load refuses the ROM for, the rest are informational.
+type CompatibilityIssue struct {
+	Fatal   bool
+	Message string
+}
+
+// supportedCartridgeTypes are the cartridge types the MMU can run, those without a memory bank controller
+var supportedCartridgeTypes = map[byte]bool{
+	0x00: true,
+	0x08: true,
+	0x09: true,
+}
+
+// CompatibilityCheck reports what in the header and ROM image stops FreeMe!GB,
+// or real hardware, from running it
+func (header CartridgeHeader) CompatibilityCheck(size int) []CompatibilityIssue {
+	var issues []CompatibilityIssue
+	if !header.LogoValid {
+		issues = append(issues, CompatibilityIssue{false, "Nintendo logo does not match, real hardware would lock up"})
+	}
+	if !header.HeaderChecksumValid {
+		issues = append(issues, CompatibilityIssue{true, "Header checksum does not match, the header is corrupt"})
+	}
+	if !header.GlobalChecksumValid {
+		issues = append(issues, CompatibilityIssue{false, "Global checksum does not match, the ROM may be modified or damaged"})
+	}
+	if _, ok := romTypeMap[header.CartridgeType]; !ok {
+		issues = append(issues, CompatibilityIssue{true, fmt.Sprintf("Unknown cartridge type 0x%02X", header.CartridgeType)})
+	} else if !supportedCartridgeTypes[header.CartridgeType] {
+		issues = append(issues, CompatibilityIssue{false, header.TypeName() +
+			" memory bank controller is not emulated yet, only the first 32KB are mapped"})
+	}
+	if header.ROMSize() == 0 {
+		issues = append(issues, CompatibilityIssue{true, fmt.Sprintf("Unknown ROM size code 0x%02X", header.ROMSizeCode)})
+	} else if header.ROMSize()*1024 != size {
+		issues = append(issues, CompatibilityIssue{true, fmt.Sprintf("Header declares %dKB but the ROM is %dKB", header.ROMSize(), size/1024)})
+	}
+	if _, ok := ramSizes[header.RAMSizeCode]; !ok {
+		issues = append(issues, CompatibilityIssue{false, fmt.Sprintf("Unknown RAM size code 0x%02X", header.RAMSizeCode)})
+	}
+	return issues
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// headerTestROM builds a 32KB ROM with a valid header, applies edit to it and
+// then fixes both checksums
+func headerTestROM(edit func(rom []byte)) []byte {
+	rom := testROM(nil)
+	if edit != nil {
+		edit(rom)
+	}
+	rom[ROM_OFFSET_HEADER_CHECKSUM] = ComputeHeaderChecksum(rom)
+	checksum := ComputeGlobalChecksum(rom)
+	rom[ROM_OFFSET_GLOBAL_CHECKSUM] = byte(checksum >> 8)
+	rom[ROM_OFFSET_GLOBAL_CHECKSUM+1] = byte(checksum)
+	return rom
+}
+
+func TestParseCartridgeHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		edit         func(rom []byte)
+		title        string
+		manufacturer string
+		cgb          string
+		licensee     string
+		sgb          bool
+	}{
+		{"DMG", func(rom []byte) {
+			copy(rom[ROM_OFFSET_NAME:], "TETRIS")
+			rom[ROM_OFFSET_LICENSEE] = 0x01
+		}, "TETRIS", "", "DMG", "Nintendo", false},
+		{"16 character title", func(rom []byte) {
+			copy(rom[ROM_OFFSET_NAME:], "ABCDEFGHIJKLMNOP")
+		}, "ABCDEFGHIJKLMNOP", "", "DMG", "None", false},
+		{"CGB enhanced", func(rom []byte) {
+			copy(rom[ROM_OFFSET_NAME:], "POKEMON YELLOW")
+			rom[ROM_OFFSET_CGB] = 0x80
+		}, "POKEMON YELLOW", "", "CGB enhanced", "None", false},
+		{"manufacturer code", func(rom []byte) {
+			copy(rom[ROM_OFFSET_NAME:], "ZELDA")
+			copy(rom[ROM_OFFSET_MANUFACTURER:], "AZLE")
+			rom[ROM_OFFSET_CGB] = 0xC0
+		}, "ZELDA", "AZLE", "CGB only", "None", false},
+		{"new licensee and SGB", func(rom []byte) {
+			rom[ROM_OFFSET_LICENSEE] = 0x33
+			copy(rom[ROM_OFFSET_NEW_LICENSEE:], "01")
+			rom[ROM_OFFSET_SGB] = 0x03
+		}, "", "", "DMG", "Nintendo", true},
+		{"SGB flag without the new licensee", func(rom []byte) {
+			rom[ROM_OFFSET_LICENSEE] = 0x01
+			rom[ROM_OFFSET_SGB] = 0x03
+		}, "", "", "DMG", "Nintendo", false},
+		{"unknown new licensee", func(rom []byte) {
+			rom[ROM_OFFSET_LICENSEE] = 0x33
+			copy(rom[ROM_OFFSET_NEW_LICENSEE:], "ZZ")
+		}, "", "", "DMG", "Unknown (ZZ)", false},
+	}
+	for _, test := range tests {
+		header, err := ParseCartridgeHeader(headerTestROM(test.edit))
+		if err != nil {
+			t.Errorf("Header: %s: %v", test.name, err)
+			continue
+		}
+		if header.Title != test.title || header.Manufacturer != test.manufacturer {
+			t.Errorf("Header: %s: title %q by %q, expected %q by %q",
+				test.name, header.Title, header.Manufacturer, test.title, test.manufacturer)
+		}
+		if header.CGBSupport() != test.cgb {
+			t.Errorf("Header: %s: %s, expected %s", test.name, header.CGBSupport(), test.cgb)
+		}
+		if header.Licensee() != test.licensee {
+			t.Errorf("Header: %s: licensee %s, expected %s", test.name, header.Licensee(), test.licensee)
+		}
+		if header.SupportsSGB() != test.sgb {
+			t.Errorf("Header: %s: SGB support %v, expected %v", test.name, header.SupportsSGB(), test.sgb)
+		}
+		if !header.LogoValid || !header.HeaderChecksumValid || !header.GlobalChecksumValid {
+			t.Errorf("Header: %s: logo %v, header checksum %v, global checksum %v, expected all valid", test.name,
+				header.LogoValid, header.HeaderChecksumValid, header.GlobalChecksumValid)
+		}
+	}
+
+	if _, err := ParseCartridgeHeader(make([]byte, ROM_HEADER_SIZE-1)); err != ErrHeaderTooSmall {
+		t.Errorf("Header: a truncated header returned %v, expected ErrHeaderTooSmall", err)
+	}
+}
+
+func TestHeaderChecksums(t *testing.T) {
+	// every header byte 0xFF subtracts 0x100, the global checksum adds 0x14E bytes of 0xFF
+	data := make([]byte, ROM_HEADER_SIZE)
+	for i := range data {
+		data[i] = 0xFF
+	}
+	if checksum := ComputeHeaderChecksum(data); checksum != 0x00 {
+		t.Errorf("Header: header checksum 0x%02X, expected 0x00", checksum)
+	}
+	if checksum := ComputeGlobalChecksum(data); checksum != 0x4CB2 {
+		t.Errorf("Header: global checksum 0x%04X, expected 0x4CB2", checksum)
+	}
+	if checksum := ComputeHeaderChecksum(make([]byte, ROM_HEADER_SIZE)); checksum != 0xE7 {
+		t.Errorf("Header: header checksum of zeroes 0x%02X, expected 0xE7", checksum)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(rom []byte)
+		header  bool
+		global  bool
+	}{
+		{"valid", func(rom []byte) {}, true, true},
+		{"header checksum", func(rom []byte) { rom[ROM_OFFSET_HEADER_CHECKSUM]++ }, false, false},
+		{"title", func(rom []byte) { rom[ROM_OFFSET_NAME] = 'X' }, false, false},
+		{"global checksum", func(rom []byte) { rom[ROM_OFFSET_GLOBAL_CHECKSUM+1]++ }, true, false},
+		{"ROM data", func(rom []byte) { rom[0x4000] = 0x76 }, true, false},
+	}
+	for _, test := range tests {
+		rom := headerTestROM(nil)
+		test.corrupt(rom)
+		header, err := ParseCartridgeHeader(rom)
+		if err != nil {
+			t.Fatalf("Header: %s: %v", test.name, err)
+		}
+		if header.HeaderChecksumValid != test.header || header.GlobalChecksumValid != test.global {
+			t.Errorf("Header: corrupted %s: header checksum valid %v, global %v, expected %v, %v", test.name,
+				header.HeaderChecksumValid, header.GlobalChecksumValid, test.header, test.global)
+		}
+	}
+}
+
+func TestCompatibilityCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(rom []byte)
+		corrupt func(rom []byte)
+		size    int
+		issues  []CompatibilityIssue
+	}{
+		{"valid", nil, nil, 0x8000, nil},
+		{"bad logo", func(rom []byte) { rom[ROM_OFFSET_LOGO] = 0x00 }, nil, 0x8000,
+			[]CompatibilityIssue{{false, "Nintendo logo"}}},
+		{"corrupted header checksum", nil, func(rom []byte) { rom[ROM_OFFSET_HEADER_CHECKSUM]++ }, 0x8000,
+			[]CompatibilityIssue{{true, "Header checksum"}, {false, "Global checksum"}}},
+		{"corrupted global checksum", nil, func(rom []byte) { rom[ROM_OFFSET_GLOBAL_CHECKSUM]++ }, 0x8000,
+			[]CompatibilityIssue{{false, "Global checksum"}}},
+		{"unknown cartridge type", func(rom []byte) { rom[ROM_OFFSET_TYPE] = 0x04 }, nil, 0x8000,
+			[]CompatibilityIssue{{true, "Unknown cartridge type 0x04"}}},
+		{"memory bank controller", func(rom []byte) { rom[ROM_OFFSET_TYPE] = 0x01 }, nil, 0x8000,
+			[]CompatibilityIssue{{false, "ROM_MBC1 memory bank controller"}}},
+		{"bad ROM size code", func(rom []byte) { rom[ROM_OFFSET_ROM_SIZE] = 0x09 }, nil, 0x8000,
+			[]CompatibilityIssue{{true, "Unknown ROM size code 0x09"}}},
+		{"ROM size mismatch", func(rom []byte) { rom[ROM_OFFSET_ROM_SIZE] = 0x01 }, nil, 0x8000,
+			[]CompatibilityIssue{{true, "declares 64KB but the ROM is 32KB"}}},
+		{"truncated ROM", nil, nil, 0x4000,
+			[]CompatibilityIssue{{true, "declares 32KB but the ROM is 16KB"}}},
+		{"bad RAM size code", func(rom []byte) { rom[ROM_OFFSET_RAM_SIZE] = 0x06 }, nil, 0x8000,
+			[]CompatibilityIssue{{false, "Unknown RAM size code 0x06"}}},
+	}
+	for _, test := range tests {
+		rom := headerTestROM(test.edit)
+		if test.corrupt != nil {
+			test.corrupt(rom)
+		}
+		header, err := ParseCartridgeHeader(rom[:test.size])
+		if err != nil {
+			t.Fatalf("Header: %s: %v", test.name, err)
+		}
+		issues := header.CompatibilityCheck(test.size)
+		if len(issues) != len(test.issues) {
+			t.Errorf("Header: %s: issues %v, expected %v", test.name, issues, test.issues)
+			continue
+		}
+		for i, issue := range issues {
+			if issue.Fatal != test.issues[i].Fatal || !strings.Contains(issue.Message, test.issues[i].Message) {
+				t.Errorf("Header: %s: issue %v, expected %v", test.name, issue, test.issues[i])
+			}
+		}
+	}
+}
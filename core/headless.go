@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+)
+
+// FrameCycles is the number of cycles in a frame, 154 lines of 456 cycles
+const FrameCycles = gpuCyclesLine * gpuLinesTotal
+
+// HeadlessOptions configures RunHeadless
+type HeadlessOptions struct {
+	// Frames is how long to run, counted in cycles so it also advances with the LCD off
+	Frames int
+	// Screenshot is where the final frame is saved as a PNG, "" to skip it
+	Screenshot string
+	// Movie is a movie to play, "" to run without input. The run ends when it
+	// finishes, or after Frames when that is not 0, and fails unless the last
+	// frame matches the one recorded.
+	Movie string
+	// Trace is where the state before each instruction is written, "" to skip it
+	Trace string
+}
+
+// RunHeadless loads a ROM and runs it as fast as possible for a number of frames,
+// without a window, audio or link cable. It returns the first error: a ROM that
+// fails to load, an unknown instruction, an instruction that panics or a screenshot
+// that cannot be saved.
+func (system *SystemType) RunHeadless(location string, options HeadlessOptions) error {
+	if err := system.OpenROM(location, ""); err != nil {
+		return err
+	}
+	system.CPU.Reset()
+	// serial output is captured rather than sent down a link cable
+	Serial.Peer = &SerialPrinter
+	if options.Trace != "" {
+		if err := Trace.Start(options.Trace); err != nil {
+			return err
+		}
+	}
+	var err error
+	if options.Movie != "" {
+		err = system.playMovie(options.Movie, options.Frames)
+	} else {
+		_, err = system.runFrames(options.Frames, nil)
+	}
+	// the trace is kept when execution fails, it shows how it got there
+	if traceErr := Trace.Stop(); err == nil {
+		err = traceErr
+	}
+	if err != nil {
+		return err
+	}
+	if options.Screenshot != "" {
+		if err := SaveScreenshot(options.Screenshot); err != nil {
+			return err
+		}
+		Logger.Log(LogTypes.INFO, "HEADLESS: saved "+options.Screenshot)
+	}
+	return nil
+}
+
+// runFrames executes instructions until frames worth of cycles have passed,
+// or until stop, called with each instruction fetched, known or not, returns true.
+// It reports whether stop ended the run.
+func (system *SystemType) runFrames(frames int, stop func(InstructionType) bool) (stopped bool, err error) {
+	cpu := system.CPU
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("instruction at 0x%04X failed: %v", cpu.REGISTERS.PC, r)
+		}
+	}()
+
+	cpu.RUNNING = true
+	defer func() { cpu.RUNNING = false }()
+	end := cpu.CYCLES + uint64(frames)*FrameCycles
+	for cpu.CYCLES < end {
+		instruction, err := cpu.Fetch()
+		if stop != nil && stop(instruction) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		Trace.Record()
+		cpu.Execute(instruction)
+		Movie.Update()
+	}
+	return false, nil
+}
+
+// playMovie plays a movie until it finishes, or for frames when not 0,
+// checking the last frame against the one recorded
+func (system *SystemType) playMovie(location string, frames int) error {
+	movie, err := ReadMovie(location)
+	if err != nil {
+		return err
+	}
+	if err := Movie.Play(movie); err != nil {
+		return err
+	}
+	if frames == 0 {
+		// time for frames the LCD was off during
+		frames = 2*movie.Frames() + 60
+	}
+	_, err = system.runFrames(frames, func(InstructionType) bool {
+		return !Movie.Playing()
+	})
+	if err != nil {
+		Movie.Stop()
+		return err
+	}
+	if Movie.Playing() {
+		Movie.Stop()
+		return ErrMovieUnplayed
+	}
+	if hash := FrameHash(); hash != movie.Hash {
+		return fmt.Errorf("%w: got %s, recorded %s", ErrMovieHash, hash, movie.Hash)
+	}
+	Logger.Logf(LogTypes.INFO, "HEADLESS: movie matched, %d frames\n", movie.Frames())
+	return nil
+}
+
+// SaveScreenshot writes the last completed frame as a PNG
+func SaveScreenshot(location string) error {
+	pixels, width, height := GPU.Frame()
+	picture := &image.RGBA{
+		Pix:    pixels,
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	file, err := os.Create(location)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, picture); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -202,7 +202,9 @@ type InstructionType struct {
 	// 0x10 - STOP
 	{
 		Exec: func(op interface{}) {
-			CPU.RUNNING = false
+			if !CGB.SpeedSwitch() {
+				CPU.RUNNING = false
+			}
 		},
 		Opcode:      0x10,
 		Name:        "STOP",
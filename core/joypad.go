@@ -0,0 +1,85 @@
+package core
+
+import (
+	"sync/atomic"
+)
+
+// Joypad buttons, as the bits of a button set
+const (
+	ButtonRight byte = 1 << iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+// JoypadType is the structure to define the joypad
+//
+//	Joypad Structure
+//	================
+//	---> Buttons held on the keyboard or controller
+//	---> Buttons the machine sees, which follow the held buttons
+//	     after each instruction or a frame at a time while a movie is recorded or played
+//	================
+type JoypadType struct {
+	// held is changed by the UI thread and read by the CPU thread, atomically
+	held    uint32
+	buttons byte
+}
+
+// Joypad is the exported object used in the system
+//
+// Joypad is exported so the UI can press and release buttons
+var Joypad = JoypadType{}
+
+// Press holds a button down, the machine sees it once Movie.Update applies it
+func (joypad *JoypadType) Press(button byte) {
+	joypad.hold(func(held byte) byte { return held | button })
+}
+
+// Release lets a button go, the machine sees it once Movie.Update applies it
+func (joypad *JoypadType) Release(button byte) {
+	joypad.hold(func(held byte) byte { return held &^ button })
+}
+
+// Held returns the buttons held on the keyboard or controller
+func (joypad *JoypadType) Held() byte {
+	return byte(atomic.LoadUint32(&joypad.held))
+}
+
+func (joypad *JoypadType) hold(change func(held byte) byte) {
+	for {
+		held := atomic.LoadUint32(&joypad.held)
+		if atomic.CompareAndSwapUint32(&joypad.held, held, uint32(change(byte(held)))) {
+			return
+		}
+	}
+}
+
+// set changes the buttons the machine sees, requesting the joypad interrupt
+// when one is newly pressed. It runs on the CPU thread.
+func (joypad *JoypadType) set(buttons byte) {
+	if buttons != joypad.buttons {
+		Reverse.recordInput(buttons)
+	}
+	if buttons&^joypad.buttons != 0 {
+		INTERRUPTS.flags |= 0x10
+	}
+	joypad.buttons = buttons
+}
+
+// ReadP1 returns P1 with the low bits cleared for the pressed buttons of the
+// groups selected by P14 (directions) and P15 (buttons)
+func (joypad *JoypadType) ReadP1(selected byte) byte {
+	lines := byte(0x0F)
+	if selected&0x10 == 0 {
+		lines &^= joypad.buttons & 0x0F
+	}
+	if selected&0x20 == 0 {
+		lines &^= joypad.buttons >> 4
+	}
+	return 0xC0 | selected&0x30 | lines
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// LinkModeOff leaves the serial port connected to the SerialPrinter
+	LinkModeOff = "off"
+	// LinkModeHost waits for another FreeMe!GB to connect on LinkPort
+	LinkModeHost = "host"
+	// LinkModeConnect connects to another FreeMe!GB at LinkHost:LinkPort
+	LinkModeConnect = "connect"
+	// LinkModePrinter plugs in the Game Boy Printer
+	LinkModePrinter = "printer"
+)
+
+// LinkPortDefault is the TCP port used when none is configured
+const LinkPortDefault = 5964
+
+// linkTimeout is how long an internally clocked transfer waits for the other side
+const linkTimeout = 2 * time.Second
+
+// link cable messages are three bytes, a type, the sequence number of the
+// transfer and the data byte
+const (
+	linkMessageTransfer byte = 0x01
+	linkMessageReply    byte = 0x02
+)
+
+// ErrLinkTimeout is returned when the other Game Boy does not answer a transfer
+var ErrLinkTimeout = errors.New("link cable timed out waiting for the other side")
+
+// LinkCableType is a SerialPeer exchanging bytes with another emulator over TCP.
+//
+// Transfers are lock-step: the side providing the clock sends its byte and waits
+// for the other side to shift its own byte back, which it does only once its
+// game has started an externally clocked transfer.
+//
+// Each transfer carries a sequence number echoed by the reply, so a reply
+// arriving after its transfer timed out is dropped instead of answering the next.
+type LinkCableType struct {
+	conn      net.Conn
+	incoming  chan linkMessage
+	replies   chan linkMessage
+	closed    chan bool
+	sequence  byte
+	writeLock sync.Mutex
+	closeOnce sync.Once
+	Timeout   time.Duration
+}
+
+// linkMessage is a transfer or reply read from the connection
+type linkMessage struct {
+	sequence byte
+	value    byte
+}
+
+// NewLinkCable starts exchanging link cable messages over an established connection
+func NewLinkCable(conn net.Conn) *LinkCableType {
+	link := &LinkCableType{
+		conn:     conn,
+		incoming: make(chan linkMessage, 1),
+		replies:  make(chan linkMessage, 1),
+		closed:   make(chan bool),
+		Timeout:  linkTimeout,
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+	go link.read()
+	return link
+}
+
+// ListenLinkCable waits for one connection on address
+func ListenLinkCable(address string) (*LinkCableType, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewLinkCable(conn), nil
+}
+
+// DialLinkCable connects to a FreeMe!GB waiting on address
+func DialLinkCable(address string) (*LinkCableType, error) {
+	conn, err := net.DialTimeout("tcp", address, linkTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewLinkCable(conn), nil
+}
+
+func (link *LinkCableType) read() {
+	message := make([]byte, 3)
+	for {
+		if _, err := readFull(link.conn, message); err != nil {
+			link.Close()
+			return
+		}
+		queue := link.incoming
+		if message[0] == linkMessageReply {
+			queue = link.replies
+		}
+		select {
+		case queue <- linkMessage{message[1], message[2]}:
+		case <-link.closed:
+			return
+		}
+	}
+}
+
+func readFull(conn net.Conn, buffer []byte) (int, error) {
+	n := 0
+	for n < len(buffer) {
+		read, err := conn.Read(buffer[n:])
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (link *LinkCableType) send(kind byte, sequence byte, value byte) error {
+	link.writeLock.Lock()
+	defer link.writeLock.Unlock()
+	_, err := link.conn.Write([]byte{kind, sequence, value})
+	return err
+}
+
+// Transfer sends a byte clocked by this Game Boy and waits for the other side's byte,
+// returning 0xFF as a disconnected cable would if it does not answer
+func (link *LinkCableType) Transfer(out byte) byte {
+	link.sequence++
+	if err := link.send(linkMessageTransfer, link.sequence, out); err != nil {
+		Logger.Log(LogTypes.ERROR, "LINK:", err)
+		return 0xFF
+	}
+	timeout := time.After(link.Timeout)
+	for {
+		select {
+		case reply := <-link.replies:
+			if reply.sequence == link.sequence {
+				return reply.value
+			}
+			// the late reply to a transfer that timed out
+		case <-link.closed:
+			return 0xFF
+		case <-timeout:
+			Logger.Log(LogTypes.WARNING, "LINK:", ErrLinkTimeout)
+			return 0xFF
+		}
+	}
+}
+
+// Receive completes a transfer clocked by the other side, if one is pending
+func (link *LinkCableType) Receive(out byte) (byte, bool) {
+	select {
+	case transfer := <-link.incoming:
+		if err := link.send(linkMessageReply, transfer.sequence, out); err != nil {
+			Logger.Log(LogTypes.ERROR, "LINK:", err)
+		}
+		return transfer.value, true
+	default:
+		return 0xFF, false
+	}
+}
+
+// Close disconnects the cable
+func (link *LinkCableType) Close() error {
+	var err error
+	link.closeOnce.Do(func() {
+		close(link.closed)
+		err = link.conn.Close()
+	})
+	return err
+}
+
+// LinkAddress returns the host:port from Settings
+func LinkAddress() string {
+	return net.JoinHostPort(Settings.LinkHost, strconv.Itoa(Settings.LinkPort))
+}
+
+var linkCable *LinkCableType
+var linkListener net.Listener
+var linkLock sync.Mutex
+
+// StartLinkCable connects the serial port as configured in Settings.LinkMode.
+// It returns at once, the serial port is switched over once the other side is found.
+func StartLinkCable() {
+	mode := Settings.LinkMode
+	if mode == LinkModePrinter {
+		CPU.Call(func() { Serial.Peer = &Printer })
+		return
+	}
+	if mode != LinkModeHost && mode != LinkModeConnect {
+		return
+	}
+	address := LinkAddress()
+	go func() {
+		var link *LinkCableType
+		var err error
+		if mode == LinkModeHost {
+			link, err = acceptLinkCable(net.JoinHostPort("", strconv.Itoa(Settings.LinkPort)))
+		} else {
+			link, err = DialLinkCable(address)
+		}
+		if err != nil {
+			Logger.Log(LogTypes.ERROR, "LINK:", err)
+			return
+		}
+		Logger.Logf(LogTypes.INFO, "LINK: connected to %s\n", link.conn.RemoteAddr())
+
+		linkLock.Lock()
+		if linkCable != nil {
+			linkCable.Close()
+		}
+		linkCable = link
+		// the CPU thread reads Serial.Peer on every serial step
+		CPU.Call(func() { Serial.Peer = link })
+		linkLock.Unlock()
+	}()
+}
+
+// acceptLinkCable is ListenLinkCable with the listener kept so StopLinkCable can cancel the wait
+func acceptLinkCable(address string) (*LinkCableType, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	Logger.Logf(LogTypes.INFO, "LINK: waiting for a connection on %s\n", listener.Addr())
+	linkLock.Lock()
+	linkListener = listener
+	linkLock.Unlock()
+
+	conn, err := listener.Accept()
+	linkLock.Lock()
+	linkListener = nil
+	linkLock.Unlock()
+	listener.Close()
+	if err != nil {
+		return nil, err
+	}
+	return NewLinkCable(conn), nil
+}
+
+// StopLinkCable disconnects the link cable or printer and reconnects the SerialPrinter
+func StopLinkCable() {
+	linkLock.Lock()
+	defer linkLock.Unlock()
+	if linkListener != nil {
+		linkListener.Close()
+		linkListener = nil
+	}
+	if linkCable != nil {
+		linkCable.Close()
+		linkCable = nil
+	}
+	Serial.Peer = &SerialPrinter
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// linkPeerEnvironment names the link cable address a child test process
+// connects to as the second Game Boy
+const linkPeerEnvironment = "FREEMEGB_LINK_PEER"
+
+// linkSide is the program one Game Boy runs in TestLinkCable. The first
+// transfer is started at 0x0150 and the second at 0x0600, each followed by a
+// jump to itself: the CPU has no working flags for a polling loop, so the test
+// moves PC on once a transfer completes. The byte shifted in by the first
+// transfer is kept at 0xC000.
+type linkSide struct {
+	first, second       byte // sent in each transfer
+	firstSC, secondSC   byte // 0x81 provides the clock, 0x80 waits for the other side
+	received, clockedIn byte // expected at 0xC000 and in SB at the end
+}
+
+var linkHost = linkSide{0x42, 0x24, 0x81, 0x80, 0x99, 0x5A}
+var linkGuest = linkSide{0x99, 0x5A, 0x80, 0x81, 0x42, 0x24}
+
+func (side linkSide) rom() []byte {
+	program := make([]byte, 0x0610-0x0150)
+	copy(program, []byte{
+		0x3E, side.first, //   0x0150 LD A, first
+		0xE0, 0x01, //         0x0152 LDH (0x01), A
+		0x3E, side.firstSC, // 0x0154 LD A, firstSC
+		0xE0, 0x02, //         0x0156 LDH (0x02), A
+		0xC3, 0x58, 0x01, //   0x0158 JP 0x0158
+	})
+	copy(program[0x0600-0x0150:], []byte{
+		0xF0, 0x01, //          0x0600 LDH A, (0x01)
+		0xEA, 0x00, 0xC0, //    0x0602 LD (0xC000), A
+		0x3E, side.second, //   0x0605 LD A, second
+		0xE0, 0x01, //          0x0607 LDH (0x01), A
+		0x3E, side.secondSC, // 0x0609 LD A, secondSC
+		0xE0, 0x02, //          0x060B LDH (0x02), A
+		0xC3, 0x0D, 0x06, //    0x060D JP 0x060D
+	})
+	return programROM(program)
+}
+
+// run runs the program on this process' core with its serial port plugged
+// into link, returning what went wrong
+func (side linkSide) run(link *LinkCableType) error {
+	if err := ROM.Load(side.rom()); err != nil {
+		return err
+	}
+	CGB.Enabled = false
+	SGB.Enabled = false
+	powerOn()
+	Serial.Peer = link
+	defer func() { Serial.Peer = &SerialPrinter }()
+
+	for i, loop := range []uint16{0x0158, 0x060D} {
+		if i > 0 {
+			CPU.REGISTERS.PC = 0x0600
+		}
+		INTERRUPTS.flags = 0x00
+		if err := runUntilTransferred(loop); err != nil {
+			return err
+		}
+		if INTERRUPTS.flags&0x08 == 0 {
+			return fmt.Errorf("no serial interrupt requested by the transfer before 0x%04X", loop)
+		}
+	}
+	if value := MMU.ReadByte(0xC000); value != side.received {
+		return fmt.Errorf("the first transfer shifted in 0x%02X, expected 0x%02X", value, side.received)
+	}
+	if value := MMU.ReadByte(0xFF01); value != side.clockedIn {
+		return fmt.Errorf("the second transfer shifted in 0x%02X, expected 0x%02X", value, side.clockedIn)
+	}
+	return nil
+}
+
+// runUntilTransferred runs until the program loops at address with no transfer in progress
+func runUntilTransferred(address uint16) error {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		stopped, err := System.runFrames(1, func(instruction InstructionType) bool {
+			return CPU.REGISTERS.PC == address && Serial.control&0x80 == 0
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return fmt.Errorf("the transfer before 0x%04X never completed", address)
+}
+
+// TestLinkCable connects two FreeMe!GB cores over a local TCP link cable. The
+// core is a set of package globals, so the second Game Boy is this test binary
+// run again as a child process, clocking the transfer the first one waits for
+// and the other way round.
+func TestLinkCable(t *testing.T) {
+	if address := os.Getenv(linkPeerEnvironment); address != "" {
+		link, err := DialLinkCable(address)
+		if err != nil {
+			t.Fatalf("Link Cable: cannot connect: %v", err)
+		}
+		defer link.Close()
+		if err := linkGuest.run(link); err != nil {
+			t.Fatalf("Link Cable: guest: %v", err)
+		}
+		return
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Link Cable: cannot listen: %v", err)
+	}
+	defer listener.Close()
+	guest := exec.Command(os.Args[0], "-test.run=^TestLinkCable$")
+	guest.Env = append(os.Environ(), linkPeerEnvironment+"="+listener.Addr().String())
+	var output strings.Builder
+	guest.Stdout, guest.Stderr = &output, &output
+	if err := guest.Start(); err != nil {
+		t.Fatalf("Link Cable: cannot start the guest: %v", err)
+	}
+	defer guest.Process.Kill()
+
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(30 * time.Second))
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Link Cable: the guest never connected: %v\n%s", err, output.String())
+	}
+	link := NewLinkCable(conn)
+	defer link.Close()
+	if err := linkHost.run(link); err != nil {
+		t.Errorf("Link Cable: host: %v", err)
+	}
+	if err := guest.Wait(); err != nil {
+		t.Errorf("Link Cable: guest: %v\n%s", err, output.String())
+	}
+}
+
+// TestLinkCableLateReply expects a reply arriving after its transfer timed out
+// to be dropped rather than taken as the answer to the next transfer
+func TestLinkCableLateReply(t *testing.T) {
+	local, remote := net.Pipe()
+	link := NewLinkCable(local)
+	defer link.Close()
+	defer remote.Close()
+	link.Timeout = 50 * time.Millisecond
+
+	transfers := make(chan []byte, 2)
+	go func() {
+		for {
+			message := make([]byte, 3)
+			if _, err := readFull(remote, message); err != nil {
+				close(transfers)
+				return
+			}
+			transfers <- message
+		}
+	}()
+
+	if in := link.Transfer(0x01); in != 0xFF {
+		t.Errorf("Link Cable: unanswered transfer returned 0x%02X, expected 0xFF", in)
+	}
+	first := <-transfers
+	remote.Write([]byte{linkMessageReply, first[1], 0x11})
+
+	result := make(chan byte)
+	go func() { result <- link.Transfer(0x02) }()
+	second := <-transfers
+	if second[1] == first[1] || second[2] != 0x02 {
+		t.Fatalf("Link Cable: sent %v after %v, expected a new sequence number", second, first)
+	}
+	remote.Write([]byte{linkMessageReply, second[1], 0x22})
+	if in := <-result; in != 0x22 {
+		t.Errorf("Link Cable: second transfer returned 0x%02X, expected 0x22", in)
+	}
+}
@@ -3,12 +3,18 @@
 import (
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/fatih/color"
 )
 
 type LoggerType struct {
 	InternalLogger *log.Logger
+
+	// Console receives the coloured console output, os.Stdout when nil
+	Console *os.File
+	// Quiet drops INFO messages, the progress notes such as the loaded ROM and serial output
+	Quiet bool
 }
 
 type LogType struct {
@@ -28,6 +34,9 @@ type LogType struct {
 var Logger LoggerType
 
 func (logger *LoggerType) Log(Ltype byte, v ...interface{}) {
+	if logger.Quiet && Ltype == LogTypes.INFO {
+		return
+	}
 	switch Ltype {
 	case 0: // INFO
 		logger.InternalLogger.SetPrefix("<span foreground='#555555' font='bold'>[INFO]:\t\t\t</span>")
@@ -46,28 +55,31 @@ func (logger *LoggerType) Log(Ltype byte, v ...interface{}) {
 	switch Ltype {
 	case 0: // INFO
 		c := color.New(color.FgHiBlack).Add(color.Bold)
-		c.Printf("%-15s", "[INFO]:")
-		fmt.Println(v...)
+		c.Fprintf(logger.console(), "%-15s", "[INFO]:")
+		fmt.Fprintln(logger.console(), v...)
 		break
 	case 1: // ERROR
 		c := color.New(color.FgHiRed).Add(color.Bold)
-		c.Printf("%-15s", "[ERROR]:")
-		fmt.Println(v...)
+		c.Fprintf(logger.console(), "%-15s", "[ERROR]:")
+		fmt.Fprintln(logger.console(), v...)
 		break
 	case 2: // WARNING
 		c := color.New(color.FgHiYellow).Add(color.Bold)
-		c.Printf("%-15s", "[WARNING]:")
-		fmt.Println(v...)
+		c.Fprintf(logger.console(), "%-15s", "[WARNING]:")
+		fmt.Fprintln(logger.console(), v...)
 		break
 	case 3: // COMPLETED
 		c := color.New(color.FgHiGreen).Add(color.Bold)
-		c.Printf("%-15s", "[COMPLETED]:")
-		fmt.Println(v...)
+		c.Fprintf(logger.console(), "%-15s", "[COMPLETED]:")
+		fmt.Fprintln(logger.console(), v...)
 		break
 	}
 }
 
 func (logger *LoggerType) Logf(Ltype byte, format string, v ...interface{}) {
+	if logger.Quiet && Ltype == LogTypes.INFO {
+		return
+	}
 	switch Ltype {
 	case 0: // INFO
 		logger.InternalLogger.SetPrefix("<span foreground='#555555' font='bold'>[INFO]:\t\t\t</span>")
@@ -86,27 +98,34 @@ func (logger *LoggerType) Logf(Ltype byte, format string, v ...interface{}) {
 	switch Ltype {
 	case 0: // INFO
 		c := color.New(color.FgHiBlack).Add(color.Bold)
-		c.Printf("%-15s", "[INFO]:")
-		fmt.Printf(format, v...)
+		c.Fprintf(logger.console(), "%-15s", "[INFO]:")
+		fmt.Fprintf(logger.console(), format, v...)
 		break
 	case 1: // ERROR
 		c := color.New(color.FgHiRed).Add(color.Bold)
-		c.Printf("%-15s", "[ERROR]:")
-		fmt.Printf(format, v...)
+		c.Fprintf(logger.console(), "%-15s", "[ERROR]:")
+		fmt.Fprintf(logger.console(), format, v...)
 		break
 	case 2: // WARNING
 		c := color.New(color.FgHiYellow).Add(color.Bold)
-		c.Printf("%-15s", "[WARNING]:")
-		fmt.Printf(format, v...)
+		c.Fprintf(logger.console(), "%-15s", "[WARNING]:")
+		fmt.Fprintf(logger.console(), format, v...)
 		break
 	case 3: // COMPLETED
 		c := color.New(color.FgHiGreen).Add(color.Bold)
-		c.Printf("%-15s", "[COMPLETED]:")
-		fmt.Printf(format, v...)
+		c.Fprintf(logger.console(), "%-15s", "[COMPLETED]:")
+		fmt.Fprintf(logger.console(), format, v...)
 		break
 	}
 }
 
+func (logger *LoggerType) console() *os.File {
+	if logger.Console == nil {
+		return os.Stdout
+	}
+	return logger.Console
+}
+
 func (logger *LoggerType) Panic(v ...interface{}) {
 	logger.InternalLogger.SetPrefix("<span foreground='#FF0000' font='bold'>[ERROR]</span>")
 	logger.InternalLogger.Panic(v...)
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// TestMain discards the log, which the UI otherwise sends to its console
+func TestMain(m *testing.M) {
+	Logger.InternalLogger = log.New(ioutil.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// programROM builds a ROM with a valid header that jumps over it to program at 0x0150
+func programROM(program []byte) []byte {
+	rom := make([]byte, 0x150+len(program))
+	copy(rom[0x100:], []byte{0x18, 0x4E}) // JR 0x0150
+	copy(rom[0x150:], program)
+	return testROM(rom)
+}
+
+// loadProgram loads program as the ROM of a DMG and switches the machine on
+func loadProgram(t *testing.T, program []byte) {
+	if err := ROM.Load(programROM(program)); err != nil {
+		t.Fatalf("Program: %v", err)
+	}
+	CGB.Enabled = false
+	SGB.Enabled = false
+	powerOn()
+}
@@ -1,19 +1,23 @@
 package core
 
-import (
-	"math/rand"
-)
-
 type MMUType struct {
+	// bootROM is mapped over the cartridge until a write to 0xFF50
+	bootROM []byte
+	// divReset is the CPU cycle count when DIV was last reset
+	divReset uint64
 }
 
 var MMU = MMUType{}
 
 var sRAM [0x2000]byte
 var io [0x100]byte
-var vRAM [0x2000]byte
+
+// vRAM holds both CGB VRAM banks, bank 1 starting at 0x2000
+var vRAM [0x4000]byte
 var oam [0x100]byte
-var wRAM [0x2000]byte
+
+// wRAM holds all eight CGB WRAM banks of 0x1000 bytes each
+var wRAM [0x8000]byte
 var hRAM [0x80]byte
 
 const OFFSETsRAM uint16 = 0xA000
@@ -25,30 +29,76 @@ type MMUType struct {
 const OFFSETio uint16 = 0xFF00
 
 func (mmu *MMUType) ReadByte(address uint16) byte {
-	if address <= 0x7FFF {
+	if GDBServer.watching {
+		GDBServer.watch(address, watchRead)
+	}
+	if value, ok := mmu.readBootROM(address); ok {
+		return value
+	} else if address <= 0x7FFF {
 		return ROM.data[address]
 	} else if address >= 0xA000 && address <= 0xBFFF {
 		return sRAM[address-OFFSETsRAM]
 	} else if address >= 0x8000 && address <= 0x9FFF {
-		return vRAM[address-OFFSETvRAM]
+		return vRAM[vRAMIndex(address)]
 	} else if address >= 0xC000 && address <= 0xDFFF {
-		return wRAM[address-OFFSETwRAMlower]
+		return wRAM[wRAMIndex(address-OFFSETwRAMlower)]
 	} else if address >= 0xE000 && address <= 0xFDFF {
-		return wRAM[address-OFFSETwRAMupper]
+		return wRAM[wRAMIndex(address-OFFSETwRAMupper)]
 	} else if address >= 0xFE00 && address <= 0xFEFF {
 		return oam[address-OFFSEToam]
 	} else if address == 0xFF04 {
-		return byte(rand.Intn(0xFF + 1)) // generate random value range: [0, 255] (byte)
+		// DIV counts up every 256 cycles, derived from the cycle count so execution is deterministic
+		return byte((CPU.CYCLES - mmu.divReset) >> 8)
+	} else if address == 0xFF01 {
+		return Serial.data
+	} else if address == 0xFF02 {
+		return Serial.ReadControl()
+	} else if address >= 0xFF10 && address <= 0xFF3F {
+		return APU.ReadRegister(address)
 	} else if address == 0xFF40 {
 		return GPU.control
+	} else if address == 0xFF41 {
+		return GPU.ReadStatus()
 	} else if address == 0xFF42 {
 		return GPU.scrollY
 	} else if address == 0xFF43 {
 		return GPU.scrollX
 	} else if address == 0xFF44 {
+		if Trace.StubLY {
+			return 0x90
+		}
 		return GPU.scanline
+	} else if address == 0xFF45 {
+		return GPU.compare
+	} else if address == 0xFF47 {
+		return GPU.bgPalette
+	} else if address == 0xFF48 {
+		return GPU.objPalette0
+	} else if address == 0xFF49 {
+		return GPU.objPalette1
+	} else if address == 0xFF4A {
+		return GPU.windowY
+	} else if address == 0xFF4B {
+		return GPU.windowX
+	} else if CGB.Enabled && address == 0xFF4D {
+		return CGB.ReadKEY1()
+	} else if CGB.Enabled && address == 0xFF4F {
+		return 0xFE | CGB.vRAMBank
+	} else if CGB.Enabled && address == 0xFF55 {
+		return CGB.ReadHDMA5()
+	} else if CGB.Enabled && address == 0xFF68 {
+		return CGB.bgPaletteIndex | 0x40
+	} else if CGB.Enabled && address == 0xFF69 {
+		return readPaletteData(&CGB.bgPaletteRAM, CGB.bgPaletteIndex)
+	} else if CGB.Enabled && address == 0xFF6A {
+		return CGB.objPaletteIndex | 0x40
+	} else if CGB.Enabled && address == 0xFF6B {
+		return readPaletteData(&CGB.objPaletteRAM, CGB.objPaletteIndex)
+	} else if CGB.Enabled && address == 0xFF70 {
+		return 0xF8 | CGB.wRAMBank
 	} else if address == 0xFF00 {
-		// io block
+		// io block, the selected button groups
+		return Joypad.ReadP1(io[0])
 	} else if address == 0xFF0F {
 		return INTERRUPTS.flags
 	} else if address == 0xFFFF {
@@ -62,30 +112,87 @@ func (mmu *MMUType) ReadByte(address uint16) byte {
 }
 
 func (mmu *MMUType) WriteByte(address uint16, value byte) {
-
+	if GDBServer.watching {
+		GDBServer.watch(address, watchWrite)
+	}
 	if address <= 0x7FFF {
 		ROM.data[address] = value
 	} else if address >= 0xA000 && address <= 0xBFFF {
 		sRAM[address-OFFSETsRAM] = value
 	} else if address >= 0x8000 && address <= 0x9FFF {
-		vRAM[address-OFFSETvRAM] = value
+		vRAM[vRAMIndex(address)] = value
 		//update tile too
 	} else if address >= 0xC000 && address <= 0xDFFF {
-		wRAM[address-OFFSETwRAMlower] = value
+		wRAM[wRAMIndex(address-OFFSETwRAMlower)] = value
 	} else if address >= 0xE000 && address <= 0xFDFF {
-		wRAM[address-OFFSETwRAMupper] = value
+		wRAM[wRAMIndex(address-OFFSETwRAMupper)] = value
 	} else if address >= 0xFE00 && address <= 0xFEFF {
 		oam[address-OFFSEToam] = value
+	} else if address == 0xFF04 {
+		// any write resets DIV
+		mmu.divReset = CPU.CYCLES
+	} else if address == 0xFF50 {
+		// any nonzero write unmaps the boot ROM for good
+		if value != 0 {
+			mmu.bootROM = nil
+		}
+		io[address-OFFSETio] = value
+	} else if address == 0xFF01 {
+		Serial.data = value
+	} else if address == 0xFF02 {
+		Serial.WriteControl(value)
+	} else if address >= 0xFF10 && address <= 0xFF3F {
+		APU.WriteRegister(address, value)
 	} else if address == 0xFF40 {
 		GPU.control = value
+	} else if address == 0xFF41 {
+		GPU.WriteStatus(value)
 	} else if address == 0xFF42 {
 		GPU.scrollY = value
 	} else if address == 0xFF43 {
 		GPU.scrollX = value
+	} else if address == 0xFF44 {
+		// LY is read-only
+	} else if address == 0xFF45 {
+		GPU.WriteCompare(value)
 	} else if address == 0xFF46 {
 		//OAM DMA copy
+		source := uint16(value) << 8
+		for i := uint16(0); i < 0xA0; i++ {
+			oam[i] = mmu.ReadByte(source + i)
+		}
+	} else if address == 0xFF47 {
+		GPU.bgPalette = value
+	} else if address == 0xFF48 {
+		GPU.objPalette0 = value
+	} else if address == 0xFF49 {
+		GPU.objPalette1 = value
+	} else if address == 0xFF4A {
+		GPU.windowY = value
+	} else if address == 0xFF4B {
+		GPU.windowX = value
+	} else if CGB.Enabled && address == 0xFF4D {
+		CGB.WriteKEY1(value)
+	} else if CGB.Enabled && address == 0xFF4F {
+		CGB.WriteVBK(value)
+	} else if CGB.Enabled && address >= 0xFF51 && address <= 0xFF55 {
+		CGB.WriteHDMA(address, value)
+	} else if CGB.Enabled && address == 0xFF68 {
+		CGB.bgPaletteIndex = value & 0xBF
+	} else if CGB.Enabled && address == 0xFF69 {
+		writePaletteData(&CGB.bgPaletteRAM, &CGB.bgPaletteIndex, value)
+	} else if CGB.Enabled && address == 0xFF6A {
+		CGB.objPaletteIndex = value & 0xBF
+	} else if CGB.Enabled && address == 0xFF6B {
+		writePaletteData(&CGB.objPaletteRAM, &CGB.objPaletteIndex, value)
+	} else if CGB.Enabled && address == 0xFF70 {
+		CGB.WriteSVBK(value)
 	} else if address == 0xFF00 {
 		// io block
+		io[0] = value & 0x30
+		if SGB.Enabled {
+			SGB.WriteP1(value)
+		}
 	} else if address == 0xFF0F {
 		INTERRUPTS.flags = value
 	} else if address == 0xFFFF {
@@ -95,6 +202,35 @@ func (mmu *MMUType) WriteByte(address uint16, value byte) {
 	}
 }
 
+// clear empties the memory arrays, as when the machine is switched on
+func (mmu *MMUType) clear() {
+	sRAM = [len(sRAM)]byte{}
+	io = [len(io)]byte{}
+	vRAM = [len(vRAM)]byte{}
+	oam = [len(oam)]byte{}
+	wRAM = [len(wRAM)]byte{}
+	hRAM = [len(hRAM)]byte{}
+}
+
+// vRAMIndex maps a 0x8000-0x9FFF address into vRAM using the VBK bank
+func vRAMIndex(address uint16) uint16 {
+	return uint16(CGB.vRAMBank)*0x2000 + address - OFFSETvRAM
+}
+
+// wRAMIndex maps an offset into 0xC000-0xDFFF onto wRAM,
+// the upper 0x1000 bytes using the SVBK bank
+func wRAMIndex(offset uint16) uint16 {
+	offset &= 0x1FFF
+	if offset < 0x1000 {
+		return offset
+	}
+	bank := uint16(1)
+	if CGB.Enabled {
+		bank = uint16(CGB.wRAMBank)
+	}
+	return bank*0x1000 + offset - 0x1000
+}
+
 func (mmu *MMUType) ReadShort(address uint16) uint16 {
 	return uint16(uint16(mmu.ReadByte(address)) | uint16(mmu.ReadByte((address+1)))<<8)
 }
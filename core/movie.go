@@ -0,0 +1,294 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// MovieVersion is written into every movie.
+//
+// Movies use the chunks of save states after their own magic: INFO holds the
+// ROM and hardware, STAT the save state a movie starts from, absent when it
+// starts at power on, INPT the buttons held in each frame and HASH the last frame.
+const MovieVersion = 1
+
+// MovieExtension is the extension given to movie files
+const MovieExtension = ".fmv"
+
+// movieMagic starts every movie
+const movieMagic = "FMGBMOVI"
+
+// Errors returned when a movie cannot be played
+var (
+	ErrNotMovie      = errors.New("not a FreeMe!GB movie")
+	ErrMovieROM      = errors.New("movie was recorded with a different ROM")
+	ErrMovieBootROM  = errors.New("movie was recorded with a different boot ROM")
+	ErrMovieNoMovie  = errors.New("no movie is being recorded")
+	ErrMovieHash     = errors.New("last frame does not match the movie")
+	ErrMovieUnplayed = errors.New("movie did not finish playing")
+	ErrMovieNoROM    = ErrSaveStateNoROM
+)
+
+// MovieFile is a recorded movie
+type MovieFile struct {
+	ROMKey string
+	// Model is the hardware model, ModelDMG, ModelMGB, ModelCGB or ModelSGB
+	Model string
+	// BootROM is the SHA-1 of the boot ROM run at power on, "" when it was skipped
+	BootROM  string
+	Recorded time.Time
+	// Start is the save state the movie starts from, nil to start at power on
+	Start []byte
+	// Inputs are the buttons held in each frame
+	Inputs []byte
+	// Hash is FrameHash of the last frame
+	Hash string
+}
+
+// Frames returns the length of the movie in frames
+func (movie *MovieFile) Frames() int {
+	return len(movie.Inputs)
+}
+
+// Save writes the movie to a file
+func (movie *MovieFile) Save(location string) error {
+	var w stateWriter
+	w.bytes([]byte(movieMagic))
+	w.uint16(MovieVersion)
+	w.chunk("INFO", func(chunk *stateWriter) {
+		chunk.slice([]byte(movie.ROMKey))
+		chunk.slice([]byte(movie.Model))
+		chunk.slice([]byte(movie.BootROM))
+		chunk.uint64(uint64(movie.Recorded.Unix()))
+	})
+	if movie.Start != nil {
+		w.chunk("STAT", func(chunk *stateWriter) {
+			chunk.bytes(movie.Start)
+		})
+	}
+	w.chunk("INPT", func(chunk *stateWriter) {
+		chunk.bytes(movie.Inputs)
+	})
+	w.chunk("HASH", func(chunk *stateWriter) {
+		chunk.slice([]byte(movie.Hash))
+	})
+	return ioutil.WriteFile(location, w.buffer.Bytes(), 0644)
+}
+
+// ReadMovie reads a movie written by Save
+func ReadMovie(location string) (*MovieFile, error) {
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	chunks, _, err := readChunks(data, movieMagic, ErrNotMovie)
+	if err != nil {
+		return nil, err
+	}
+	movie := &MovieFile{}
+	if chunk, ok := chunks["INFO"]; ok {
+		movie.ROMKey = string(chunk.slice())
+		movie.Model = string(chunk.slice())
+		movie.BootROM = string(chunk.slice())
+		movie.Recorded = time.Unix(int64(chunk.uint64()), 0)
+	}
+	if chunk, ok := chunks["STAT"]; ok {
+		movie.Start = chunk.data
+	}
+	if chunk, ok := chunks["INPT"]; ok {
+		movie.Inputs = chunk.data
+	}
+	if chunk, ok := chunks["HASH"]; ok {
+		movie.Hash = string(chunk.slice())
+	}
+	return movie, nil
+}
+
+// movie modes
+const (
+	movieOff = iota
+	movieRecording
+	moviePlaying
+)
+
+// MovieType records the buttons held in each frame and plays them back.
+// While a movie is active the machine sees the buttons change only at the
+// start of a frame, so playback matches the recording exactly.
+type MovieType struct {
+	// OnEnd is called from the CPU thread when a movie finishes playing
+	OnEnd func()
+
+	mode       int
+	movie      *MovieFile
+	startFrame uint64
+}
+
+// Movie is the exported object used in the system
+//
+// Movie is exported so the UI can record and play movies
+var Movie = MovieType{}
+
+// Active reports whether a movie is being recorded or played
+func (recorder *MovieType) Active() bool {
+	return recorder.mode != movieOff
+}
+
+// Recording reports whether a movie is being recorded
+func (recorder *MovieType) Recording() bool {
+	return recorder.mode == movieRecording
+}
+
+// Playing reports whether a movie is being played
+func (recorder *MovieType) Playing() bool {
+	return recorder.mode == moviePlaying
+}
+
+// Record starts recording, from power on or from the running machine.
+// Like Snapshot it runs between instructions.
+func (recorder *MovieType) Record(fromPowerOn bool) error {
+	if ROM.GetKey() == "" {
+		return ErrMovieNoROM
+	}
+	movie := &MovieFile{
+		ROMKey:   ROM.GetKey(),
+		Model:    currentModel(),
+		Recorded: time.Now(),
+	}
+	if fromPowerOn {
+		powerOn()
+		movie.BootROM = bootROMHash()
+	} else {
+		movie.Start = Snapshot()
+	}
+	recorder.start(movieRecording, movie)
+	Logger.Log(LogTypes.INFO, "MOVIE: recording")
+	return nil
+}
+
+// Stop ends recording or playback, returning the movie recorded
+func (recorder *MovieType) Stop() (*MovieFile, error) {
+	mode, movie := recorder.mode, recorder.movie
+	recorder.mode = movieOff
+	recorder.movie = nil
+	Joypad.set(Joypad.Held())
+	if mode != movieRecording {
+		return nil, ErrMovieNoMovie
+	}
+	// the frame being drawn is incomplete, the movie ends with the last one completed
+	movie.Inputs = movie.Inputs[:GPU.frames-recorder.startFrame]
+	movie.Hash = FrameHash()
+	Logger.Logf(LogTypes.INFO, "MOVIE: recorded %d frames\n", movie.Frames())
+	return movie, nil
+}
+
+// Play starts playing a movie, restoring the machine it was recorded on.
+// Like Restore it runs between instructions.
+func (recorder *MovieType) Play(movie *MovieFile) error {
+	if ROM.GetKey() == "" {
+		return ErrMovieNoROM
+	}
+	if movie.ROMKey != ROM.GetKey() {
+		return fmt.Errorf("%w: %s", ErrMovieROM, movie.ROMKey)
+	}
+	if movie.Model != ModelDMG && movie.Model != ModelMGB && movie.Model != ModelCGB && movie.Model != ModelSGB {
+		return fmt.Errorf("%w: unknown hardware model %q", ErrNotMovie, movie.Model)
+	}
+	MGB = movie.Model == ModelMGB
+	CGB.Enabled = movie.Model == ModelCGB
+	SGB.Enabled = movie.Model == ModelSGB
+	if movie.Start != nil {
+		if err := Restore(movie.Start); err != nil {
+			return err
+		}
+	} else {
+		powerOn()
+		if hash := bootROMHash(); hash != movie.BootROM {
+			return ErrMovieBootROM
+		}
+	}
+	recorder.start(moviePlaying, movie)
+	Logger.Logf(LogTypes.INFO, "MOVIE: playing %d frames\n", movie.Frames())
+	return nil
+}
+
+func (recorder *MovieType) start(mode int, movie *MovieFile) {
+	recorder.mode = mode
+	recorder.movie = movie
+	recorder.startFrame = GPU.frames
+	Reverse.Clear()
+	recorder.Update()
+}
+
+// Update applies the buttons held, or those of a new frame while a movie is
+// recorded or played, called after each instruction
+func (recorder *MovieType) Update() {
+	if recorder.mode == movieOff {
+		Joypad.set(Joypad.Held())
+		return
+	}
+	frame := int(GPU.frames - recorder.startFrame)
+	movie := recorder.movie
+	switch recorder.mode {
+	case movieRecording:
+		for len(movie.Inputs) <= frame {
+			movie.Inputs = append(movie.Inputs, Joypad.Held())
+			Joypad.set(Joypad.Held())
+		}
+	case moviePlaying:
+		if frame < len(movie.Inputs) {
+			Joypad.set(movie.Inputs[frame])
+			return
+		}
+		recorder.mode = movieOff
+		recorder.movie = nil
+		Joypad.set(Joypad.Held())
+		Logger.Log(LogTypes.INFO, "MOVIE: finished, last frame "+FrameHash())
+		if recorder.OnEnd != nil {
+			recorder.OnEnd()
+		}
+	}
+}
+
+// FrameHash hashes the last completed frame as the GPU produced it,
+// before palettes and borders are applied for display
+func FrameHash() string {
+	GPU.frameLock.Lock()
+	defer GPU.frameLock.Unlock()
+	hash := sha1.New()
+	binary.Write(hash, binary.LittleEndian, &GPU.frame)
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// powerOn resets the machine as if it was switched on, with cleared memory
+// and screen, leaving Run running
+func powerOn() {
+	running := CPU.RUNNING
+	MMU.clear()
+	GPU.reset()
+	Joypad.buttons = 0
+	CPU.Reset()
+	CPU.RUNNING = running
+}
+
+func currentModel() string {
+	switch {
+	case CGB.Enabled:
+		return ModelCGB
+	case SGB.Enabled:
+		return ModelSGB
+	case MGB:
+		return ModelMGB
+	}
+	return ModelDMG
+}
+
+func bootROMHash() string {
+	if MMU.bootROM == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha1.Sum(MMU.bootROM))
+}
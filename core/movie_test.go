@@ -0,0 +1,138 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// movieProgram copies P1 into memory from VRAM upwards, so the screen depends
+// on the buttons held. It reaches the I/O registers after about five frames.
+var movieProgram = []byte{
+	0x21, 0x00, 0x80, // 0x0150 LD HL, 0x8000
+	0xF0, 0x00, //       0x0153 LDH A, (0x00)
+	0x22,             // 0x0155 LD (HL+), A
+	0xC3, 0x53, 0x01, // 0x0156 JP 0x0153
+}
+
+// movieInputs are the buttons held in each frame of the recording
+var movieInputs = []byte{ButtonA, ButtonA | ButtonRight, ButtonStart, ButtonDown | ButtonB}
+
+// recordMovie records a movie from power on holding inputs, one per frame
+func recordMovie(t *testing.T, inputs []byte) *MovieFile {
+	// the buttons held are read at the start of each frame
+	Joypad.Release(0xFF)
+	Joypad.Press(inputs[0])
+	if err := Movie.Record(true); err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	for _, buttons := range inputs {
+		Joypad.Release(0xFF)
+		Joypad.Press(buttons)
+		if _, err := System.runFrames(1, nil); err != nil {
+			t.Fatalf("Movie: %v", err)
+		}
+	}
+	Joypad.Release(0xFF)
+	movie, err := Movie.Stop()
+	if err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	return movie
+}
+
+// TestMoviePlayback records a movie, saves and reads it, and expects playback
+// to end on the same frame
+func TestMoviePlayback(t *testing.T) {
+	loadProgram(t, movieProgram)
+	movie := recordMovie(t, movieInputs)
+	if movie.Frames() == 0 {
+		t.Fatalf("Movie: no frames recorded")
+	}
+	if idle := recordMovie(t, make([]byte, len(movieInputs))); idle.Hash == movie.Hash {
+		t.Errorf("Movie: the buttons held did not change the screen")
+	}
+
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, "test"+MovieExtension)
+	if err := movie.Save(location); err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	if err := System.playMovie(location, 0); err != nil {
+		t.Errorf("Movie: %v", err)
+	}
+
+	// a different ROM is refused
+	rom := testROM(nil)
+	rom[0x14F]++
+	if err := ROM.Load(rom); err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	if err := System.playMovie(location, 0); !errors.Is(err, ErrMovieROM) {
+		t.Errorf("Movie: got %v, expected %v", err, ErrMovieROM)
+	}
+}
+
+// TestMovieInput presses buttons from another goroutine while the machine runs,
+// as the UI does, and refuses to load a state while a movie is recorded
+func TestMovieInput(t *testing.T) {
+	loadProgram(t, movieProgram)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			Joypad.Press(ButtonA)
+			Joypad.Release(ButtonA)
+		}
+		Joypad.Press(ButtonStart)
+		close(done)
+	}()
+	if _, err := System.runFrames(1, nil); err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	<-done
+	if _, err := System.runFrames(1, nil); err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	Joypad.Release(0xFF)
+	if Joypad.buttons != ButtonStart {
+		t.Errorf("Movie: the machine sees buttons 0x%02X, expected 0x%02X", Joypad.buttons, ButtonStart)
+	}
+
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := StateDir
+	StateDir = dir
+	defer func() { StateDir = stateDir }()
+	if err := SaveStateSlot(1); err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	if err := Movie.Record(false); err != nil {
+		t.Fatalf("Movie: %v", err)
+	}
+	defer Movie.Stop()
+	if err := LoadStateSlot(1); !errors.Is(err, ErrSaveStateMovie) {
+		t.Errorf("Movie: loading a state while recording got %v, expected %v", err, ErrSaveStateMovie)
+	}
+
+	for i := 0; i < 10; i++ {
+		Reverse.Record()
+		instruction, err := CPU.Fetch()
+		if err != nil {
+			t.Fatalf("Movie: %v", err)
+		}
+		CPU.Execute(instruction)
+	}
+	count, pc := Reverse.Count(), CPU.REGISTERS.PC
+	if Reverse.StepBack() || Reverse.ReverseContinue() || Reverse.Count() != count || CPU.REGISTERS.PC != pc {
+		t.Errorf("Movie: reverse debugging moved the machine while recording")
+	}
+}
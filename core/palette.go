@@ -0,0 +1,217 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PaletteDefault is the name of the palette used when none is selected
+const PaletteDefault = "grey"
+
+// PaletteDir is where imported palettes are kept
+var PaletteDir = path.Join(UserHome, ".freemegb", "palettes")
+
+// PaletteType is the structure that maps the four DMG shades, lightest first, to RGB
+type PaletteType struct {
+	Name    string
+	Colours [4][3]byte
+}
+
+// Palettes are the builtin palette presets
+var Palettes = []PaletteType{
+	{
+		Name: "grey",
+		Colours: [4][3]byte{
+			{0xFF, 0xFF, 0xFF},
+			{0xAA, 0xAA, 0xAA},
+			{0x55, 0x55, 0x55},
+			{0x00, 0x00, 0x00},
+		},
+	},
+	{
+		Name: "pocket",
+		Colours: [4][3]byte{
+			{0xC4, 0xCF, 0xA1},
+			{0x8B, 0x95, 0x6D},
+			{0x4D, 0x53, 0x3C},
+			{0x1F, 0x1F, 0x1F},
+		},
+	},
+	{
+		Name: "green",
+		Colours: [4][3]byte{
+			{0x9B, 0xBC, 0x0F},
+			{0x8B, 0xAC, 0x0F},
+			{0x30, 0x62, 0x30},
+			{0x0F, 0x38, 0x0F},
+		},
+	},
+	{
+		Name: "contrast",
+		Colours: [4][3]byte{
+			{0xFF, 0xFF, 0xFF},
+			{0xFF, 0xD7, 0x00},
+			{0x00, 0x50, 0xFF},
+			{0x00, 0x00, 0x00},
+		},
+	},
+}
+
+// FindPalettes returns the builtin palettes followed by the imported ones
+func FindPalettes() []PaletteType {
+	palettes := append([]PaletteType{}, Palettes...)
+	entries, err := ioutil.ReadDir(PaletteDir)
+	if err != nil {
+		return palettes
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".pal") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		palette, err := ReadPalette(path.Join(PaletteDir, name))
+		if err != nil {
+			Logger.Logf(LogTypes.WARNING, "PALETTE: skipping %s: %v\n", name, err)
+			continue
+		}
+		// a file named after a builtin palette would never be selected
+		palette.Name = importedPaletteName(palette.Name)
+		palettes = append(palettes, palette)
+	}
+	return palettes
+}
+
+// importedPaletteName renames an imported palette that has the name of a
+// builtin palette, as FindPalette would always pick the builtin one
+func importedPaletteName(name string) string {
+	for _, palette := range Palettes {
+		if palette.Name == name {
+			return name + "-imported"
+		}
+	}
+	return name
+}
+
+// FindPalette returns the palette with the given name, or the default palette
+func FindPalette(name string) PaletteType {
+	palettes := FindPalettes()
+	for _, palette := range palettes {
+		if palette.Name == name {
+			return palette
+		}
+	}
+	return palettes[0]
+}
+
+// ReadPalette parses a palette file.
+// A 12 byte file is read as four raw RGB triplets; anything else is read as text
+// holding four RRGGBB hex colours (optionally prefixed by # or 0x), lightest first,
+// separated by whitespace or commas. Lines starting with ; or // are comments.
+func ReadPalette(location string) (PaletteType, error) {
+	palette := PaletteType{
+		Name: strings.TrimSuffix(path.Base(location), path.Ext(location)),
+	}
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return palette, err
+	}
+	if len(data) == 12 && !isPaletteText(data) {
+		for i := 0; i < 4; i++ {
+			copy(palette.Colours[i][:], data[i*3:i*3+3])
+		}
+		return palette, nil
+	}
+
+	colours := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		colours = append(colours, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})...)
+	}
+	if len(colours) != 4 {
+		return palette, fmt.Errorf("expected 4 colours, found %d", len(colours))
+	}
+	for i, colour := range colours {
+		colour = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(colour), "#"), "0x")
+		if len(colour) != 6 {
+			return palette, errors.New("colour " + colours[i] + " is not RRGGBB")
+		}
+		value, err := strconv.ParseUint(colour, 16, 32)
+		if err != nil {
+			return palette, errors.New("colour " + colours[i] + " is not RRGGBB")
+		}
+		palette.Colours[i] = [3]byte{byte(value >> 16), byte(value >> 8), byte(value)}
+	}
+	return palette, nil
+}
+
+func isPaletteText(data []byte) bool {
+	for _, b := range data {
+		if b != '\t' && b != '\n' && b != '\r' && (b < 0x20 || b > 0x7E) {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportPalette validates a palette file and copies it into PaletteDir,
+// renaming it when a builtin palette has the same name
+func ImportPalette(location string) (PaletteType, error) {
+	palette, err := ReadPalette(location)
+	if err != nil {
+		return palette, err
+	}
+	if name := importedPaletteName(palette.Name); name != palette.Name {
+		Logger.Logf(LogTypes.WARNING, "PALETTE: %s is a builtin palette, importing it as %s\n", palette.Name, name)
+		palette.Name = name
+	}
+	os.MkdirAll(PaletteDir, os.FileMode(0755))
+	var text strings.Builder
+	text.WriteString("; imported from " + path.Base(location) + "\n")
+	for _, colour := range palette.Colours {
+		text.WriteString(fmt.Sprintf("#%02X%02X%02X\n", colour[0], colour[1], colour[2]))
+	}
+	err = ioutil.WriteFile(path.Join(PaletteDir, palette.Name+".pal"), []byte(text.String()), 0644)
+	if err == nil {
+		Logger.Log(LogTypes.COMPLETED, "PALETTE: imported "+palette.Name)
+	}
+	return palette, err
+}
+
+// ROMPalette returns the name of the palette selected for the loaded ROM,
+// falling back to the default palette in Settings
+func ROMPalette() string {
+	if name, ok := Settings.ROMPalettes[ROM.GetKey()]; ok {
+		return name
+	}
+	return Settings.Palette
+}
+
+// SetROMPalette selects a palette for the loaded ROM only.
+// An empty name removes the ROM's selection so the default palette applies.
+func SetROMPalette(name string) {
+	key := ROM.GetKey()
+	if key == "" {
+		return
+	}
+	if name == "" {
+		delete(Settings.ROMPalettes, key)
+	} else {
+		Settings.ROMPalettes[key] = name
+	}
+	Settings.Save()
+	GPU.SetPalette(FindPalette(ROMPalette()))
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReadPalette reads palettes as raw RGB bytes and as hex text in the
+// accepted spellings, and rejects malformed files
+func TestReadPalette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Palette: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := [4][3]byte{{0xE0, 0xF8, 0xD0}, {0x88, 0xC0, 0x70}, {0x34, 0x68, 0x56}, {0x08, 0x18, 0x20}}
+	tests := []struct {
+		name string
+		data string
+		err  bool
+	}{
+		{"binary", "\xE0\xF8\xD0\x88\xC0\x70\x34\x68\x56\x08\x18\x20", false},
+		{"lines", "#E0F8D0\n#88C070\n#346856\n#081820\n", false},
+		{"one line", "0xe0f8d0, 0x88c070,\t346856 081820", false},
+		{"comments", "; exported\r\n// lightest first\r\nE0F8D0\r\n88C070\r\n346856\r\n081820\r\n", false},
+		// twelve printable bytes are text, not colours
+		{"short text", "E0F8D088C070", true},
+		{"three colours", "#E0F8D0 #88C070 #346856", true},
+		{"five colours", "#E0F8D0 #88C070 #346856 #081820 #000000", true},
+		{"short colour", "#E0F8D0 #88C070 #346856 #08182", true},
+		{"not hex", "#E0F8D0 #88C070 #346856 #08182G", true},
+	}
+	for _, test := range tests {
+		location := filepath.Join(dir, test.name+".pal")
+		if err := ioutil.WriteFile(location, []byte(test.data), 0644); err != nil {
+			t.Fatalf("Palette: %v", err)
+		}
+		palette, err := ReadPalette(location)
+		if (err != nil) != test.err {
+			t.Errorf("Palette: %s: error %v, expected an error %v", test.name, err, test.err)
+			continue
+		}
+		if palette.Name != test.name {
+			t.Errorf("Palette: %s: named %q after its file", test.name, palette.Name)
+		}
+		if err == nil && palette.Colours != expected {
+			t.Errorf("Palette: %s: colours %X, expected %X", test.name, palette.Colours, expected)
+		}
+	}
+	if _, err := ReadPalette(filepath.Join(dir, "missing.pal")); err == nil {
+		t.Errorf("Palette: reading a missing file succeeded")
+	}
+}
+
+// TestImportPalette imports a palette named after a builtin one, expecting it
+// renamed so FindPalette can select it while the builtin stays the same
+func TestImportPalette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Palette: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	paletteDir := PaletteDir
+	PaletteDir = filepath.Join(dir, "palettes")
+	defer func() { PaletteDir = paletteDir }()
+
+	location := filepath.Join(dir, "grey.pal")
+	if err := ioutil.WriteFile(location, []byte("#101010 #202020 #303030 #404040"), 0644); err != nil {
+		t.Fatalf("Palette: %v", err)
+	}
+	palette, err := ImportPalette(location)
+	if err != nil {
+		t.Fatalf("Palette: %v", err)
+	}
+	if palette.Name != "grey-imported" {
+		t.Errorf("Palette: imported grey as %q, expected grey-imported", palette.Name)
+	}
+	if imported := FindPalette("grey-imported"); imported.Colours[0] != [3]byte{0x10, 0x10, 0x10} {
+		t.Errorf("Palette: grey-imported selected %X, expected the imported colours", imported.Colours)
+	}
+	if builtin := FindPalette("grey"); builtin.Colours != Palettes[0].Colours {
+		t.Errorf("Palette: grey selected %X, expected the builtin colours", builtin.Colours)
+	}
+
+	// a file copied into the palette directory by hand is renamed the same way
+	if err := ioutil.WriteFile(filepath.Join(PaletteDir, "pocket.pal"), []byte("#111111 #222222 #333333 #444444"), 0644); err != nil {
+		t.Fatalf("Palette: %v", err)
+	}
+	names := map[string]int{}
+	for _, palette := range FindPalettes() {
+		names[palette.Name]++
+	}
+	if names["pocket"] != 1 || names["pocket-imported"] != 1 {
+		t.Errorf("Palette: found %v, expected pocket and pocket-imported once each", names)
+	}
+}
@@ -0,0 +1,309 @@
+package core
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Game Boy Printer commands
+const (
+	printerCommandInit   byte = 0x01
+	printerCommandPrint  byte = 0x02
+	printerCommandData   byte = 0x04
+	printerCommandStatus byte = 0x0F
+)
+
+// Game Boy Printer status bits
+const (
+	printerStatusChecksum    byte = 0x01
+	printerStatusPrinting    byte = 0x02
+	printerStatusFull        byte = 0x04
+	printerStatusUnprocessed byte = 0x08
+)
+
+// printerWidth is the printer's line width in pixels, 20 tiles
+const printerWidth = 160
+
+// printerMaxData is the printer's image buffer size, 9 bands of 2 tile rows
+const printerMaxData = 9 * 0x280
+
+// printerPrintingPolls is how many status requests report printing after a print command
+const printerPrintingPolls = 4
+
+// printer packet parser states, in packet order
+const (
+	printerStateMagic1 = iota
+	printerStateMagic2
+	printerStateCommand
+	printerStateCompression
+	printerStateLengthLow
+	printerStateLengthHigh
+	printerStateData
+	printerStateChecksumLow
+	printerStateChecksumHigh
+	printerStateAlive
+	printerStateStatus
+)
+
+// PrintDir is where printouts are saved as PNG files
+var PrintDir = path.Join(UserHome, ".freemegb", "prints")
+
+// PrinterType is a SerialPeer emulating the Game Boy Printer.
+//
+// The game clocks every byte. A packet is the magic bytes 0x88 0x33, a command,
+// a compression flag, a little endian data length, the data and a checksum,
+// after which the printer answers 0x81 and its status in the last two bytes.
+type PrinterType struct {
+	state       int
+	command     byte
+	compression byte
+	length      uint16
+	packet      []byte
+	checksum    uint16
+	received    uint16
+
+	status        byte
+	printingPolls int
+	image         []byte
+
+	lock sync.Mutex
+}
+
+// Printer is the exported object used in the system
+//
+// Printer is exported so it can be plugged into the serial port
+var Printer PrinterType
+
+// Transfer shifts in one byte of a packet, returning the printer's response
+func (printer *PrinterType) Transfer(out byte) byte {
+	printer.lock.Lock()
+	defer printer.lock.Unlock()
+
+	response := byte(0x00)
+	switch printer.state {
+	case printerStateMagic1:
+		if out == 0x88 {
+			printer.state = printerStateMagic2
+		}
+	case printerStateMagic2:
+		if out == 0x33 {
+			printer.state = printerStateCommand
+		} else {
+			printer.state = printerStateMagic1
+		}
+	case printerStateCommand:
+		printer.command = out
+		printer.checksum = uint16(out)
+		printer.state = printerStateCompression
+	case printerStateCompression:
+		printer.compression = out
+		printer.checksum += uint16(out)
+		printer.state = printerStateLengthLow
+	case printerStateLengthLow:
+		printer.length = uint16(out)
+		printer.checksum += uint16(out)
+		printer.state = printerStateLengthHigh
+	case printerStateLengthHigh:
+		printer.length |= uint16(out) << 8
+		printer.checksum += uint16(out)
+		printer.packet = printer.packet[:0]
+		printer.state = printerStateData
+		if printer.length == 0 {
+			printer.state = printerStateChecksumLow
+		}
+	case printerStateData:
+		printer.packet = append(printer.packet, out)
+		printer.checksum += uint16(out)
+		if uint16(len(printer.packet)) == printer.length {
+			printer.state = printerStateChecksumLow
+		}
+	case printerStateChecksumLow:
+		printer.received = uint16(out)
+		printer.state = printerStateChecksumHigh
+	case printerStateChecksumHigh:
+		printer.received |= uint16(out) << 8
+		printer.state = printerStateAlive
+	case printerStateAlive:
+		response = 0x81
+		printer.state = printerStateStatus
+	case printerStateStatus:
+		if printer.received != printer.checksum {
+			printer.status |= printerStatusChecksum
+		} else {
+			printer.status &^= printerStatusChecksum
+			printer.execute()
+		}
+		response = printer.status
+		printer.state = printerStateMagic1
+	}
+	return response
+}
+
+// Receive never completes, the printer is always clocked by the Game Boy
+func (printer *PrinterType) Receive(out byte) (byte, bool) {
+	return 0x00, false
+}
+
+// execute runs a packet whose checksum matched
+func (printer *PrinterType) execute() {
+	switch printer.command {
+	case printerCommandInit:
+		printer.image = printer.image[:0]
+		printer.status = 0x00
+		printer.printingPolls = 0
+	case printerCommandData:
+		data := printer.packet
+		if printer.compression != 0 {
+			data = DecompressPrinterData(data)
+		}
+		if len(printer.image)+len(data) > printerMaxData {
+			data = data[:printerMaxData-len(printer.image)]
+		}
+		printer.image = append(printer.image, data...)
+		if len(printer.image) == printerMaxData {
+			printer.status |= printerStatusFull
+		}
+		if len(printer.image) > 0 {
+			printer.status |= printerStatusUnprocessed
+		}
+	case printerCommandPrint:
+		if len(printer.packet) < 4 {
+			return
+		}
+		palette := printer.packet[2]
+		if palette == 0x00 {
+			// some games leave the palette unset, meaning the standard 0xE4 mapping
+			palette = 0xE4
+		}
+		if location, err := printer.save(palette); err != nil {
+			Logger.Log(LogTypes.ERROR, "PRINTER:", err)
+		} else if location != "" {
+			Logger.Log(LogTypes.INFO, "PRINTER: printed "+location)
+		}
+		printer.image = printer.image[:0]
+		printer.status &^= printerStatusUnprocessed | printerStatusFull
+		printer.status |= printerStatusPrinting
+		printer.printingPolls = printerPrintingPolls
+	case printerCommandStatus:
+		if printer.printingPolls > 0 {
+			printer.printingPolls--
+			if printer.printingPolls == 0 {
+				printer.status &^= printerStatusPrinting
+			}
+		}
+	}
+}
+
+// DecompressPrinterData expands the printer's run length encoding.
+// A control byte with bit 7 set repeats the next byte (control&0x7F)+2 times,
+// otherwise the next (control+1) bytes are copied as they are.
+func DecompressPrinterData(data []byte) []byte {
+	var output []byte
+	for i := 0; i < len(data); {
+		control := data[i]
+		i++
+		if control&0x80 != 0 {
+			if i >= len(data) {
+				break
+			}
+			for n := 0; n < int(control&0x7F)+2; n++ {
+				output = append(output, data[i])
+			}
+			i++
+		} else {
+			end := i + int(control) + 1
+			if end > len(data) {
+				end = len(data)
+			}
+			output = append(output, data[i:end]...)
+			i = end
+		}
+	}
+	return output
+}
+
+// PrinterImage decodes tile data received by the printer, 20 tiles to a row,
+// mapping each colour through the print palette onto the current DMG palette
+func PrinterImage(data []byte, palette byte, colours [4][3]byte) *image.RGBA {
+	tilesWide := printerWidth / 8
+	rows := len(data) / (tilesWide * 16)
+	picture := image.NewRGBA(image.Rect(0, 0, printerWidth, rows*8))
+	for tile := 0; tile < rows*tilesWide; tile++ {
+		tileX := (tile % tilesWide) * 8
+		tileY := (tile / tilesWide) * 8
+		for y := 0; y < 8; y++ {
+			low := data[tile*16+y*2]
+			high := data[tile*16+y*2+1]
+			for x := 0; x < 8; x++ {
+				bit := uint(7 - x)
+				colour := (low>>bit)&0x01 | ((high>>bit)&0x01)<<1
+				shade := (palette >> (colour * 2)) & 0x03
+				rgb := colours[shade]
+				picture.Set(tileX+x, tileY+y, color.RGBA{rgb[0], rgb[1], rgb[2], 0xFF})
+			}
+		}
+	}
+	return picture
+}
+
+// save writes the image buffer as a PNG in PrintDir, returning its location
+func (printer *PrinterType) save(palette byte) (string, error) {
+	if len(printer.image) < printerWidth/8*16 {
+		return "", nil
+	}
+	if err := os.MkdirAll(PrintDir, os.FileMode(0755)); err != nil {
+		return "", err
+	}
+	name := "print_" + time.Now().Format("20060102_150405")
+	location := path.Join(PrintDir, name+".png")
+	for n := 2; fileExists(location); n++ {
+		location = path.Join(PrintDir, fmt.Sprintf("%s_%d.png", name, n))
+	}
+
+	// the UI thread changes the palette under the frame lock
+	GPU.frameLock.Lock()
+	colours := GPU.palette
+	GPU.frameLock.Unlock()
+
+	file, err := os.Create(location)
+	if err != nil {
+		return "", err
+	}
+	if err := png.Encode(file, PrinterImage(printer.image, palette, colours)); err != nil {
+		file.Close()
+		return "", err
+	}
+	return location, file.Close()
+}
+
+func fileExists(location string) bool {
+	_, err := os.Stat(location)
+	return err == nil
+}
+
+// FindPrints returns the saved printouts, newest first
+func FindPrints() []string {
+	files, err := ioutil.ReadDir(PrintDir)
+	if err != nil {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	var prints []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(strings.ToLower(file.Name()), ".png") {
+			prints = append(prints, path.Join(PrintDir, file.Name()))
+		}
+	}
+	return prints
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// printerPacket builds a packet with its checksum, followed by the two bytes
+// clocked to read the printer's answer
+func printerPacket(command byte, compression byte, data []byte) []byte {
+	packet := []byte{0x88, 0x33, command, compression, byte(len(data)), byte(len(data) >> 8)}
+	packet = append(packet, data...)
+	checksum := uint16(0)
+	for _, value := range packet[2:] {
+		checksum += uint16(value)
+	}
+	return append(packet, byte(checksum), byte(checksum>>8), 0x00, 0x00)
+}
+
+// sendPrinterPacket clocks a packet into the printer, returning its answer
+// and status
+func sendPrinterPacket(printer *PrinterType, packet []byte) (byte, byte) {
+	var responses []byte
+	for _, value := range packet {
+		responses = append(responses, printer.Transfer(value))
+	}
+	return responses[len(responses)-2], responses[len(responses)-1]
+}
+
+func TestDecompressPrinterData(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		output []byte
+	}{
+		{"empty", nil, nil},
+		{"literal", []byte{0x02, 0x01, 0x02, 0x03}, []byte{0x01, 0x02, 0x03}},
+		{"run", []byte{0x81, 0xAA}, []byte{0xAA, 0xAA, 0xAA}},
+		{"shortest run", []byte{0x80, 0x55}, []byte{0x55, 0x55}},
+		{"run then literal", []byte{0x82, 0xFF, 0x00, 0x11}, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x11}},
+		{"literal then run", []byte{0x01, 0x11, 0x22, 0x80, 0x33}, []byte{0x11, 0x22, 0x33, 0x33}},
+		{"truncated run", []byte{0x85}, nil},
+		{"truncated literal", []byte{0x05, 0x01, 0x02}, []byte{0x01, 0x02}},
+	}
+	for _, test := range tests {
+		if output := DecompressPrinterData(test.data); !bytes.Equal(output, test.output) {
+			t.Errorf("Printer: %s decompressed to % X, expected % X", test.name, output, test.output)
+		}
+	}
+}
+
+// TestPrinterChecksum drops a packet whose checksum does not match
+func TestPrinterChecksum(t *testing.T) {
+	printer := &PrinterType{}
+	sendPrinterPacket(printer, printerPacket(printerCommandInit, 0, nil))
+
+	packet := printerPacket(printerCommandData, 0, make([]byte, 0x280))
+	packet[len(packet)-4]++
+	if alive, status := sendPrinterPacket(printer, packet); alive != 0x81 || status&printerStatusChecksum == 0 {
+		t.Errorf("Printer: bad checksum answered 0x%02X status 0x%02X", alive, status)
+	}
+	if len(printer.image) != 0 {
+		t.Errorf("Printer: kept %d bytes of a packet with a bad checksum", len(printer.image))
+	}
+	if _, status := sendPrinterPacket(printer, printerPacket(printerCommandStatus, 0, nil)); status&printerStatusChecksum != 0 {
+		t.Errorf("Printer: checksum error still reported after a good packet, status 0x%02X", status)
+	}
+}
+
+// TestPrinterPrint sends a band as raw and as compressed data, prints it and
+// expects a PNG with both bands, then the printer busy for a few polls
+func TestPrinterPrint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Printer: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	printDir := PrintDir
+	PrintDir = dir
+	defer func() { PrintDir = printDir }()
+
+	GPU.SetPalette(Palettes[0])
+	printer := &PrinterType{}
+	sendPrinterPacket(printer, printerPacket(printerCommandInit, 0, nil))
+	band := bytes.Repeat([]byte{0xFF, 0x00}, 0x280/2)
+	if _, status := sendPrinterPacket(printer, printerPacket(printerCommandData, 0, band)); status&printerStatusUnprocessed == 0 {
+		t.Errorf("Printer: status 0x%02X after data, expected unprocessed data", status)
+	}
+	var compressed []byte
+	for i := 0; i < 0x280/2; i++ {
+		compressed = append(compressed, 0x01, 0x00, 0xFF)
+	}
+	sendPrinterPacket(printer, printerPacket(printerCommandData, 1, compressed))
+	if len(printer.image) != 2*0x280 {
+		t.Fatalf("Printer: holding %d bytes, expected %d", len(printer.image), 2*0x280)
+	}
+
+	_, status := sendPrinterPacket(printer, printerPacket(printerCommandPrint, 0, []byte{0x01, 0x13, 0xE4, 0x40}))
+	if status&printerStatusPrinting == 0 || status&printerStatusUnprocessed != 0 {
+		t.Errorf("Printer: status 0x%02X after printing", status)
+	}
+	for i := 0; i < printerPrintingPolls; i++ {
+		_, status = sendPrinterPacket(printer, printerPacket(printerCommandStatus, 0, nil))
+	}
+	if status&printerStatusPrinting != 0 {
+		t.Errorf("Printer: still printing after %d polls", printerPrintingPolls)
+	}
+
+	prints := FindPrints()
+	if len(prints) != 1 {
+		t.Fatalf("Printer: %d prints saved, expected 1", len(prints))
+	}
+	file, err := os.Open(prints[0])
+	if err != nil {
+		t.Fatalf("Printer: %v", err)
+	}
+	defer file.Close()
+	picture, err := png.Decode(file)
+	if err != nil {
+		t.Fatalf("Printer: %v", err)
+	}
+	if bounds := picture.Bounds(); bounds.Dx() != printerWidth || bounds.Dy() != 32 {
+		t.Fatalf("Printer: printed %dx%d, expected %dx32", bounds.Dx(), bounds.Dy(), printerWidth)
+	}
+	// 0xFF low and 0x00 high bytes are colour 1, the other band is colour 2
+	light, _, _, _ := picture.At(0, 0).RGBA()
+	dark, _, _, _ := picture.At(0, 16).RGBA()
+	if light <= dark {
+		t.Errorf("Printer: raw band 0x%04X is not lighter than the compressed band 0x%04X", light, dark)
+	}
+}
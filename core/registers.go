@@ -408,6 +408,9 @@ func (r *RegistersType) UpdateRegisterTable(registerTreeView *gtk.TreeView, regi
 			for j := 0; j < flagsValues.NumField(); j++ {
 				row = append(row, flags.Field(j).Name)
 			}
+		} else if registers.Field(i).Name == "PC" && Symbols.Label(value) != "" {
+			// PC is named after the label it is in
+			row = append(row, fmt.Sprintf("0x%04X (%s)\n", value, Symbols.Label(value)))
 		} else {
 			row = append(row, r.Register16toString(value))
 		}
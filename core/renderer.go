@@ -0,0 +1,75 @@
+package core
+
+import (
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// Renderer names as stored in Settings.Renderer
+const (
+	RendererAuto     = "auto"
+	RendererOpenGL   = "opengl"
+	RendererSoftware = "software"
+)
+
+// Renderer is the interface implemented by each way of putting GPU frames on screen
+type Renderer interface {
+	// Name returns the Settings.Renderer value selecting this renderer
+	Name() string
+	// Widget returns the GTK widget the renderer draws into
+	Widget() gtk.IWidget
+	// QueueFrame asks the widget to redraw with the latest GPU frame
+	QueueFrame()
+	// Destroy releases the renderer's resources
+	Destroy()
+}
+
+// ActiveRenderer is the renderer currently attached to the emulator window
+var ActiveRenderer Renderer
+
+// AttachRenderer creates the renderer selected in Settings and packs it into box.
+// The OpenGL renderer is replaced by the software renderer if OpenGL fails to initialise.
+func AttachRenderer(box *gtk.Box) {
+	var renderer Renderer
+	var err error
+	if Settings.Renderer == RendererSoftware {
+		renderer, err = NewSoftwareRenderer()
+	} else {
+		renderer, err = NewGLRenderer(func() {
+			Logger.Log(LogTypes.WARNING, "RENDERER: OpenGL unavailable, falling back to software rendering")
+			glib.IdleAdd(func() { switchToSoftware(box) })
+		})
+		if err != nil {
+			Logger.Log(LogTypes.WARNING, "RENDERER: OpenGL unavailable, falling back to software rendering", err)
+			renderer, err = NewSoftwareRenderer()
+		}
+	}
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "RENDERER: Error creating renderer", err)
+		return
+	}
+	attach(box, renderer)
+}
+
+func switchToSoftware(box *gtk.Box) {
+	renderer, err := NewSoftwareRenderer()
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "RENDERER: Error creating software renderer", err)
+		return
+	}
+	if ActiveRenderer != nil {
+		box.Remove(ActiveRenderer.Widget())
+		ActiveRenderer.Destroy()
+	}
+	attach(box, renderer)
+}
+
+func attach(box *gtk.Box, renderer Renderer) {
+	ActiveRenderer = renderer
+	box.PackStart(renderer.Widget(), true, true, 0)
+	box.ShowAll()
+	GPU.OnFrame = func() {
+		glib.IdleAdd(renderer.QueueFrame)
+	}
+	Logger.Log(LogTypes.INFO, "RENDERER: using "+renderer.Name())
+}
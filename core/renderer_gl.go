@@ -0,0 +1,310 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-gl/gl/v4.6-core/gl"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// VertexSource is the builtin vertex shader.
+// Shader presets receive the same inputs: a vec2 position and a vec2 texCoord.
+const VertexSource string = `#version 460 core
+    in vec2 position;
+    in vec2 texCoord;
+    out vec2 fragTexCoord;
+
+    void main()
+    {
+        fragTexCoord = texCoord;
+        gl_Position = vec4(position, 0.0, 1.0);
+    }`
+
+// FragmentSource is the builtin fragment shader.
+// Shader presets sample the GPU frame from the screen uniform.
+const FragmentSource string = `#version 460 core
+	in  vec2 fragTexCoord;
+	out vec4 outputColor;
+	uniform sampler2D screen;
+
+	void main()
+	{
+	    outputColor = texture(screen, fragTexCoord);
+	}`
+
+// screenQuad is a triangle strip covering the viewport, as x, y, u, v per vertex
+var screenQuad = []float32{
+	-1.0, 1.0, 0.0, 0.0,
+	-1.0, -1.0, 0.0, 1.0,
+	1.0, 1.0, 1.0, 0.0,
+	1.0, -1.0, 1.0, 1.0,
+}
+
+// GLRendererType draws GPU frames as a texture in a GTK GLArea
+type GLRendererType struct {
+	glarea    *gtk.GLArea
+	onFailure func()
+	failed    bool
+	width     int
+
+	program   uint32
+	vao       uint32
+	vbo       uint32
+	texture   uint32
+	reload    bool
+	watchStop chan bool
+}
+
+// NewGLRenderer creates an OpenGL 4.6 renderer.
+// onFailure is called if the OpenGL context cannot be initialised once realized.
+func NewGLRenderer(onFailure func()) (*GLRendererType, error) {
+	glarea, err := gtk.GLAreaNew()
+	if err != nil {
+		return nil, err
+	}
+	glarea.SetRequiredVersion(4, 6)
+	glarea.SetSizeRequest(ScreenWidth, ScreenHeight)
+
+	renderer := &GLRendererType{
+		glarea:    glarea,
+		onFailure: onFailure,
+		width:     ScreenWidth,
+	}
+	glarea.Connect("realize", renderer.Init)
+	glarea.Connect("render", renderer.Run)
+	glarea.Connect("unrealize", renderer.Destroy)
+	return renderer, nil
+}
+
+// Name returns the Settings.Renderer value selecting this renderer
+func (renderer *GLRendererType) Name() string {
+	return RendererOpenGL
+}
+
+// Widget returns the GLArea the renderer draws into
+func (renderer *GLRendererType) Widget() gtk.IWidget {
+	return renderer.glarea
+}
+
+// QueueFrame asks the GLArea to render the latest GPU frame
+func (renderer *GLRendererType) QueueFrame() {
+	if !renderer.failed {
+		renderer.glarea.QueueRender()
+	}
+}
+
+func (renderer *GLRendererType) fail(err error) {
+	Logger.Log(LogTypes.ERROR, "RENDERER:", err)
+	renderer.failed = true
+	if renderer.onFailure != nil {
+		renderer.onFailure()
+	}
+}
+
+// Init sets up OpenGL, the shaders and the screen texture when the GLArea is realized
+func (renderer *GLRendererType) Init() {
+	glarea := renderer.glarea
+	glarea.MakeCurrent()
+	if err := glarea.GetError(); err != nil {
+		renderer.fail(err)
+		return
+	}
+
+	// Init OpenGL
+	if err := gl.Init(); err != nil {
+		renderer.fail(err)
+		return
+	}
+	version := gl.GoStr(gl.GetString(gl.VERSION))
+	Logger.Log(LogTypes.INFO, "RENDERER: OpenGL version "+version)
+
+	renderer.LoadShaders()
+	if renderer.program == 0 {
+		renderer.fail(errors.New("no usable shader program"))
+		return
+	}
+
+	// Init Buffers
+	gl.GenVertexArrays(1, &renderer.vao)
+	gl.BindVertexArray(renderer.vao)
+	gl.GenBuffers(1, &renderer.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, renderer.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(screenQuad)*4, gl.Ptr(screenQuad), gl.STATIC_DRAW)
+	gl.BindVertexArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	// Init Screen Texture
+	gl.GenTextures(1, &renderer.texture)
+	gl.BindTexture(gl.TEXTURE_2D, renderer.texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	renderer.watchStop = make(chan bool)
+	go WatchShaders(renderer.watchStop, func() {
+		// Run reads the flag on the GTK thread
+		glib.IdleAdd(func() {
+			renderer.reload = true
+			renderer.QueueFrame()
+		})
+	})
+}
+
+// LoadShaders builds the shader program from the preset selected in Settings,
+// falling back to the builtin shaders if the preset fails to compile or link
+func (renderer *GLRendererType) LoadShaders() {
+	preset := FindShaderPreset(Settings.Shader)
+	if preset.Name != Settings.Shader {
+		Logger.Logf(LogTypes.WARNING, "SHADER: preset %s not found, using %s\n", Settings.Shader, preset.Name)
+	}
+	program, err := buildShaderProgram(preset)
+	if err != nil {
+		Logger.Logf(LogTypes.ERROR, "SHADER: %s\n", html.EscapeString(err.Error()))
+		if preset.Name == ShaderPresetBuiltin {
+			return
+		}
+		program, err = buildShaderProgram(ShaderPresetType{Name: ShaderPresetBuiltin})
+		if err != nil {
+			Logger.Logf(LogTypes.ERROR, "SHADER: %s\n", html.EscapeString(err.Error()))
+			return
+		}
+		preset.Name = ShaderPresetBuiltin
+	}
+	if renderer.program != 0 {
+		gl.DeleteProgram(renderer.program)
+	}
+	renderer.program = program
+	Logger.Logf(LogTypes.INFO, "SHADER: using preset %s\n", preset.Name)
+}
+
+func buildShaderProgram(preset ShaderPresetType) (uint32, error) {
+	vertexSrc, fragmentSrc, err := preset.Sources()
+	if err != nil {
+		return 0, err
+	}
+	vertexShader, err := compileShader(vertexSrc, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, fmt.Errorf("%s vertex shader: %v", preset.Name, err)
+	}
+	fragmentShader, err := compileShader(fragmentSrc, gl.FRAGMENT_SHADER)
+	if err != nil {
+		gl.DeleteShader(vertexShader)
+		return 0, fmt.Errorf("%s fragment shader: %v", preset.Name, err)
+	}
+
+	// Init Shader Program
+	program := gl.CreateProgram()
+	gl.AttachShader(program, vertexShader)
+	gl.AttachShader(program, fragmentShader)
+	gl.LinkProgram(program)
+	gl.DeleteShader(vertexShader)
+	gl.DeleteShader(fragmentShader)
+
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
+		gl.DeleteProgram(program)
+		return 0, fmt.Errorf("%s link failed: %s", preset.Name, strings.TrimRight(log, "\x00"))
+	}
+	return program, nil
+}
+
+func compileShader(source string, shaderType uint32) (uint32, error) {
+	shader := gl.CreateShader(shaderType)
+	src, free := gl.Strs(source + "\x00")
+	gl.ShaderSource(shader, 1, src, nil)
+	free()
+	gl.CompileShader(shader)
+
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
+		gl.DeleteShader(shader)
+		return 0, errors.New(strings.TrimRight(log, "\x00"))
+	}
+	return shader, nil
+}
+
+// Run draws the latest GPU frame, called on the GLArea render signal
+func (renderer *GLRendererType) Run(glarea *gtk.GLArea) bool {
+	if renderer.failed {
+		return false
+	}
+	if err := glarea.GetError(); err != nil {
+		renderer.fail(err)
+		return false
+	}
+
+	if renderer.reload {
+		renderer.reload = false
+		renderer.LoadShaders()
+	}
+
+	// Upload the frame
+	frame, width, height := GPU.Frame()
+	if width != renderer.width {
+		renderer.width = width
+		glarea.SetSizeRequest(width, height)
+	}
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, renderer.texture)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(width), int32(height), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(frame))
+
+	gl.ClearColor(0.0, 0.0, 0.0, 1.0)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	gl.UseProgram(renderer.program)
+	gl.BindVertexArray(renderer.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, renderer.vbo)
+
+	// Attributes are looked up on every draw since presets can be reloaded
+	positionLoc := gl.GetAttribLocation(renderer.program, gl.Str("position\x00"))
+	if positionLoc >= 0 {
+		gl.EnableVertexAttribArray(uint32(positionLoc))
+		gl.VertexAttribPointer(uint32(positionLoc), 2, gl.FLOAT, false, 4*4, gl.PtrOffset(0))
+	}
+	texCoordLoc := gl.GetAttribLocation(renderer.program, gl.Str("texCoord\x00"))
+	if texCoordLoc >= 0 {
+		gl.EnableVertexAttribArray(uint32(texCoordLoc))
+		gl.VertexAttribPointer(uint32(texCoordLoc), 2, gl.FLOAT, false, 4*4, gl.PtrOffset(2*4))
+	}
+	gl.Uniform1i(gl.GetUniformLocation(renderer.program, gl.Str("screen\x00")), 0)
+
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+
+	gl.BindVertexArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+	gl.UseProgram(0)
+	return true
+}
+
+// Destroy stops the shader watch and releases the OpenGL objects
+func (renderer *GLRendererType) Destroy() {
+	if renderer.watchStop != nil {
+		close(renderer.watchStop)
+		renderer.watchStop = nil
+	}
+	if renderer.program == 0 {
+		return
+	}
+	renderer.glarea.MakeCurrent()
+	gl.DeleteTextures(1, &renderer.texture)
+	gl.DeleteBuffers(1, &renderer.vbo)
+	gl.DeleteVertexArrays(1, &renderer.vao)
+	gl.DeleteProgram(renderer.program)
+	renderer.program = 0
+}
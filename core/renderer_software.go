@@ -0,0 +1,110 @@
+package core
+
+import (
+	"runtime"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// SoftwareRendererType draws GPU frames into a GTK DrawingArea through a Cairo image surface.
+// It needs no OpenGL, so it works on llvmpipe, older GPUs and virtual machines.
+type SoftwareRendererType struct {
+	drawingArea *gtk.DrawingArea
+	width       int
+}
+
+// NewSoftwareRenderer creates a renderer that scales frames on the CPU
+func NewSoftwareRenderer() (*SoftwareRendererType, error) {
+	drawingArea, err := gtk.DrawingAreaNew()
+	if err != nil {
+		return nil, err
+	}
+	drawingArea.SetSizeRequest(ScreenWidth, ScreenHeight)
+
+	renderer := &SoftwareRendererType{
+		drawingArea: drawingArea,
+		width:       ScreenWidth,
+	}
+	drawingArea.Connect("draw", renderer.Draw)
+	return renderer, nil
+}
+
+// Name returns the Settings.Renderer value selecting this renderer
+func (renderer *SoftwareRendererType) Name() string {
+	return RendererSoftware
+}
+
+// Widget returns the DrawingArea the renderer draws into
+func (renderer *SoftwareRendererType) Widget() gtk.IWidget {
+	return renderer.drawingArea
+}
+
+// QueueFrame asks the DrawingArea to draw the latest GPU frame
+func (renderer *SoftwareRendererType) QueueFrame() {
+	renderer.drawingArea.QueueDraw()
+}
+
+// Destroy has nothing to release, Cairo surfaces are freed with each draw
+func (renderer *SoftwareRendererType) Destroy() {}
+
+// Draw blits the latest GPU frame, scaled by the largest whole factor
+// that fits the widget and centred, on the DrawingArea draw signal
+func (renderer *SoftwareRendererType) Draw(drawingArea *gtk.DrawingArea, context *cairo.Context) bool {
+	frame, frameWidth, frameHeight := GPU.Frame()
+	if frameWidth != renderer.width {
+		renderer.width = frameWidth
+		drawingArea.SetSizeRequest(frameWidth, frameHeight)
+	}
+
+	width := drawingArea.GetAllocatedWidth()
+	height := drawingArea.GetAllocatedHeight()
+	scale := width / frameWidth
+	if height/frameHeight < scale {
+		scale = height / frameHeight
+	}
+	if scale < 1 {
+		scale = 1
+	}
+
+	context.SetSourceRGB(0, 0, 0)
+	context.Paint()
+
+	// Cairo reads the pixels from Go memory, so they must outlive the surface
+	pixels := scaleFrame(frame, frameWidth, frameHeight, scale)
+	surface, err := cairo.CreateImageSurfaceForData(pixels,
+		cairo.FORMAT_RGB24, frameWidth*scale, frameHeight*scale, frameWidth*scale*4)
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "RENDERER:", err)
+		return false
+	}
+	context.SetSourceSurface(surface, float64((width-frameWidth*scale)/2), float64((height-frameHeight*scale)/2))
+	context.Paint()
+	surface.Close()
+	runtime.KeepAlive(pixels)
+	return true
+}
+
+// scaleFrame converts RGBA pixels from GPU.Frame into Cairo's native-endian
+// RGB24 layout, repeating each pixel scale times in both directions
+func scaleFrame(frame []byte, width int, height int, scale int) []byte {
+	stride := width * scale * 4
+	pixels := make([]byte, stride*height*scale)
+	for y := 0; y < height; y++ {
+		row := pixels[y*scale*stride : (y*scale+1)*stride]
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * 4
+			for s := 0; s < scale; s++ {
+				j := (x*scale + s) * 4
+				row[j] = frame[i+2]
+				row[j+1] = frame[i+1]
+				row[j+2] = frame[i]
+				row[j+3] = 0xFF
+			}
+		}
+		for s := 1; s < scale; s++ {
+			copy(pixels[(y*scale+s)*stride:], row)
+		}
+	}
+	return pixels
+}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// Reverse debugging defaults
+const (
+	ReverseIntervalDefault    = 10000
+	ReverseCheckpointsDefault = 256
+)
+
+// reverseCheckpoint is the machine before the instruction numbered count executed
+type reverseCheckpoint struct {
+	count uint64
+	state []byte
+}
+
+// reverseInput is a change of the buttons the machine sees, applied once the
+// instruction numbered count-1 executed
+type reverseInput struct {
+	count   uint64
+	buttons byte
+}
+
+// ReverseType lets the debugger step backwards.
+//
+// Run numbers the instructions it executes and takes a snapshot every Interval
+// instructions. Going back restores the nearest snapshot before the target and
+// re-executes the instructions up to it, which reaches the same machine as
+// execution is deterministic. The buttons pressed in between are the only
+// input, so their changes are recorded and pressed again on the way.
+type ReverseType struct {
+	// Interval is the number of instructions between checkpoints
+	Interval uint64
+	// Checkpoints is the number kept, the oldest is dropped beyond it
+	Checkpoints int
+
+	count       uint64
+	checkpoints []reverseCheckpoint
+	inputs      []reverseInput
+	replaying   bool
+}
+
+// Reverse is the exported object used in the system
+//
+// Reverse is exported so the debugger UI can step back
+var Reverse = ReverseType{
+	Interval:    ReverseIntervalDefault,
+	Checkpoints: ReverseCheckpointsDefault,
+}
+
+// Clear forgets the history, used when the machine changes other than by
+// executing instructions: loading a ROM, a save state or rewinding
+func (reverse *ReverseType) Clear() {
+	reverse.count = 0
+	reverse.checkpoints = nil
+	reverse.inputs = nil
+}
+
+// Count returns the number of instructions executed since the history began
+func (reverse *ReverseType) Count() uint64 {
+	return reverse.count
+}
+
+// Record is called by Run before each instruction executes, taking a
+// checkpoint when Interval instructions have executed since the last one
+func (reverse *ReverseType) Record() {
+	last := len(reverse.checkpoints) - 1
+	if last < 0 || reverse.count-reverse.checkpoints[last].count >= reverse.Interval {
+		reverse.checkpoints = append(reverse.checkpoints, reverseCheckpoint{
+			count: reverse.count,
+			state: compressState(Snapshot()),
+		})
+		if len(reverse.checkpoints) > reverse.Checkpoints {
+			reverse.checkpoints[0] = reverseCheckpoint{}
+			reverse.checkpoints = reverse.checkpoints[1:]
+			// the oldest checkpoint holds the buttons of the inputs before it
+			oldest := reverse.checkpoints[0].count
+			i := 0
+			for i < len(reverse.inputs) && reverse.inputs[i].count <= oldest {
+				i++
+			}
+			reverse.inputs = append(reverse.inputs[:0], reverse.inputs[i:]...)
+		}
+	}
+	reverse.count++
+}
+
+// recordInput is called by Joypad.set when the buttons the machine sees change
+func (reverse *ReverseType) recordInput(buttons byte) {
+	if reverse.replaying || len(reverse.checkpoints) == 0 {
+		return
+	}
+	reverse.inputs = append(reverse.inputs, reverseInput{reverse.count, buttons})
+}
+
+// StepBack returns to the instruction before the current one, false when
+// it is older than the history or a movie is recorded or played, which would
+// no longer match its inputs. Like Restore it runs between instructions.
+func (reverse *ReverseType) StepBack() bool {
+	if reverse.count == 0 || Movie.Active() {
+		return false
+	}
+	return reverse.seek(reverse.count - 1)
+}
+
+// ReverseContinue goes back to the last time execution reached an enabled
+// breakpoint. Without one in the history it stops at the oldest checkpoint
+// and returns false, during a movie it stays put.
+func (reverse *ReverseType) ReverseContinue() bool {
+	if Movie.Active() {
+		return false
+	}
+	end := reverse.count
+	for i := len(reverse.checkpoints) - 1; i >= 0; i-- {
+		checkpoint := reverse.checkpoints[i]
+		if checkpoint.count >= end {
+			continue
+		}
+		found, hit := false, uint64(0)
+		err := reverse.replay(checkpoint, end, func(count uint64) {
+			if enabled, ok := CPU.BREAKPOINTS[CPU.REGISTERS.PC]; ok && enabled {
+				found, hit = true, count
+			}
+		})
+		if err != nil {
+			Logger.Log(LogTypes.ERROR, "REVERSE: Error replaying", err)
+			return false
+		}
+		if found {
+			return reverse.seek(hit)
+		}
+		end = checkpoint.count
+	}
+	if len(reverse.checkpoints) > 0 {
+		reverse.seek(reverse.checkpoints[0].count)
+	}
+	return false
+}
+
+// seek brings the machine to the instruction numbered target, dropping the
+// checkpoints after it as execution will take them again
+func (reverse *ReverseType) seek(target uint64) bool {
+	i := len(reverse.checkpoints) - 1
+	for i >= 0 && reverse.checkpoints[i].count > target {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	if err := reverse.replay(reverse.checkpoints[i], target, nil); err != nil {
+		Logger.Log(LogTypes.ERROR, "REVERSE: Error replaying", err)
+		return false
+	}
+	reverse.checkpoints = reverse.checkpoints[:i+1]
+	// the inputs after target are recorded again as execution takes them
+	inputs := len(reverse.inputs)
+	for inputs > 0 && reverse.inputs[inputs-1].count > target {
+		inputs--
+	}
+	reverse.inputs = reverse.inputs[:inputs]
+	reverse.count = target
+	return true
+}
+
+// replay restores a checkpoint and executes until the instruction numbered end,
+// pressing the recorded buttons and calling visit with the number of each
+// instruction before it executes
+func (reverse *ReverseType) replay(checkpoint reverseCheckpoint, end uint64, visit func(count uint64)) error {
+	state, err := expandState(checkpoint.state)
+	if err != nil {
+		return err
+	}
+	defer detachOutputs()()
+	reverse.replaying = true
+	defer func() { reverse.replaying = false }()
+	if err := Restore(state); err != nil {
+		return err
+	}
+	input := 0
+	for input < len(reverse.inputs) && reverse.inputs[input].count <= checkpoint.count {
+		input++
+	}
+	for count := checkpoint.count; count < end; count++ {
+		if visit != nil {
+			visit(count)
+		}
+		instruction, err := CPU.Fetch()
+		if err != nil {
+			return err
+		}
+		CPU.Execute(instruction)
+		for ; input < len(reverse.inputs) && reverse.inputs[input].count == count+1; input++ {
+			Joypad.set(reverse.inputs[input].buttons)
+		}
+	}
+	return nil
+}
+
+// detachOutputs disconnects the audio sink, the link cable peer and the frame
+// callback, so replayed instructions are not heard, sent or drawn a second
+// time. The returned function connects them again, dropping the replayed
+// samples and drawing the frame reached.
+func detachOutputs() func() {
+	sink, peer, onFrame := APU.Sink, Serial.Peer, GPU.OnFrame
+	APU.Sink, Serial.Peer, GPU.OnFrame = nil, nil, nil
+	return func() {
+		APU.samplesLock.Lock()
+		APU.samples = APU.samples[:0]
+		APU.samplesLock.Unlock()
+		APU.Sink, Serial.Peer, GPU.OnFrame = sink, peer, onFrame
+		if onFrame != nil {
+			onFrame()
+		}
+	}
+}
+
+func compressState(state []byte) []byte {
+	var buffer bytes.Buffer
+	writer, _ := flate.NewWriter(&buffer, flate.BestSpeed)
+	writer.Write(state)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func expandState(data []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,147 @@
+package core
+
+import "testing"
+
+// runRecorded executes instructions the way Run does, pressing the buttons
+// in presses after the instruction of the same number, and returns the
+// machine before each of them
+func runRecorded(t *testing.T, instructions int, presses map[int]byte) [][]byte {
+	var states [][]byte
+	for i := 0; i < instructions; i++ {
+		states = append(states, machineChunks(t, Snapshot()))
+		Reverse.Record()
+		instruction, err := CPU.Fetch()
+		if err != nil {
+			t.Fatalf("Reverse: %v", err)
+		}
+		CPU.Execute(instruction)
+		if buttons, ok := presses[i]; ok {
+			Joypad.set(buttons)
+		}
+	}
+	return append(states, machineChunks(t, Snapshot()))
+}
+
+// TestReverseStepBack steps back across checkpoints, expecting the machine
+// before each instruction in turn
+func TestReverseStepBack(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	Reverse.Interval = 7
+	defer func() { Reverse.Interval = ReverseIntervalDefault }()
+
+	states := runRecorded(t, 30, nil)
+	for i := len(states) - 2; i >= 0; i-- {
+		if !Reverse.StepBack() {
+			t.Fatalf("Reverse: could not step back to instruction %d", i)
+		}
+		if Reverse.Count() != uint64(i) {
+			t.Fatalf("Reverse: at instruction %d, expected %d", Reverse.Count(), i)
+		}
+		if string(machineChunks(t, Snapshot())) != string(states[i]) {
+			t.Errorf("Reverse: machine differs at instruction %d", i)
+		}
+	}
+	if Reverse.StepBack() {
+		t.Errorf("Reverse: stepped back before the first instruction")
+	}
+}
+
+// TestReverseStepBackInput steps back over button changes between
+// checkpoints, expecting them pressed again on the way
+func TestReverseStepBackInput(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	Reverse.Interval = 7
+	defer func() { Reverse.Interval = ReverseIntervalDefault }()
+	defer Joypad.set(0)
+
+	INTERRUPTS.flags = 0x00
+	states := runRecorded(t, 30, map[int]byte{
+		3:  ButtonA,
+		9:  ButtonA | ButtonUp,
+		13: ButtonUp,
+		20: 0,
+		27: ButtonStart,
+	})
+	for i := len(states) - 2; i >= 0; i-- {
+		if !Reverse.StepBack() {
+			t.Fatalf("Reverse: could not step back to instruction %d", i)
+		}
+		if string(machineChunks(t, Snapshot())) != string(states[i]) {
+			t.Errorf("Reverse: machine differs at instruction %d, buttons 0x%02X", i, Joypad.buttons)
+		}
+	}
+}
+
+// TestReverseContinue goes back to the last instruction at a breakpoint
+func TestReverseContinue(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	Reverse.Interval = 7
+	defer func() { Reverse.Interval = ReverseIntervalDefault }()
+
+	states := runRecorded(t, 30, nil)
+	last := -1
+	for i := 0; i < len(states)-1; i++ {
+		// the program loops through LD (HL+), A at 0x0153, PC follows
+		// AF, BC, DE, HL and SP at the start of the CPU chunk
+		if states[i][10] == 0x53 && states[i][11] == 0x01 {
+			last = i
+		}
+	}
+	if last < 0 {
+		t.Fatalf("Reverse: the program never reached 0x0153")
+	}
+
+	CPU.BREAKPOINTS[0x0153] = true
+	defer delete(CPU.BREAKPOINTS, 0x0153)
+	if !Reverse.ReverseContinue() {
+		t.Fatalf("Reverse: no breakpoint found")
+	}
+	if Reverse.Count() != uint64(last) || CPU.REGISTERS.PC != 0x0153 {
+		t.Errorf("Reverse: stopped at instruction %d PC 0x%04X, expected %d", Reverse.Count(), CPU.REGISTERS.PC, last)
+	}
+	if string(machineChunks(t, Snapshot())) != string(states[last]) {
+		t.Errorf("Reverse: machine differs at the breakpoint")
+	}
+}
+
+// countingSink counts the chunks of samples it receives
+type countingSink struct{ chunks int }
+
+func (sink *countingSink) WriteSamples(samples []int16) { sink.chunks++ }
+
+// countingPeer counts the bytes sent to it
+type countingPeer struct{ bytes int }
+
+func (peer *countingPeer) Transfer(out byte) byte { peer.bytes++; return 0xFF }
+
+func (peer *countingPeer) Receive(out byte) (byte, bool) { return 0xFF, false }
+
+// TestReverseReplayDetached replays frames worth of instructions without
+// playing them, sending them over the link cable or drawing them
+func TestReverseReplayDetached(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	Reverse.Interval = 100000
+	defer func() { Reverse.Interval = ReverseIntervalDefault }()
+	for i := 0; i < 40000; i++ {
+		Reverse.Record()
+		instruction, err := CPU.Fetch()
+		if err != nil {
+			t.Fatalf("Reverse: %v", err)
+		}
+		CPU.Execute(instruction)
+	}
+
+	sink, peer, frames := &countingSink{}, &countingPeer{}, 0
+	APU.Sink, Serial.Peer, GPU.OnFrame = sink, peer, func() { frames++ }
+	defer func() { APU.Sink, Serial.Peer, GPU.OnFrame = nil, &SerialPrinter, nil }()
+	if !Reverse.StepBack() {
+		t.Fatalf("Reverse: could not step back")
+	}
+	if sink.chunks != 0 || peer.bytes != 0 || frames != 1 {
+		t.Errorf("Reverse: replay wrote %d chunks, sent %d bytes and drew %d frames, expected only the frame reached",
+			sink.chunks, peer.bytes, frames)
+	}
+	if APU.Sink != sink || Serial.Peer != peer || GPU.OnFrame == nil {
+		t.Errorf("Reverse: outputs not connected again after replaying")
+	}
+}
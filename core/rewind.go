@@ -0,0 +1,200 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Rewind defaults, also used when the settings hold invalid values
+const (
+	RewindIntervalDefault = 5
+	RewindBudgetDefault   = 32
+)
+
+// RewindType keeps the recent history of the machine so it can be stepped back in time.
+//
+// A snapshot is taken every Interval frames. The newest is kept whole, each
+// older one as the XOR against the snapshot after it, compressed. Consecutive
+// snapshots differ in few bytes, so the deltas are small, and the oldest can
+// be dropped without decoding anything when the buffer is over its budget.
+type RewindType struct {
+	// Interval is the number of frames between snapshots
+	Interval int
+	// Budget is the memory the buffer may use, in bytes, 0 disables rewinding
+	Budget int
+	// Rewinding is set while the rewind hotkey is held, the CPU then steps
+	// back a snapshot at a time instead of running
+	Rewinding bool
+
+	lock      sync.Mutex
+	latest    []byte
+	deltas    [][]byte
+	size      int
+	lastFrame uint64
+}
+
+// Rewind is the exported object used in the system
+//
+// Rewind is exported so the UI can hold the hotkey and change the budget
+var Rewind = RewindType{
+	Interval: RewindIntervalDefault,
+	Budget:   RewindBudgetDefault << 20,
+}
+
+// Configure applies the interval in frames and the budget in megabytes from the settings
+func (rewind *RewindType) Configure(interval int, budget int) {
+	if interval <= 0 {
+		interval = RewindIntervalDefault
+	}
+	if budget < 0 {
+		budget = RewindBudgetDefault
+	}
+	rewind.lock.Lock()
+	defer rewind.lock.Unlock()
+	rewind.Interval = interval
+	rewind.Budget = budget << 20
+	rewind.trim()
+}
+
+// Clear empties the buffer, used when another ROM is loaded
+func (rewind *RewindType) Clear() {
+	rewind.lock.Lock()
+	defer rewind.lock.Unlock()
+	rewind.latest = nil
+	rewind.deltas = nil
+	rewind.size = 0
+	rewind.lastFrame = GPU.frames
+}
+
+// Len returns the number of snapshots held
+func (rewind *RewindType) Len() int {
+	rewind.lock.Lock()
+	defer rewind.lock.Unlock()
+	if rewind.latest == nil {
+		return 0
+	}
+	return len(rewind.deltas) + 1
+}
+
+// Size returns the memory used by the snapshots, in bytes
+func (rewind *RewindType) Size() int {
+	rewind.lock.Lock()
+	defer rewind.lock.Unlock()
+	return rewind.size + len(rewind.latest)
+}
+
+// Update takes a snapshot when Interval frames have completed since the last one.
+// It is called by the CPU between instructions.
+func (rewind *RewindType) Update() {
+	if GPU.frames-rewind.lastFrame < uint64(rewind.Interval) || rewind.Budget <= 0 {
+		return
+	}
+	rewind.Capture()
+}
+
+// Capture adds a snapshot of the machine to the buffer, between instructions
+func (rewind *RewindType) Capture() {
+	state := Snapshot()
+	rewind.lock.Lock()
+	defer rewind.lock.Unlock()
+	rewind.lastFrame = GPU.frames
+	if rewind.latest != nil {
+		delta := compressDelta(state, rewind.latest)
+		rewind.deltas = append(rewind.deltas, delta)
+		rewind.size += len(delta)
+	}
+	rewind.latest = state
+	rewind.trim()
+}
+
+// trim drops the oldest snapshots until the buffer fits its budget
+func (rewind *RewindType) trim() {
+	for len(rewind.deltas) > 0 && rewind.size+len(rewind.latest) > rewind.Budget {
+		rewind.size -= len(rewind.deltas[0])
+		rewind.deltas[0] = nil
+		rewind.deltas = rewind.deltas[1:]
+	}
+	if rewind.Budget <= 0 {
+		rewind.latest = nil
+	}
+}
+
+// Step restores the newest snapshot and makes the one before it the newest,
+// returning false once the oldest has been restored. Like Restore it runs
+// between instructions.
+func (rewind *RewindType) Step() bool {
+	rewind.lock.Lock()
+	defer rewind.lock.Unlock()
+	if rewind.latest == nil {
+		return false
+	}
+	if err := Restore(rewind.latest); err != nil {
+		Logger.Log(LogTypes.ERROR, "REWIND: Error restoring", err)
+		rewind.latest = nil
+		rewind.deltas = nil
+		rewind.size = 0
+		return false
+	}
+	rewind.lastFrame = GPU.frames
+	if len(rewind.deltas) == 0 {
+		// keep the oldest, running on captures from it again
+		return false
+	}
+	last := len(rewind.deltas) - 1
+	older, err := expandDelta(rewind.latest, rewind.deltas[last])
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "REWIND: Error expanding", err)
+		return false
+	}
+	rewind.size -= len(rewind.deltas[last])
+	rewind.deltas[last] = nil
+	rewind.deltas = rewind.deltas[:last]
+	rewind.latest = older
+	return true
+}
+
+// StepDelay is how long each snapshot is shown while rewinding, twice as fast as playing
+func (rewind *RewindType) StepDelay() time.Duration {
+	return time.Duration(rewind.Interval) * time.Second / 120
+}
+
+// compressDelta encodes older as its length and its XOR against newer, deflated
+func compressDelta(newer []byte, older []byte) []byte {
+	xor := make([]byte, 4+len(older))
+	binary.LittleEndian.PutUint32(xor, uint32(len(older)))
+	for i, value := range older {
+		if i < len(newer) {
+			value ^= newer[i]
+		}
+		xor[4+i] = value
+	}
+	var buffer bytes.Buffer
+	writer, _ := flate.NewWriter(&buffer, flate.BestSpeed)
+	writer.Write(xor)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+// expandDelta decodes the snapshot before newer from a delta made by compressDelta
+func expandDelta(newer []byte, delta []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(delta))
+	defer reader.Close()
+	xor, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(xor) < 4 || int(binary.LittleEndian.Uint32(xor)) != len(xor)-4 {
+		return nil, ErrNotSaveState
+	}
+	older := xor[4:]
+	for i := range older {
+		if i < len(newer) {
+			older[i] ^= newer[i]
+		}
+	}
+	return older, nil
+}
@@ -0,0 +1,80 @@
+package core
+
+import "testing"
+
+// TestRewind captures a snapshot every frame, then steps back through them,
+// expecting each machine state in reverse order
+func TestRewind(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	Rewind.Configure(1, RewindBudgetDefault)
+	Rewind.Clear()
+	defer Rewind.Configure(RewindIntervalDefault, RewindBudgetDefault)
+
+	var states [][]byte
+	for i := 0; i < 4; i++ {
+		runFrameHashes(t, 1)
+		states = append(states, machineChunks(t, Snapshot()))
+		Rewind.Capture()
+	}
+	if Rewind.Len() != len(states) {
+		t.Fatalf("Rewind: holding %d snapshots, expected %d", Rewind.Len(), len(states))
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		more := Rewind.Step()
+		if string(machineChunks(t, Snapshot())) != string(states[i]) {
+			t.Errorf("Rewind: snapshot %d differs after stepping back", i)
+		}
+		if more != (i > 0) {
+			t.Errorf("Rewind: Step returned %v at snapshot %d", more, i)
+		}
+	}
+}
+
+// TestRewindBudget drops the oldest snapshots to stay within the budget
+func TestRewindBudget(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	Rewind.Configure(1, RewindBudgetDefault)
+	Rewind.Clear()
+	defer Rewind.Configure(RewindIntervalDefault, RewindBudgetDefault)
+
+	for i := 0; i < 4; i++ {
+		runFrameHashes(t, 1)
+		Rewind.Capture()
+	}
+	budget := Rewind.Size() - 1
+	Rewind.Budget = budget
+	runFrameHashes(t, 1)
+	Rewind.Capture()
+	if Rewind.Size() > budget {
+		t.Errorf("Rewind: using %d bytes, over the budget of %d", Rewind.Size(), budget)
+	}
+	if Rewind.Len() >= 5 {
+		t.Errorf("Rewind: holding %d snapshots, expected the oldest dropped", Rewind.Len())
+	}
+}
+
+// TestRewindSizeChange steps back across snapshots of different lengths, as
+// when a partial SGB command is received between them
+func TestRewindSizeChange(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	Rewind.Configure(1, RewindBudgetDefault)
+	Rewind.Clear()
+	defer Rewind.Configure(RewindIntervalDefault, RewindBudgetDefault)
+	defer func() { SGB.command = nil }()
+
+	var states [][]byte
+	for _, command := range [][]byte{nil, make([]byte, 32), make([]byte, 16), nil} {
+		runFrameHashes(t, 1)
+		SGB.command = command
+		states = append(states, machineChunks(t, Snapshot()))
+		Rewind.Capture()
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		Rewind.Step()
+		if string(machineChunks(t, Snapshot())) != string(states[i]) {
+			t.Errorf("Rewind: snapshot %d differs after stepping back", i)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package core
 
 import (
+	"errors"
 	"fmt"
-	"math"
 )
 
 // ROM_OFFSET_NAME is the location in every ROM of the name
 const ROM_OFFSET_NAME = 0x0134
+const ROM_OFFSET_CGB = 0x0143
+const ROM_OFFSET_SGB = 0x0146
+const ROM_OFFSET_LICENSEE = 0x014B
 const ROM_OFFSET_TYPE = 0x0147
 const ROM_OFFSET_ROM_SIZE = 0x0148
 const ROM_OFFSET_RAM_SIZE = 0x0149
@@ -40,6 +43,38 @@
 	0xFF: "ROM_HUDSON_HUC1",
 }
 
+// Errors returned when a ROM image cannot be loaded, wrapped in a ROMError
+var (
+	ErrROMTooSmall       = ErrHeaderTooSmall
+	ErrHeaderChecksum    = errors.New("header checksum does not match, the header is corrupt")
+	ErrUnsupportedMapper = errors.New("unsupported cartridge type")
+	ErrROMSizeMismatch   = errors.New("ROM size does not match the header")
+)
+
+// ROMError describes why a ROM could not be loaded.
+// Err is one of the errors above, or the error reading the file.
+type ROMError struct {
+	Location string
+	Err      error
+	Detail   string
+}
+
+func (e *ROMError) Error() string {
+	message := e.Err.Error()
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	if e.Location != "" {
+		message = e.Location + ": " + message
+	}
+	return message
+}
+
+// Unwrap lets errors.Is match the reason
+func (e *ROMError) Unwrap() error {
+	return e.Err
+}
+
 type ROMType struct {
 	data         []byte
 	model        []interface{}
@@ -48,43 +83,93 @@ type ROMType struct {
 	romName      string
 	romSize      int
 	romRAMSize   int
+	header       CartridgeHeader
 }
 
 // GetType gets the ROM Type of the ROM from within the ROM's file
 func (rom *ROMType) GetType() string {
-	return romTypeMap[rom.data[ROM_OFFSET_TYPE]]
+	return rom.header.TypeName()
 }
 
-// GetROMSize get the size of the ROM from within the ROM's file
+// GetROMSize get the size of the ROM in KB from within the ROM's file
 func (rom *ROMType) GetROMSize() int {
-	var romSize int = int(rom.data[ROM_OFFSET_ROM_SIZE])
-	if (romSize & 0xF0) == 0x50 {
-		romSize = int(math.Pow(2.0, float64((((0x52)&0xF)+1))+64))
-	} else {
-		romSize = int(math.Pow(2.0, float64((romSize + 1))))
-	}
-	return romSize
+	return rom.header.ROMSize()
 }
 
-// GetRAMSize get the size of the ROM's RAM from within the ROM's file
+// GetRAMSize get the size of the ROM's RAM in KB from within the ROM's file
 func (rom *ROMType) GetRAMSize() int {
-	return int(math.Pow(4.0, float64(rom.data[ROM_OFFSET_RAM_SIZE])))
+	return rom.header.RAMSize()
 }
 
 // GetName gets the name of the ROM from within the ROM's file
 func (rom *ROMType) GetName() string {
-	var name [256]byte
-	var returnString string
-	// var charRead int = 0
-	for i := 0; i < 256; i++ {
-		if string(rom.data[ROM_OFFSET_NAME+i]) != "\000" {
-			name[i] = rom.data[ROM_OFFSET_NAME+i]
-		} else {
-			returnString = string(name[:i])
-			break
-		}
+	return rom.header.Title
+}
+
+// Header returns the cartridge header parsed when the ROM was loaded
+func (rom *ROMType) Header() CartridgeHeader {
+	return rom.header
+}
+
+// IsCGB reports whether the ROM's header flags it as supporting the Game Boy Color
+func (rom *ROMType) IsCGB() bool {
+	return rom.header.CGBFlag&0x80 != 0
+}
+
+// IsSGB reports whether the ROM's header flags it as using Super Game Boy functions
+func (rom *ROMType) IsSGB() bool {
+	return rom.header.SupportsSGB()
+}
+
+// GetKey identifies the ROM by its title and global checksum,
+// used to store per-ROM settings
+func (rom *ROMType) GetKey() string {
+	if len(rom.data) < 0x0150 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%02X%02X", rom.romName, rom.data[0x014E], rom.data[0x014F])
+}
+
+// Load verifies a ROM image and makes it the loaded ROM.
+// The image is checked before anything is replaced, so a ROM that fails
+// to load leaves the previous one in place.
+func (rom *ROMType) Load(data []byte) error {
+	header, err := verifyROM(data)
+	if err != nil {
+		return err
+	}
+
+	rom.data = data
+	rom.header = header
+	rom.romName = rom.GetName()
+	rom.romType = rom.GetType()
+	rom.romSize = rom.GetROMSize()
+	rom.romRAMSize = rom.GetRAMSize()
+	rom.BuildModel()
+	return nil
+}
+
+// verifyROM parses the header of a ROM image and checks it can be loaded
+func verifyROM(data []byte) (CartridgeHeader, error) {
+	header, err := ParseCartridgeHeader(data)
+	if err != nil {
+		return header, &ROMError{Err: ErrROMTooSmall, Detail: fmt.Sprintf("%d bytes, the header ends at 0x%04X", len(data), ROM_HEADER_SIZE)}
+	}
+	if !header.HeaderChecksumValid {
+		return header, &ROMError{Err: ErrHeaderChecksum, Detail: fmt.Sprintf("stored 0x%02X, computed 0x%02X",
+			header.HeaderChecksum, ComputeHeaderChecksum(data))}
+	}
+	if _, ok := romTypeMap[header.CartridgeType]; !ok {
+		return header, &ROMError{Err: ErrUnsupportedMapper, Detail: fmt.Sprintf("0x%02X", header.CartridgeType)}
+	}
+	if header.ROMSize() == 0 {
+		return header, &ROMError{Err: ErrROMSizeMismatch, Detail: fmt.Sprintf("unknown size code 0x%02X", header.ROMSizeCode)}
+	}
+	if header.ROMSize()*1024 != len(data) {
+		return header, &ROMError{Err: ErrROMSizeMismatch, Detail: fmt.Sprintf("header declares %d bytes, the file is %d bytes",
+			header.ROMSize()*1024, len(data))}
 	}
-	return returnString
+	return header, nil
 }
 
 // BuildModel builds a GTK TreeModel using an instruction map
@@ -92,22 +177,36 @@ func (rom *ROMType) BuildModel() {
 	model := []interface{}{}
 
 	for i := 0x0000; i < len(rom.data); i++ {
+		// labels from the symbol file get a row of their own, as in the source
+		bank, address := 0, uint16(i)
+		if i >= 0x4000 {
+			bank, address = i/0x4000, uint16(0x4000+i%0x4000)
+		}
+		for _, symbol := range Symbols.At(bank, address) {
+			model = append(model, []string{symbol.Name + ":", ""})
+		}
+
 		row := []string{}
-		if CPU.INSTRUCTIONS[rom.data[i]].NumOperands == 1 {
-			i += int(CPU.INSTRUCTIONS[rom.data[i]].NumOperands)
+		instruction := CPU.INSTRUCTIONS[rom.data[i]]
+		if instruction.NumOperands > 0 && i+int(instruction.NumOperands) >= len(rom.data) {
+			// the operands run past the end of the ROM
+			row = append(row, fmt.Sprintf("0x%04X", i))
+			row = append(row, fmt.Sprintf("UNKNOWN: 0x%02X", rom.data[i]))
+		} else if instruction.NumOperands == 1 {
 			row = append(row, fmt.Sprintf("0x%04X", i))
-			row = append(row, fmt.Sprintf("%s, 0x%02X", CPU.INSTRUCTIONS[rom.data[i]].Name, rom.data[i+1]))
-		} else if CPU.INSTRUCTIONS[rom.data[i]].NumOperands == 2 {
-			i += int(CPU.INSTRUCTIONS[rom.data[i]].NumOperands)
+			row = append(row, fmt.Sprintf("%s, 0x%02X", instruction.Name, rom.data[i+1]))
+			i++
+		} else if instruction.NumOperands == 2 {
 			row = append(row, fmt.Sprintf("0x%04X", i))
-			row = append(row, fmt.Sprintf("%s 0x%02X 0x%02X", CPU.INSTRUCTIONS[rom.data[i]].Name, rom.data[i+1], rom.data[i+2]))
+			row = append(row, fmt.Sprintf("%s 0x%02X 0x%02X", instruction.Name, rom.data[i+1], rom.data[i+2]))
+			i += 2
 		} else {
-			if CPU.INSTRUCTIONS[rom.data[i]].Name == "UNKNOWN" {
+			if instruction.Name == "UNKNOWN" {
 				row = append(row, fmt.Sprintf("0x%04X", i))
-				row = append(row, fmt.Sprintf("%s: 0x%02X", CPU.INSTRUCTIONS[rom.data[i]].Name, rom.data[i]))
+				row = append(row, fmt.Sprintf("%s: 0x%02X", instruction.Name, rom.data[i]))
 			} else {
 				row = append(row, fmt.Sprintf("0x%04X", i))
-				row = append(row, fmt.Sprintf("%s", CPU.INSTRUCTIONS[rom.data[i]].Name))
+				row = append(row, fmt.Sprintf("%s", instruction.Name))
 			}
 		}
 		model = append(model, row)
@@ -0,0 +1,25 @@
+//go:build go1.18
+// +build go1.18
+
+package core
+
+import "testing"
+
+// FuzzROMLoad checks that no byte slice panics the ROM header checks or the
+// disassembler, run with
+//
+//	go test -run '^$' -fuzz FuzzROMLoad ./core
+func FuzzROMLoad(f *testing.F) {
+	// the seeds stay small, as the fuzzer minimizes every new input it keeps
+	// and testROM repairs the header of each input anyway
+	f.Add([]byte{})
+	f.Add(make([]byte, ROM_HEADER_SIZE))
+	f.Add([]byte{0xCB, 0x00, 0x01, 0xFA})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 0x8000 {
+			t.Skip()
+		}
+		checkROM(t, data)
+		checkROM(t, testROM(data))
+	})
+}
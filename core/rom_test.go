@@ -0,0 +1,127 @@
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// testROM builds a 32KB ROM image with a valid header around data
+func testROM(data []byte) []byte {
+	rom := make([]byte, 0x8000)
+	copy(rom, data)
+	copy(rom[ROM_OFFSET_LOGO:], nintendoLogo)
+	rom[ROM_OFFSET_ROM_SIZE] = 0x00
+	if _, ok := romTypeMap[rom[ROM_OFFSET_TYPE]]; !ok {
+		rom[ROM_OFFSET_TYPE] = 0x00
+	}
+	rom[ROM_OFFSET_HEADER_CHECKSUM] = ComputeHeaderChecksum(rom)
+	return rom
+}
+
+// loadROM loads data, failing the test if the loader panics
+func loadROM(t *testing.T, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("ROM: loading %d bytes panicked: %v", len(data), r)
+		}
+	}()
+	var rom ROMType
+	return rom.Load(data)
+}
+
+// romModelLimit caps the bytes disassembled by checkROM, the header checks
+// see the whole image
+const romModelLimit = 0x400
+
+// checkROM verifies data as the loader does and disassembles its first
+// romModelLimit bytes, failing the test if either panics. Building the model
+// of a full image is what makes Load slow.
+func checkROM(t *testing.T, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("ROM: checking %d bytes panicked: %v", len(data), r)
+		}
+	}()
+	verifyROM(data)
+	if len(data) > romModelLimit {
+		data = data[:romModelLimit]
+	}
+	rom := ROMType{data: data}
+	rom.BuildModel()
+}
+
+func TestROMLoadErrors(t *testing.T) {
+	valid := testROM(nil)
+
+	badChecksum := testROM(nil)
+	badChecksum[ROM_OFFSET_HEADER_CHECKSUM]++
+
+	badType := testROM(nil)
+	badType[ROM_OFFSET_TYPE] = 0x04
+	badType[ROM_OFFSET_HEADER_CHECKSUM] = ComputeHeaderChecksum(badType)
+
+	badSize := testROM(nil)
+	badSize[ROM_OFFSET_ROM_SIZE] = 0x01
+	badSize[ROM_OFFSET_HEADER_CHECKSUM] = ComputeHeaderChecksum(badSize)
+
+	mapper := testROM(nil)
+	mapper[ROM_OFFSET_TYPE] = 0x01
+	mapper[ROM_OFFSET_HEADER_CHECKSUM] = ComputeHeaderChecksum(mapper)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"valid", valid, nil},
+		{"empty", nil, ErrROMTooSmall},
+		{"truncated header", valid[:ROM_HEADER_SIZE-1], ErrROMTooSmall},
+		{"header checksum", badChecksum, ErrHeaderChecksum},
+		{"cartridge type", badType, ErrUnsupportedMapper},
+		{"declared size", badSize, ErrROMSizeMismatch},
+		{"truncated ROM", valid[:0x4000], ErrROMSizeMismatch},
+		{"memory bank controller", mapper, nil},
+	}
+	for _, test := range tests {
+		err := loadROM(t, test.data)
+		if test.err == nil && err != nil {
+			t.Errorf("ROM: %s: unexpected error %v", test.name, err)
+		} else if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("ROM: %s: got %v, expected %v", test.name, err, test.err)
+		}
+		if _, ok := err.(*ROMError); err != nil && !ok {
+			t.Errorf("ROM: %s: %T is not a *ROMError", test.name, err)
+		}
+
+		// the compatibility check calls fatal exactly what Load refuses
+		header, headerErr := ParseCartridgeHeader(test.data)
+		if headerErr != nil {
+			continue
+		}
+		fatal := false
+		for _, issue := range header.CompatibilityCheck(len(test.data)) {
+			fatal = fatal || issue.Fatal
+		}
+		if fatal != (err != nil) {
+			t.Errorf("ROM: %s: compatibility check fatal %v, Load returned %v", test.name, fatal, err)
+		}
+	}
+}
+
+// TestROMLoadRandom feeds the loader random images, both raw and with a
+// repaired header so they pass the checks, fully loading only a few
+func TestROMLoadRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		data := make([]byte, random.Intn(0x8000+1))
+		random.Read(data)
+		checkROM(t, data)
+		checkROM(t, testROM(data))
+		if i%50 == 0 {
+			if err := loadROM(t, testROM(data)); err != nil {
+				t.Errorf("ROM: repaired random image failed to load: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,707 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// SaveStateVersion is written into every save state.
+//
+// Save states are a magic string and version followed by chunks, each a four
+// character tag, a little endian length and the data. Readers skip chunks they
+// do not know and treat fields missing from the end of a chunk as zero, so new
+// hardware state is added as new fields at the end of a chunk or as new chunks,
+// keeping older and newer builds able to load each other's states.
+const SaveStateVersion = 1
+
+// SaveStateSlots is the number of save state slots per ROM
+const SaveStateSlots = 9
+
+// saveStateMagic starts every save state
+const saveStateMagic = "FMGBSAVE"
+
+// StateDir holds the save states, in a directory per ROM
+var StateDir = path.Join(UserHome, ".freemegb", "states")
+
+// Errors returned when a save state cannot be loaded
+var (
+	ErrNotSaveState   = errors.New("not a FreeMe!GB save state")
+	ErrSaveStateROM   = errors.New("save state was made with a different ROM")
+	ErrSaveStateSlot  = errors.New("no such save state slot")
+	ErrSaveStateNoROM = errors.New("no ROM is loaded")
+	ErrSaveStateMovie = errors.New("a movie is being recorded or played")
+)
+
+// SaveStateInfo describes a save state without loading it
+type SaveStateInfo struct {
+	Version uint16
+	ROMKey  string
+	Saved   time.Time
+	// Thumbnail is the screen when the state was saved, as a PNG
+	Thumbnail []byte
+}
+
+// stateWriter encodes fixed size values in little endian order
+type stateWriter struct {
+	buffer bytes.Buffer
+}
+
+func (w *stateWriter) byte(value byte) {
+	w.buffer.WriteByte(value)
+}
+
+func (w *stateWriter) bool(value bool) {
+	if value {
+		w.byte(1)
+	} else {
+		w.byte(0)
+	}
+}
+
+func (w *stateWriter) uint16(value uint16) {
+	var data [2]byte
+	binary.LittleEndian.PutUint16(data[:], value)
+	w.buffer.Write(data[:])
+}
+
+func (w *stateWriter) uint32(value uint32) {
+	var data [4]byte
+	binary.LittleEndian.PutUint32(data[:], value)
+	w.buffer.Write(data[:])
+}
+
+func (w *stateWriter) uint64(value uint64) {
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], value)
+	w.buffer.Write(data[:])
+}
+
+func (w *stateWriter) int(value int) {
+	w.uint64(uint64(int64(value)))
+}
+
+// bytes writes a fixed length array
+func (w *stateWriter) bytes(data []byte) {
+	w.buffer.Write(data)
+}
+
+// slice writes a variable length slice, prefixed by its length
+func (w *stateWriter) slice(data []byte) {
+	w.uint32(uint32(len(data)))
+	w.buffer.Write(data)
+}
+
+// chunk writes a tagged chunk holding what save writes
+func (w *stateWriter) chunk(tag string, save func(chunk *stateWriter)) {
+	var chunk stateWriter
+	save(&chunk)
+	w.bytes([]byte(tag[:4]))
+	w.uint32(uint32(chunk.buffer.Len()))
+	w.bytes(chunk.buffer.Bytes())
+}
+
+// stateReader decodes what stateWriter encoded, reading zeros past the end of the data
+type stateReader struct {
+	data     []byte
+	position int
+	version  uint16
+}
+
+func (r *stateReader) next(size int) []byte {
+	if r.position+size > len(r.data) {
+		r.position = len(r.data)
+		return make([]byte, size)
+	}
+	data := r.data[r.position : r.position+size]
+	r.position += size
+	return data
+}
+
+func (r *stateReader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *stateReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *stateReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.next(2))
+}
+
+func (r *stateReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.next(4))
+}
+
+func (r *stateReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.next(8))
+}
+
+func (r *stateReader) int() int {
+	return int(int64(r.uint64()))
+}
+
+func (r *stateReader) bytes(data []byte) {
+	copy(data, r.next(len(data)))
+}
+
+func (r *stateReader) slice() []byte {
+	size := int(r.uint32())
+	if size > len(r.data)-r.position {
+		size = len(r.data) - r.position
+	}
+	return append([]byte{}, r.next(size)...)
+}
+
+// readState checks the magic string and splits a state into its chunks
+func readState(data []byte) (map[string]*stateReader, uint16, error) {
+	return readChunks(data, saveStateMagic, ErrNotSaveState)
+}
+
+// readChunks splits a file in the save state layout, starting with magic, into its chunks.
+// A file with another magic string or truncated chunks returns invalid.
+func readChunks(data []byte, magic string, invalid error) (map[string]*stateReader, uint16, error) {
+	if len(data) < len(magic)+2 || string(data[:len(magic)]) != magic {
+		return nil, 0, invalid
+	}
+	r := &stateReader{data: data, position: len(magic)}
+	version := r.uint16()
+	chunks := map[string]*stateReader{}
+	for r.position+8 <= len(data) {
+		tag := string(r.next(4))
+		size := int(r.uint32())
+		if size > len(data)-r.position {
+			return nil, 0, fmt.Errorf("%w: chunk %q is truncated", invalid, tag)
+		}
+		chunks[tag] = &stateReader{data: r.next(size), version: version}
+	}
+	return chunks, version, nil
+}
+
+// Snapshot captures the whole machine: the CPU, memory, GPU, CGB and SGB
+// hardware, APU and serial port. It must be called between instructions,
+// from the CPU thread or through CPU.Call.
+func Snapshot() []byte {
+	var w stateWriter
+	w.bytes([]byte(saveStateMagic))
+	w.uint16(SaveStateVersion)
+	w.chunk("INFO", func(chunk *stateWriter) {
+		chunk.slice([]byte(ROM.GetKey()))
+		chunk.uint64(uint64(time.Now().Unix()))
+	})
+	snapshotMachine(&w)
+	return w.buffer.Bytes()
+}
+
+func snapshotMachine(w *stateWriter) {
+	w.chunk("CPU ", CPU.saveState)
+	w.chunk("MEM ", MMU.saveState)
+	w.chunk("GPU ", GPU.saveState)
+	w.chunk("CGB ", CGB.saveState)
+	w.chunk("SGB ", SGB.saveState)
+	w.chunk("APU ", APU.saveState)
+	w.chunk("SER ", Serial.saveState)
+	w.chunk("JOYP", Joypad.saveState)
+}
+
+// Restore loads a machine captured by Snapshot or SaveState, which must
+// have been made with the loaded ROM. Like Snapshot it runs between instructions.
+func Restore(data []byte) error {
+	chunks, _, err := readState(data)
+	if err != nil {
+		return err
+	}
+	if info, ok := chunks["INFO"]; ok {
+		if key := string(info.slice()); key != ROM.GetKey() {
+			return fmt.Errorf("%w: %s", ErrSaveStateROM, key)
+		}
+	}
+	restore := func(tag string, load func(r *stateReader)) {
+		if chunk, ok := chunks[tag]; ok {
+			load(chunk)
+		}
+	}
+	restore("CPU ", CPU.loadState)
+	restore("MEM ", MMU.loadState)
+	restore("GPU ", GPU.loadState)
+	restore("CGB ", CGB.loadState)
+	restore("SGB ", SGB.loadState)
+	restore("APU ", APU.loadState)
+	restore("SER ", Serial.loadState)
+	restore("JOYP", Joypad.loadState)
+	return nil
+}
+
+// SaveState captures the machine as Snapshot does, with a thumbnail of the screen
+func SaveState() ([]byte, error) {
+	var thumbnail bytes.Buffer
+	pixels, width, height := GPU.Frame()
+	err := png.Encode(&thumbnail, &image.RGBA{Pix: pixels, Stride: width * 4, Rect: image.Rect(0, 0, width, height)})
+	if err != nil {
+		return nil, err
+	}
+	w := stateWriter{}
+	w.bytes(Snapshot())
+	w.chunk("THMB", func(chunk *stateWriter) {
+		chunk.bytes(thumbnail.Bytes())
+	})
+	return w.buffer.Bytes(), nil
+}
+
+// ReadSaveStateInfo reads the ROM, time and thumbnail of a save state file
+func ReadSaveStateInfo(location string) (SaveStateInfo, error) {
+	var info SaveStateInfo
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return info, err
+	}
+	chunks, version, err := readState(data)
+	if err != nil {
+		return info, err
+	}
+	info.Version = version
+	if chunk, ok := chunks["INFO"]; ok {
+		info.ROMKey = string(chunk.slice())
+		info.Saved = time.Unix(int64(chunk.uint64()), 0)
+	}
+	if chunk, ok := chunks["THMB"]; ok {
+		info.Thumbnail = chunk.data
+	}
+	return info, nil
+}
+
+// SaveStateLocation returns the file of a save state slot, from 1, for the loaded ROM
+func SaveStateLocation(slot int) string {
+	key := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, ROM.GetKey())
+	return path.Join(StateDir, key, fmt.Sprintf("slot%d.state", slot))
+}
+
+// SaveStateSlot saves the running machine into a slot, from 1
+func SaveStateSlot(slot int) error {
+	if slot < 1 || slot > SaveStateSlots {
+		return ErrSaveStateSlot
+	}
+	if ROM.GetKey() == "" {
+		return ErrSaveStateNoROM
+	}
+	var data []byte
+	var err error
+	CPU.Call(func() {
+		data, err = SaveState()
+	})
+	if err != nil {
+		return err
+	}
+	location := SaveStateLocation(slot)
+	if err := os.MkdirAll(path.Dir(location), os.FileMode(0755)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(location, data, 0644); err != nil {
+		return err
+	}
+	Logger.Logf(LogTypes.INFO, "SAVE STATE: saved slot %d\n", slot)
+	return nil
+}
+
+// LoadStateSlot loads a slot, from 1, into the running machine, refused while
+// a movie is recorded or played as it would no longer match its inputs
+func LoadStateSlot(slot int) error {
+	if slot < 1 || slot > SaveStateSlots {
+		return ErrSaveStateSlot
+	}
+	if ROM.GetKey() == "" {
+		return ErrSaveStateNoROM
+	}
+	data, err := ioutil.ReadFile(SaveStateLocation(slot))
+	if err != nil {
+		return err
+	}
+	CPU.Call(func() {
+		if Movie.Active() {
+			err = ErrSaveStateMovie
+			return
+		}
+		err = Restore(data)
+		Reverse.Clear()
+	})
+	if err != nil {
+		return err
+	}
+	Logger.Logf(LogTypes.INFO, "SAVE STATE: loaded slot %d\n", slot)
+	return nil
+}
+
+func (cpu *CPUType) saveState(w *stateWriter) {
+	registers := cpu.REGISTERS
+	w.uint16(registers.AF)
+	w.uint16(registers.BC)
+	w.uint16(registers.DE)
+	w.uint16(registers.HL)
+	w.uint16(registers.SP)
+	w.uint16(registers.PC)
+	w.uint64(cpu.CYCLES)
+	w.byte(INTERRUPTS.master)
+	w.byte(INTERRUPTS.enable)
+	w.byte(INTERRUPTS.flags)
+}
+
+func (cpu *CPUType) loadState(r *stateReader) {
+	registers := cpu.REGISTERS
+	registers.AF = r.uint16()
+	registers.BC = r.uint16()
+	registers.DE = r.uint16()
+	registers.HL = r.uint16()
+	registers.SP = r.uint16()
+	registers.PC = r.uint16()
+	cpu.CYCLES = r.uint64()
+	INTERRUPTS.master = r.byte()
+	INTERRUPTS.enable = r.byte()
+	INTERRUPTS.flags = r.byte()
+}
+
+// the MMU has no mapper registers yet, only the memory arrays and the boot ROM
+func (mmu *MMUType) saveState(w *stateWriter) {
+	w.bytes(sRAM[:])
+	w.bytes(io[:])
+	w.bytes(vRAM[:])
+	w.bytes(oam[:])
+	w.bytes(wRAM[:])
+	w.bytes(hRAM[:])
+	w.slice(mmu.bootROM)
+	w.uint64(mmu.divReset)
+}
+
+func (mmu *MMUType) loadState(r *stateReader) {
+	r.bytes(sRAM[:])
+	r.bytes(io[:])
+	r.bytes(vRAM[:])
+	r.bytes(oam[:])
+	r.bytes(wRAM[:])
+	r.bytes(hRAM[:])
+	mmu.bootROM = r.slice()
+	if len(mmu.bootROM) == 0 {
+		mmu.bootROM = nil
+	}
+	mmu.divReset = r.uint64()
+}
+
+func (gpu *GPUType) saveState(w *stateWriter) {
+	gpu.frameLock.Lock()
+	defer gpu.frameLock.Unlock()
+	for _, register := range []byte{gpu.control, gpu.status, gpu.scrollX, gpu.scrollY, gpu.scanline,
+		gpu.compare, gpu.windowX, gpu.windowY, gpu.mode, gpu.bgPalette, gpu.objPalette0, gpu.objPalette1} {
+		w.byte(register)
+	}
+	w.int(gpu.tick)
+	for y := range gpu.framebuffer {
+		for x := range gpu.framebuffer[y] {
+			w.uint16(gpu.framebuffer[y][x])
+		}
+	}
+	for y := range gpu.frame {
+		for x := range gpu.frame[y] {
+			w.uint16(gpu.frame[y][x])
+		}
+	}
+	w.bool(gpu.frameCGB)
+}
+
+func (gpu *GPUType) loadState(r *stateReader) {
+	gpu.frameLock.Lock()
+	for _, register := range []*byte{&gpu.control, &gpu.status, &gpu.scrollX, &gpu.scrollY, &gpu.scanline,
+		&gpu.compare, &gpu.windowX, &gpu.windowY, &gpu.mode, &gpu.bgPalette, &gpu.objPalette0, &gpu.objPalette1} {
+		*register = r.byte()
+	}
+	gpu.tick = r.int()
+	for y := range gpu.framebuffer {
+		for x := range gpu.framebuffer[y] {
+			gpu.framebuffer[y][x] = r.uint16()
+		}
+	}
+	for y := range gpu.frame {
+		for x := range gpu.frame[y] {
+			gpu.frame[y][x] = r.uint16()
+		}
+	}
+	gpu.frameCGB = r.bool()
+	gpu.frameLock.Unlock()
+	if gpu.OnFrame != nil {
+		gpu.OnFrame()
+	}
+}
+
+func (cgb *CGBType) saveState(w *stateWriter) {
+	w.bool(cgb.Enabled)
+	w.bool(cgb.DoubleSpeed)
+	w.bool(cgb.prepare)
+	w.byte(cgb.vRAMBank)
+	w.byte(cgb.wRAMBank)
+	w.byte(cgb.bgPaletteIndex)
+	w.byte(cgb.objPaletteIndex)
+	w.bytes(cgb.bgPaletteRAM[:])
+	w.bytes(cgb.objPaletteRAM[:])
+	w.uint16(cgb.hdmaSource)
+	w.uint16(cgb.hdmaDestination)
+	w.byte(cgb.hdmaBlocks)
+	w.bool(cgb.hdmaActive)
+}
+
+func (cgb *CGBType) loadState(r *stateReader) {
+	cgb.Enabled = r.bool()
+	cgb.DoubleSpeed = r.bool()
+	cgb.prepare = r.bool()
+	cgb.vRAMBank = r.byte()
+	cgb.wRAMBank = r.byte()
+	cgb.bgPaletteIndex = r.byte()
+	cgb.objPaletteIndex = r.byte()
+	r.bytes(cgb.bgPaletteRAM[:])
+	r.bytes(cgb.objPaletteRAM[:])
+	cgb.hdmaSource = r.uint16()
+	cgb.hdmaDestination = r.uint16()
+	cgb.hdmaBlocks = r.byte()
+	cgb.hdmaActive = r.bool()
+}
+
+func (sgb *SGBType) saveState(w *stateWriter) {
+	sgb.lock.Lock()
+	defer sgb.lock.Unlock()
+	w.bool(sgb.Enabled)
+	w.byte(sgb.lastP1)
+	w.int(sgb.bits)
+	w.bytes(sgb.packet[:])
+	w.slice(sgb.command)
+	w.int(sgb.packets)
+	w.byte(sgb.transfer)
+	w.bool(sgb.receive)
+	for i := range sgb.palettes {
+		for j := range sgb.palettes[i] {
+			w.uint16(sgb.palettes[i][j])
+		}
+	}
+	for y := range sgb.attributes {
+		w.bytes(sgb.attributes[y][:])
+	}
+	for i := range sgb.systemPalettes {
+		for j := range sgb.systemPalettes[i] {
+			w.uint16(sgb.systemPalettes[i][j])
+		}
+	}
+	w.byte(sgb.mask)
+	for y := range sgb.frozen {
+		for x := range sgb.frozen[y] {
+			w.uint16(sgb.frozen[y][x])
+		}
+	}
+	w.bytes(sgb.borderTiles[:])
+	for i := range sgb.borderMap {
+		w.uint16(sgb.borderMap[i])
+	}
+	for i := range sgb.borderPalettes {
+		for j := range sgb.borderPalettes[i] {
+			w.uint16(sgb.borderPalettes[i][j])
+		}
+	}
+	w.bool(sgb.hasBorder)
+}
+
+func (sgb *SGBType) loadState(r *stateReader) {
+	sgb.lock.Lock()
+	defer sgb.lock.Unlock()
+	sgb.Enabled = r.bool()
+	sgb.lastP1 = r.byte()
+	sgb.bits = r.int()
+	r.bytes(sgb.packet[:])
+	sgb.command = r.slice()
+	sgb.packets = r.int()
+	sgb.transfer = r.byte()
+	sgb.receive = r.bool()
+	for i := range sgb.palettes {
+		for j := range sgb.palettes[i] {
+			sgb.palettes[i][j] = r.uint16()
+		}
+	}
+	for y := range sgb.attributes {
+		r.bytes(sgb.attributes[y][:])
+	}
+	for i := range sgb.systemPalettes {
+		for j := range sgb.systemPalettes[i] {
+			sgb.systemPalettes[i][j] = r.uint16()
+		}
+	}
+	sgb.mask = r.byte()
+	for y := range sgb.frozen {
+		for x := range sgb.frozen[y] {
+			sgb.frozen[y][x] = r.uint16()
+		}
+	}
+	r.bytes(sgb.borderTiles[:])
+	for i := range sgb.borderMap {
+		sgb.borderMap[i] = r.uint16()
+	}
+	for i := range sgb.borderPalettes {
+		for j := range sgb.borderPalettes[i] {
+			sgb.borderPalettes[i][j] = r.uint16()
+		}
+	}
+	sgb.hasBorder = r.bool()
+}
+
+func (envelope *envelopeType) saveState(w *stateWriter) {
+	w.byte(envelope.initial)
+	w.bool(envelope.increase)
+	w.byte(envelope.period)
+	w.byte(envelope.timer)
+	w.byte(envelope.volume)
+}
+
+func (envelope *envelopeType) loadState(r *stateReader) {
+	envelope.initial = r.byte()
+	envelope.increase = r.bool()
+	envelope.period = r.byte()
+	envelope.timer = r.byte()
+	envelope.volume = r.byte()
+}
+
+func (length *lengthType) saveState(w *stateWriter) {
+	w.int(length.counter)
+	w.bool(length.enabled)
+}
+
+func (length *lengthType) loadState(r *stateReader) {
+	length.counter = r.int()
+	length.enabled = r.bool()
+}
+
+func (channel *SquareChannelType) saveState(w *stateWriter) {
+	w.bool(channel.Enabled)
+	w.bool(channel.dacEnabled)
+	w.byte(channel.Duty)
+	w.uint16(channel.Frequency)
+	w.int(channel.timer)
+	w.byte(channel.position)
+	channel.length.saveState(w)
+	channel.Envelope.saveState(w)
+	w.byte(channel.sweepPeriod)
+	w.bool(channel.sweepNegate)
+	w.byte(channel.sweepShift)
+	w.byte(channel.sweepTimer)
+	w.uint16(channel.sweepShadow)
+	w.bool(channel.sweepEnabled)
+}
+
+func (channel *SquareChannelType) loadState(r *stateReader) {
+	channel.Enabled = r.bool()
+	channel.dacEnabled = r.bool()
+	channel.Duty = r.byte()
+	channel.Frequency = r.uint16()
+	channel.timer = r.int()
+	channel.position = r.byte()
+	channel.length.loadState(r)
+	channel.Envelope.loadState(r)
+	channel.sweepPeriod = r.byte()
+	channel.sweepNegate = r.bool()
+	channel.sweepShift = r.byte()
+	channel.sweepTimer = r.byte()
+	channel.sweepShadow = r.uint16()
+	channel.sweepEnabled = r.bool()
+}
+
+func (apu *APUType) saveState(w *stateWriter) {
+	w.bool(apu.Enabled)
+	apu.Square1.saveState(w)
+	apu.Square2.saveState(w)
+
+	wave := &apu.Wave
+	w.bool(wave.Enabled)
+	w.bool(wave.dacEnabled)
+	w.byte(wave.VolumeCode)
+	w.uint16(wave.Frequency)
+	w.int(wave.timer)
+	w.byte(wave.position)
+	w.byte(wave.sample)
+	wave.length.saveState(w)
+	w.bytes(wave.RAM[:])
+
+	noise := &apu.Noise
+	w.bool(noise.Enabled)
+	w.bool(noise.dacEnabled)
+	w.byte(noise.Shift)
+	w.bool(noise.WidthMode)
+	w.byte(noise.Divisor)
+	w.int(noise.timer)
+	w.uint16(noise.lfsr)
+	noise.length.saveState(w)
+	noise.Envelope.saveState(w)
+
+	w.bytes(apu.registers[:])
+	w.int(apu.sequencerTimer)
+	w.byte(apu.sequencerStep)
+	w.int(apu.sampleTimer)
+}
+
+func (apu *APUType) loadState(r *stateReader) {
+	apu.Enabled = r.bool()
+	apu.Square1.loadState(r)
+	apu.Square2.loadState(r)
+
+	wave := &apu.Wave
+	wave.Enabled = r.bool()
+	wave.dacEnabled = r.bool()
+	wave.VolumeCode = r.byte()
+	wave.Frequency = r.uint16()
+	wave.timer = r.int()
+	wave.position = r.byte()
+	wave.sample = r.byte()
+	wave.length.loadState(r)
+	r.bytes(wave.RAM[:])
+
+	noise := &apu.Noise
+	noise.Enabled = r.bool()
+	noise.dacEnabled = r.bool()
+	noise.Shift = r.byte()
+	noise.WidthMode = r.bool()
+	noise.Divisor = r.byte()
+	noise.timer = r.int()
+	noise.lfsr = r.uint16()
+	noise.length.loadState(r)
+	noise.Envelope.loadState(r)
+
+	r.bytes(apu.registers[:])
+	apu.sequencerTimer = r.int()
+	apu.sequencerStep = r.byte()
+	apu.sampleTimer = r.int()
+}
+
+func (serial *SerialType) saveState(w *stateWriter) {
+	w.byte(serial.data)
+	w.byte(serial.control)
+	w.int(serial.timer)
+}
+
+func (serial *SerialType) loadState(r *stateReader) {
+	serial.data = r.byte()
+	serial.control = r.byte()
+	serial.timer = r.int()
+}
+
+func (joypad *JoypadType) saveState(w *stateWriter) {
+	w.byte(joypad.buttons)
+}
+
+func (joypad *JoypadType) loadState(r *stateReader) {
+	joypad.buttons = r.byte()
+}
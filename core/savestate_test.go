@@ -0,0 +1,115 @@
+package core
+
+import (
+	"crypto/sha1"
+	"errors"
+	"testing"
+)
+
+// saveStateProgram fills memory from VRAM upwards with a counter and scrolls
+// the background with it, so that consecutive frames differ. It starts after
+// the header, at 0x0150, and reaches the I/O registers after about seven frames.
+var saveStateProgram = []byte{
+	0x21, 0x00, 0x80, // 0x0150 LD HL, 0x8000
+	0x22,       // 0x0153 LD (HL+), A
+	0x3C,       // 0x0154 INC A
+	0xE0, 0x43, //       0x0155 LDH (0x43), A
+	0xC3, 0x53, 0x01, // 0x0157 JP 0x0153
+}
+
+// runFrameHashes runs frames and returns a hash of each completed frame
+func runFrameHashes(t *testing.T, frames int) [][sha1.Size]byte {
+	var hashes [][sha1.Size]byte
+	GPU.OnFrame = func() {
+		pixels, _, _ := GPU.Frame()
+		hashes = append(hashes, sha1.Sum(pixels))
+	}
+	defer func() { GPU.OnFrame = nil }()
+	if _, err := System.runFrames(frames, nil); err != nil {
+		t.Fatalf("Save State: %v", err)
+	}
+	return hashes
+}
+
+// TestSaveStateRoundTrip saves a state, runs on, restores it and runs again,
+// expecting the same frames and machine both times
+func TestSaveStateRoundTrip(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	runFrameHashes(t, 1)
+
+	state, err := SaveState()
+	if err != nil {
+		t.Fatalf("Save State: %v", err)
+	}
+	first := runFrameHashes(t, 3)
+	after := Snapshot()
+
+	if err := Restore(state); err != nil {
+		t.Fatalf("Save State: %v", err)
+	}
+	second := runFrameHashes(t, 3)
+
+	if len(first) == 0 || len(first) != len(second) {
+		t.Fatalf("Save State: %d frames after saving, %d after loading", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("Save State: frame %d differs after loading", i)
+		}
+	}
+	if first[0] == first[len(first)-1] {
+		t.Errorf("Save State: the test program did not change the screen")
+	}
+	// the INFO chunk holds the time, compare the machine chunks only
+	if string(machineChunks(t, Snapshot())) != string(machineChunks(t, after)) {
+		t.Errorf("Save State: machine state differs after loading")
+	}
+}
+
+func machineChunks(t *testing.T, data []byte) []byte {
+	chunks, _, err := readState(data)
+	if err != nil {
+		t.Fatalf("Save State: %v", err)
+	}
+	var machine []byte
+	for _, tag := range []string{"CPU ", "MEM ", "GPU ", "CGB ", "SGB ", "APU ", "SER ", "JOYP"} {
+		machine = append(machine, chunks[tag].data...)
+	}
+	return machine
+}
+
+// TestSaveStateCompatibility loads states with unknown chunks and truncated chunks,
+// as written by newer and older versions
+func TestSaveStateCompatibility(t *testing.T) {
+	if err := ROM.Load(testROM(nil)); err != nil {
+		t.Fatalf("Save State: %v", err)
+	}
+	CPU.Reset()
+	CPU.REGISTERS.PC = 0x1234
+
+	var newer stateWriter
+	newer.bytes(Snapshot())
+	newer.chunk("NEW?", func(chunk *stateWriter) {
+		chunk.bytes([]byte{1, 2, 3})
+	})
+	if err := Restore(newer.buffer.Bytes()); err != nil {
+		t.Errorf("Save State: unknown chunk: %v", err)
+	}
+
+	var older stateWriter
+	older.bytes([]byte(saveStateMagic))
+	older.uint16(SaveStateVersion)
+	older.chunk("CPU ", func(chunk *stateWriter) {
+		chunk.uint16(0x01B0)
+	})
+	if err := Restore(older.buffer.Bytes()); err != nil {
+		t.Errorf("Save State: truncated chunk: %v", err)
+	}
+	if CPU.REGISTERS.AF != 0x01B0 || CPU.REGISTERS.PC != 0 {
+		t.Errorf("Save State: truncated chunk loaded AF 0x%04X PC 0x%04X", CPU.REGISTERS.AF, CPU.REGISTERS.PC)
+	}
+
+	if err := Restore([]byte("not a state")); !errors.Is(err, ErrNotSaveState) {
+		t.Errorf("Save State: got %v, expected %v", err, ErrNotSaveState)
+	}
+}
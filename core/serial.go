@@ -0,0 +1,148 @@
+package core
+
+import (
+	"html"
+	"strings"
+	"sync"
+)
+
+// serialTransferCycles is the length of an internally clocked transfer, 8 bits at 8192Hz
+const serialTransferCycles = ClockSpeed / 8192 * 8
+
+// SerialPeer is the device on the other end of the link cable
+type SerialPeer interface {
+	// Transfer exchanges a byte clocked by this Game Boy, returning the byte shifted in
+	Transfer(out byte) byte
+	// Receive exchanges a byte if the peer has clocked a transfer, ok is false while none is pending
+	Receive(out byte) (in byte, ok bool)
+}
+
+// SerialType is the structure that holds the serial port registers
+//
+//	Serial Structure
+//	================
+//	---> SB data register (0xFF01)
+//	---> SC control register (0xFF02), transfer start and clock select
+//	---> Peer the link cable is connected to
+//	================
+type SerialType struct {
+	data    byte
+	control byte
+	timer   int
+	Peer    SerialPeer
+}
+
+// Serial is the exported object used in the system
+//
+// Serial is exported so a link cable peer can be plugged in
+var Serial = SerialType{
+	Peer: &SerialPrinter,
+}
+
+// Reset clears the serial registers, keeping the peer connected
+func (serial *SerialType) Reset() {
+	serial.data = 0x00
+	serial.control = 0x00
+	serial.timer = 0
+}
+
+// ReadControl returns SC, unused bits read as 1
+func (serial *SerialType) ReadControl() byte {
+	if CGB.Enabled {
+		return serial.control | 0x7C
+	}
+	return serial.control | 0x7E
+}
+
+// WriteControl stores SC, starting an internally clocked transfer when bits 7 and 0 are set
+func (serial *SerialType) WriteControl(value byte) {
+	serial.control = value & 0x83
+	if !CGB.Enabled {
+		serial.control &= 0x81
+	}
+	if serial.control&0x81 == 0x81 {
+		serial.timer = serialTransferCycles
+		if serial.control&0x02 != 0 {
+			// CGB fast clock, 262144Hz
+			serial.timer /= 32
+		}
+	}
+}
+
+// Step advances a transfer in progress by the given number of CPU cycles
+func (serial *SerialType) Step(cycles int) {
+	if serial.control&0x80 == 0 {
+		return
+	}
+	if serial.control&0x01 != 0 {
+		serial.timer -= cycles
+		if serial.timer > 0 {
+			return
+		}
+		in := byte(0xFF)
+		if serial.Peer != nil {
+			in = serial.Peer.Transfer(serial.data)
+		}
+		serial.complete(in)
+	} else if serial.Peer != nil {
+		if in, ok := serial.Peer.Receive(serial.data); ok {
+			serial.complete(in)
+		}
+	}
+}
+
+// complete finishes a transfer and requests the serial interrupt
+func (serial *SerialType) complete(in byte) {
+	serial.data = in
+	serial.control &^= 0x80
+	INTERRUPTS.flags |= 0x08
+}
+
+// SerialPrinterType is a peer that collects every transmitted byte as text,
+// which is how test ROMs such as Blargg's cpu_instrs report their results
+type SerialPrinterType struct {
+	output strings.Builder
+	line   strings.Builder
+	lock   sync.Mutex
+}
+
+// SerialPrinter is the exported object used in the system
+//
+// SerialPrinter is exported so test harnesses can read what a ROM printed
+var SerialPrinter SerialPrinterType
+
+// Transfer records the byte, echoing each complete line to the log,
+// and returns 0xFF as if nothing were connected
+func (printer *SerialPrinterType) Transfer(out byte) byte {
+	printer.lock.Lock()
+	defer printer.lock.Unlock()
+	printer.output.WriteByte(out)
+	if out == '\n' {
+		// the console shows Pango markup, which a ROM must not be able to write
+		Logger.Logf(LogTypes.INFO, "SERIAL: %s\n", html.EscapeString(printer.line.String()))
+		printer.line.Reset()
+	} else {
+		printer.line.WriteByte(out)
+	}
+	return 0xFF
+}
+
+// Receive never completes, the printer does not drive the clock
+func (printer *SerialPrinterType) Receive(out byte) (byte, bool) {
+	return 0xFF, false
+}
+
+// Output returns everything transmitted since the last Clear
+func (printer *SerialPrinterType) Output() string {
+	printer.lock.Lock()
+	defer printer.lock.Unlock()
+	return printer.output.String()
+}
+
+// Clear discards the collected output
+func (printer *SerialPrinterType) Clear() {
+	printer.lock.Lock()
+	defer printer.lock.Unlock()
+	printer.output.Reset()
+	printer.line.Reset()
+}
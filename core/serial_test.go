@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+// stubPeer is a link cable peer answering with a fixed byte. It has clocked
+// a transfer for Receive once pending is set.
+type stubPeer struct {
+	in      byte
+	pending bool
+	sent    []byte
+}
+
+func (peer *stubPeer) Transfer(out byte) byte {
+	peer.sent = append(peer.sent, out)
+	return peer.in
+}
+
+func (peer *stubPeer) Receive(out byte) (byte, bool) {
+	if !peer.pending {
+		return 0, false
+	}
+	peer.pending = false
+	peer.sent = append(peer.sent, out)
+	return peer.in, true
+}
+
+// TestSerialInternalClock starts transfers on the internal clock, expecting
+// them to last 8 bits at 8192Hz, or at 262144Hz with the CGB fast clock
+func TestSerialInternalClock(t *testing.T) {
+	defer func(enabled bool) { CGB.Enabled = enabled }(CGB.Enabled)
+	tests := []struct {
+		name    string
+		cgb     bool
+		control byte
+		cycles  int
+	}{
+		{"DMG", false, 0x81, serialTransferCycles},
+		{"DMG ignores the fast clock", false, 0x83, serialTransferCycles},
+		{"CGB", true, 0x81, serialTransferCycles},
+		{"CGB fast clock", true, 0x83, serialTransferCycles / 32},
+	}
+	for _, test := range tests {
+		CGB.Enabled = test.cgb
+		INTERRUPTS.flags = 0x00
+		peer := &stubPeer{in: 0x5A}
+		serial := SerialType{Peer: peer}
+		serial.data = 0x42
+		serial.WriteControl(test.control)
+
+		serial.Step(test.cycles - 4)
+		if serial.control&0x80 == 0 || INTERRUPTS.flags&0x08 != 0 {
+			t.Errorf("Serial: %s: finished before %d cycles", test.name, test.cycles)
+		}
+		serial.Step(4)
+		if serial.control&0x80 != 0 {
+			t.Errorf("Serial: %s: still transferring after %d cycles", test.name, test.cycles)
+		}
+		if INTERRUPTS.flags&0x08 == 0 {
+			t.Errorf("Serial: %s: no serial interrupt requested", test.name)
+		}
+		if serial.data != 0x5A || len(peer.sent) != 1 || peer.sent[0] != 0x42 {
+			t.Errorf("Serial: %s: exchanged %v for 0x%02X, expected [0x42] for 0x5A", test.name, peer.sent, serial.data)
+		}
+	}
+	INTERRUPTS.flags = 0x00
+}
+
+// TestSerialExternalClock expects a transfer on the external clock to wait for
+// the peer, however many cycles pass, and a missing peer to read 0xFF
+func TestSerialExternalClock(t *testing.T) {
+	defer func(enabled bool) { CGB.Enabled = enabled }(CGB.Enabled)
+	CGB.Enabled = false
+	INTERRUPTS.flags = 0x00
+	peer := &stubPeer{in: 0x99}
+	serial := SerialType{Peer: peer}
+	serial.data = 0x24
+	serial.WriteControl(0x80)
+	serial.Step(serialTransferCycles * 4)
+	if serial.control&0x80 == 0 || INTERRUPTS.flags&0x08 != 0 {
+		t.Errorf("Serial: external clock transfer finished without the peer")
+	}
+	if value := serial.ReadControl(); value != 0xFE {
+		t.Errorf("Serial: SC reads 0x%02X during a transfer, expected 0xFE", value)
+	}
+
+	peer.pending = true
+	serial.Step(4)
+	if serial.control&0x80 != 0 || INTERRUPTS.flags&0x08 == 0 {
+		t.Errorf("Serial: external clock transfer not finished by the peer")
+	}
+	if serial.data != 0x99 || len(peer.sent) != 1 || peer.sent[0] != 0x24 {
+		t.Errorf("Serial: exchanged %v for 0x%02X, expected [0x24] for 0x99", peer.sent, serial.data)
+	}
+	if value := serial.ReadControl(); value != 0x7E {
+		t.Errorf("Serial: SC reads 0x%02X when finished, expected 0x7E", value)
+	}
+
+	INTERRUPTS.flags = 0x00
+	serial = SerialType{}
+	serial.data = 0x24
+	serial.WriteControl(0x81)
+	serial.Step(serialTransferCycles)
+	if serial.data != 0xFF || INTERRUPTS.flags&0x08 == 0 {
+		t.Errorf("Serial: unplugged transfer read 0x%02X, expected 0xFF and an interrupt", serial.data)
+	}
+	INTERRUPTS.flags = 0x00
+}
+
+// TestSerialPrinterMarkup expects text printed by a ROM to reach the log with
+// the console's markup characters escaped
+func TestSerialPrinterMarkup(t *testing.T) {
+	var buffer bytes.Buffer
+	defer func(logger *log.Logger) { Logger.InternalLogger = logger }(Logger.InternalLogger)
+	Logger.InternalLogger = log.New(&buffer, "", 0)
+	var printer SerialPrinterType
+	for _, c := range []byte("<b>Passed & done</b>\n") {
+		printer.Transfer(c)
+	}
+	if logged := buffer.String(); !strings.Contains(logged, "&lt;b&gt;Passed &amp; done&lt;/b&gt;") {
+		t.Errorf("Serial: logged %q unescaped", logged)
+	}
+	if output := printer.Output(); output != "<b>Passed & done</b>\n" {
+		t.Errorf("Serial: captured %q, expected the text as printed", output)
+	}
+}
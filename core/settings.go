@@ -0,0 +1,105 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// SettingsType is the structure that holds the user preferences
+// persisted to ~/.freemegb/settings.json between sessions
+type SettingsType struct {
+	Shader         string            `json:"shader"`
+	Renderer       string            `json:"renderer"`
+	Palette        string            `json:"palette"`
+	ROMPalettes    map[string]string `json:"rom_palettes"`
+	Model          string            `json:"model"`
+	ROMModels      map[string]string `json:"rom_models"`
+	SGBBorder      bool              `json:"sgb_border"`
+	SampleRate     int               `json:"sample_rate"`
+	Volume         int               `json:"volume"`
+	Muted          bool              `json:"muted"`
+	LinkMode       string            `json:"link_mode"`
+	LinkHost       string            `json:"link_host"`
+	LinkPort       int               `json:"link_port"`
+	BootROM        string            `json:"boot_rom"`
+	BootROMCGB     string            `json:"boot_rom_cgb"`
+	RewindInterval int               `json:"rewind_interval"`
+	RewindBudget   int               `json:"rewind_budget"`
+	GDBPort        int               `json:"gdb_port"`
+}
+
+// Settings is the exported object used in the system
+//
+// Settings is exported so the UI can read and change the user preferences
+var Settings = SettingsType{
+	Shader:         ShaderPresetDefault,
+	Renderer:       RendererAuto,
+	Palette:        PaletteDefault,
+	ROMPalettes:    map[string]string{},
+	Model:          ModelAuto,
+	ROMModels:      map[string]string{},
+	SGBBorder:      true,
+	SampleRate:     44100,
+	Volume:         100,
+	LinkMode:       LinkModeOff,
+	LinkHost:       "localhost",
+	LinkPort:       LinkPortDefault,
+	RewindInterval: RewindIntervalDefault,
+	RewindBudget:   RewindBudgetDefault,
+	GDBPort:        GDBPortDefault,
+}
+
+// SettingsFilename is the location of the settings file
+var SettingsFilename string
+
+// Load reads the settings file, keeping the defaults for any missing values
+func (settings *SettingsType) Load() {
+	SettingsFilename = path.Join(UserHome, ".freemegb", "settings.json")
+	data, err := ioutil.ReadFile(SettingsFilename)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		Logger.Log(LogTypes.ERROR, "SETTINGS: Error loading", err)
+		return
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		Logger.Log(LogTypes.ERROR, "SETTINGS: Error parsing", err)
+	}
+	if settings.ROMPalettes == nil {
+		settings.ROMPalettes = map[string]string{}
+	}
+	if settings.ROMModels == nil {
+		settings.ROMModels = map[string]string{}
+	}
+	if settings.SampleRate <= 0 {
+		settings.SampleRate = 44100
+	}
+	APU.SampleRate = settings.SampleRate
+	if settings.LinkPort <= 0 || settings.LinkPort > 0xFFFF {
+		settings.LinkPort = LinkPortDefault
+	}
+	if settings.RewindInterval <= 0 {
+		settings.RewindInterval = RewindIntervalDefault
+	}
+	if settings.RewindBudget < 0 {
+		settings.RewindBudget = RewindBudgetDefault
+	}
+	Rewind.Configure(settings.RewindInterval, settings.RewindBudget)
+	if settings.GDBPort <= 0 || settings.GDBPort > 0xFFFF {
+		settings.GDBPort = GDBPortDefault
+	}
+}
+
+// Save writes the settings file
+func (settings *SettingsType) Save() {
+	data, err := json.MarshalIndent(settings, "", "\t")
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "SETTINGS: Error encoding", err)
+		return
+	}
+	if err := ioutil.WriteFile(SettingsFilename, data, 0644); err != nil {
+		Logger.Log(LogTypes.ERROR, "SETTINGS: Error saving", err)
+	}
+}
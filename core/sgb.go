@@ -0,0 +1,429 @@
+package core
+
+import (
+	"sync"
+)
+
+// SGBBorderWidth is the width of the Super Game Boy screen including its border
+const SGBBorderWidth = 256
+
+// SGBBorderHeight is the height of the Super Game Boy screen including its border
+const SGBBorderHeight = 224
+
+// Position of the Game Boy screen within the border
+const (
+	sgbScreenX = 48
+	sgbScreenY = 40
+)
+
+// Super Game Boy command codes
+const (
+	sgbPAL01   byte = 0x00
+	sgbPAL23   byte = 0x01
+	sgbPAL03   byte = 0x02
+	sgbPAL12   byte = 0x03
+	sgbATTRBLK byte = 0x04
+	sgbATTRLIN byte = 0x05
+	sgbATTRDIV byte = 0x06
+	sgbATTRCHR byte = 0x07
+	sgbPALSET  byte = 0x0A
+	sgbPALTRN  byte = 0x0B
+	sgbMLTREQ  byte = 0x11
+	sgbCHRTRN  byte = 0x13
+	sgbPCTTRN  byte = 0x14
+	sgbMASKEN  byte = 0x17
+)
+
+// Screen masks set by MASK_EN
+const (
+	sgbMaskCancel byte = 0
+	sgbMaskFreeze byte = 1
+	sgbMaskBlack  byte = 2
+	sgbMaskColour byte = 3
+)
+
+// SGBType is the structure that holds the Super Game Boy state
+//
+//	SGB Structure
+//	================
+//	---> Packet receiver fed by writes to P1
+//	---> Four screen palettes and the 20x18 attribute map selecting them
+//	---> System palettes from PAL_TRN
+//	---> Border tiles, map and palettes from CHR_TRN and PCT_TRN
+//	---> Screen mask from MASK_EN
+//	================
+type SGBType struct {
+	Enabled bool
+
+	// packet receiver
+	lastP1   byte
+	bits     int
+	packet   [16]byte
+	command  []byte
+	packets  int
+	transfer byte
+	receive  bool
+
+	// lock guards the fields below. GPU.frameLock is always taken first, as
+	// GPU.Frame holds it while Compose takes lock, so lock must never be held
+	// while taking GPU.frameLock.
+	lock           sync.Mutex
+	palettes       [4][4]uint16
+	attributes     [18][20]byte
+	systemPalettes [512][4]uint16
+	mask           byte
+	frozen         [ScreenHeight][ScreenWidth]uint16
+
+	borderTiles    [256 * 32]byte
+	borderMap      [32 * 28]uint16
+	borderPalettes [4][16]uint16
+	hasBorder      bool
+}
+
+// SGB is the exported object used in the system
+//
+// SGB is exported so the UI can report the hardware model in use
+var SGB = SGBType{}
+
+// Reset clears the palettes, attributes, border and any partially received packet
+func (sgb *SGBType) Reset() {
+	sgb.lock.Lock()
+	defer sgb.lock.Unlock()
+	sgb.lastP1 = 0x30
+	sgb.bits = 0
+	sgb.command = nil
+	sgb.packets = 0
+	sgb.transfer = 0
+	sgb.receive = false
+	sgb.mask = sgbMaskCancel
+	sgb.attributes = [18][20]byte{}
+	sgb.hasBorder = false
+	for i := range sgb.palettes {
+		for j, colour := range Palettes[0].Colours {
+			sgb.palettes[i][j] = uint16(colour[0]>>3) | uint16(colour[1]>>3)<<5 | uint16(colour[2]>>3)<<10
+		}
+	}
+}
+
+// WriteP1 decodes the packet bits sent through P14 and P15.
+// Pulling both low resets the receiver, P14 low sends a 0 and P15 low sends a 1,
+// each bit followed by both high. 128 bits and a 0 stop bit form a 16 byte packet.
+func (sgb *SGBType) WriteP1(value byte) {
+	lines := value & 0x30
+	previous := sgb.lastP1
+	sgb.lastP1 = lines
+	if lines == 0x00 {
+		sgb.receive = true
+		sgb.bits = 0
+		sgb.packet = [16]byte{}
+		return
+	}
+	if !sgb.receive || previous != 0x30 && previous != 0x00 || lines == 0x30 {
+		return
+	}
+
+	bit := byte(0)
+	if lines == 0x10 {
+		bit = 1
+	}
+	if sgb.bits == 128 {
+		// stop bit ends the packet
+		sgb.receive = false
+		if bit == 0 {
+			sgb.receivePacket()
+		}
+		return
+	}
+	sgb.packet[sgb.bits/8] |= bit << (sgb.bits % 8)
+	sgb.bits++
+}
+
+func (sgb *SGBType) receivePacket() {
+	if sgb.packets == 0 {
+		sgb.packets = int(sgb.packet[0] & 0x07)
+		if sgb.packets == 0 {
+			return
+		}
+		sgb.command = nil
+	}
+	sgb.command = append(sgb.command, sgb.packet[:]...)
+	sgb.packets--
+	if sgb.packets == 0 {
+		sgb.execute(sgb.command)
+	}
+}
+
+func (sgb *SGBType) execute(data []byte) {
+	command := data[0] >> 3
+	// the frame MASK_EN freezes is copied before taking sgb.lock
+	var frame [ScreenHeight][ScreenWidth]uint16
+	if command == sgbMASKEN {
+		GPU.frameLock.Lock()
+		frame = GPU.frame
+		GPU.frameLock.Unlock()
+	}
+
+	sgb.lock.Lock()
+	defer sgb.lock.Unlock()
+	switch command {
+	case sgbPAL01:
+		sgb.setPalettes(data, 0, 1)
+	case sgbPAL23:
+		sgb.setPalettes(data, 2, 3)
+	case sgbPAL03:
+		sgb.setPalettes(data, 0, 3)
+	case sgbPAL12:
+		sgb.setPalettes(data, 1, 2)
+	case sgbATTRBLK:
+		sgb.attributeBlocks(data)
+	case sgbATTRLIN:
+		sgb.attributeLines(data)
+	case sgbATTRDIV:
+		sgb.attributeDivide(data)
+	case sgbATTRCHR:
+		sgb.attributeCharacters(data)
+	case sgbPALSET:
+		for i := 0; i < 4; i++ {
+			id := (uint16(data[1+i*2]) | uint16(data[2+i*2])<<8) & 0x1FF
+			sgb.palettes[i] = sgb.systemPalettes[id]
+		}
+		if data[9]&0x40 != 0 {
+			sgb.mask = sgbMaskCancel
+		}
+	case sgbPALTRN, sgbCHRTRN, sgbPCTTRN:
+		sgb.transfer = command
+		if command == sgbCHRTRN && data[1]&0x01 != 0 {
+			sgb.transfer |= 0x80
+		}
+	case sgbMASKEN:
+		sgb.mask = data[1] & 0x03
+		if sgb.mask == sgbMaskFreeze {
+			sgb.frozen = frame
+		}
+	case sgbMLTREQ:
+		// multiplayer is not supported, a single controller is always reported
+	default:
+		Logger.Logf(LogTypes.WARNING, "SGB: unsupported command 0x%02X\n", command)
+	}
+}
+
+func (sgb *SGBType) setPalettes(data []byte, first int, second int) {
+	colour := func(i int) uint16 {
+		return uint16(data[1+i*2]) | uint16(data[2+i*2])<<8&0x7F00
+	}
+	for i := range sgb.palettes {
+		sgb.palettes[i][0] = colour(0)
+	}
+	for i := 1; i < 4; i++ {
+		sgb.palettes[first][i] = colour(i)
+		sgb.palettes[second][i] = colour(i + 3)
+	}
+}
+
+func (sgb *SGBType) attributeBlocks(data []byte) {
+	sets := int(data[1] & 0x1F)
+	for set := 0; set < sets && 2+set*6+5 < len(data); set++ {
+		block := data[2+set*6 : 2+set*6+6]
+		control := block[0] & 0x07
+		inside := block[1] & 0x03
+		border := (block[1] >> 2) & 0x03
+		outside := (block[1] >> 4) & 0x03
+		// a lone inside or outside setting also colours the border
+		if control == 0x01 {
+			border = inside
+			control |= 0x02
+		} else if control == 0x04 {
+			border = outside
+			control |= 0x02
+		}
+		x1, y1, x2, y2 := int(block[2]&0x1F), int(block[3]&0x1F), int(block[4]&0x1F), int(block[5]&0x1F)
+		for y := 0; y < 18; y++ {
+			for x := 0; x < 20; x++ {
+				onEdge := (x == x1 || x == x2) && y >= y1 && y <= y2 || (y == y1 || y == y2) && x >= x1 && x <= x2
+				within := x > x1 && x < x2 && y > y1 && y < y2
+				if control&0x01 != 0 && within {
+					sgb.attributes[y][x] = inside
+				} else if control&0x02 != 0 && onEdge {
+					sgb.attributes[y][x] = border
+				} else if control&0x04 != 0 && !within && !onEdge {
+					sgb.attributes[y][x] = outside
+				}
+			}
+		}
+	}
+}
+
+func (sgb *SGBType) attributeLines(data []byte) {
+	count := int(data[1])
+	for i := 0; i < count && 2+i < len(data); i++ {
+		line := int(data[2+i] & 0x1F)
+		palette := (data[2+i] >> 5) & 0x03
+		if data[2+i]&0x80 != 0 {
+			if line < 18 {
+				for x := 0; x < 20; x++ {
+					sgb.attributes[line][x] = palette
+				}
+			}
+		} else if line < 20 {
+			for y := 0; y < 18; y++ {
+				sgb.attributes[y][line] = palette
+			}
+		}
+	}
+}
+
+func (sgb *SGBType) attributeDivide(data []byte) {
+	after := data[1] & 0x03
+	before := (data[1] >> 2) & 0x03
+	on := (data[1] >> 4) & 0x03
+	horizontal := data[1]&0x40 != 0
+	divide := int(data[2] & 0x1F)
+	for y := 0; y < 18; y++ {
+		for x := 0; x < 20; x++ {
+			position := x
+			if horizontal {
+				position = y
+			}
+			if position < divide {
+				sgb.attributes[y][x] = before
+			} else if position == divide {
+				sgb.attributes[y][x] = on
+			} else {
+				sgb.attributes[y][x] = after
+			}
+		}
+	}
+}
+
+func (sgb *SGBType) attributeCharacters(data []byte) {
+	x, y := int(data[1]%20), int(data[2]%18)
+	count := int(data[3]) | int(data[4])<<8
+	vertical := data[5]&0x01 != 0
+	for i := 0; i < count && 6+i/4 < len(data) && i < 360; i++ {
+		sgb.attributes[y][x] = (data[6+i/4] >> (6 - uint(i%4)*2)) & 0x03
+		if vertical {
+			if y++; y == 18 {
+				y = 0
+				x = (x + 1) % 20
+			}
+		} else if x++; x == 20 {
+			x = 0
+			y = (y + 1) % 18
+		}
+	}
+}
+
+// VBlank completes a pending VRAM transfer, called once a frame has been drawn.
+// The transferred 4KB are the tiles of the first 20x13 background map entries.
+func (sgb *SGBType) VBlank() {
+	if sgb.transfer == 0 {
+		return
+	}
+	var data [0x1000]byte
+	mapBase := uint16(0x9800)
+	if GPU.control&0x08 != 0 {
+		mapBase = 0x9C00
+	}
+	for i := 0; i < 0x100; i++ {
+		tile := vRAM[mapBase-OFFSETvRAM+uint16(i/20)*32+uint16(i%20)]
+		address := GPU.tileAddress(tile) - OFFSETvRAM
+		copy(data[i*16:i*16+16], vRAM[address:address+16])
+	}
+
+	sgb.lock.Lock()
+	defer sgb.lock.Unlock()
+	switch sgb.transfer & 0x7F {
+	case sgbPALTRN:
+		for i := range sgb.systemPalettes {
+			for j := 0; j < 4; j++ {
+				sgb.systemPalettes[i][j] = uint16(data[i*8+j*2]) | uint16(data[i*8+j*2+1])<<8&0x7F00
+			}
+		}
+	case sgbCHRTRN:
+		offset := 0
+		if sgb.transfer&0x80 != 0 {
+			offset = 0x1000
+		}
+		copy(sgb.borderTiles[offset:offset+0x1000], data[:])
+	case sgbPCTTRN:
+		for i := range sgb.borderMap {
+			sgb.borderMap[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+		}
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 16; j++ {
+				sgb.borderPalettes[i][j] = uint16(data[0x800+i*32+j*2]) | uint16(data[0x801+i*32+j*2])<<8&0x7F00
+			}
+		}
+		sgb.hasBorder = true
+	}
+	sgb.transfer = 0
+}
+
+// Compose colours a frame of DMG shades with the SGB palettes and attribute map,
+// surrounded by the border when one has been transferred and Settings.SGBBorder is set.
+// It returns RGBA pixels and their width and height.
+func (sgb *SGBType) Compose(frame *[ScreenHeight][ScreenWidth]uint16) ([]byte, int, int) {
+	sgb.lock.Lock()
+	defer sgb.lock.Unlock()
+
+	if sgb.mask == sgbMaskFreeze {
+		frame = &sgb.frozen
+	}
+	width, height, left, top := ScreenWidth, ScreenHeight, 0, 0
+	border := sgb.hasBorder && Settings.SGBBorder
+	if border {
+		width, height, left, top = SGBBorderWidth, SGBBorderHeight, sgbScreenX, sgbScreenY
+	}
+	pixels := make([]byte, width*height*4)
+	put := func(x int, y int, colour uint16) {
+		i := (y*width + x) * 4
+		copy(pixels[i:], rgb15(colour))
+		pixels[i+3] = 0xFF
+	}
+
+	if border {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				put(x, y, sgb.borderPixel(x, y))
+			}
+		}
+	}
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x++ {
+			var colour uint16
+			switch sgb.mask {
+			case sgbMaskBlack:
+				colour = 0
+			case sgbMaskColour:
+				colour = sgb.palettes[0][0]
+			default:
+				colour = sgb.palettes[sgb.attributes[y/8][x/8]][frame[y][x]&0x03]
+			}
+			put(left+x, top+y, colour)
+		}
+	}
+	return pixels, width, height
+}
+
+// borderPixel returns the colour of a border pixel from the SNES 4bpp tiles
+// and map sent by CHR_TRN and PCT_TRN
+func (sgb *SGBType) borderPixel(x int, y int) uint16 {
+	entry := sgb.borderMap[(y/8)*32+x/8]
+	tile := int(entry & 0xFF)
+	palette := int((entry>>10)&0x07) - 4
+	column, row := x%8, y%8
+	if entry&0x4000 != 0 {
+		column = 7 - column
+	}
+	if entry&0x8000 != 0 {
+		row = 7 - row
+	}
+	data := sgb.borderTiles[tile*32:]
+	bit := uint(7 - column)
+	colour := (data[row*2]>>bit)&1 | (data[row*2+1]>>bit)&1<<1 |
+		(data[16+row*2]>>bit)&1<<2 | (data[16+row*2+1]>>bit)&1<<3
+	if colour == 0 || palette < 0 {
+		return sgb.palettes[0][0]
+	}
+	return sgb.borderPalettes[palette][colour]
+}
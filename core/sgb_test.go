@@ -0,0 +1,232 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+// loadSGB switches an SGB on, with the GPU reading tiles from 0x8000 and its
+// map from 0x9800 as a game does before a VRAM transfer
+func loadSGB(t *testing.T) {
+	loadProgram(t, []byte{0x00})
+	SGB.Enabled = true
+	SGB.Reset()
+	GPU.control = 0x80 | 0x10
+	border := Settings.SGBBorder
+	t.Cleanup(func() {
+		SGB.Enabled = false
+		SGB.Reset()
+		Settings.SGBBorder = border
+	})
+}
+
+// sendSGB bit-bangs packets through P1 as a game does: both lines low to
+// reset, P14 low for a 0 bit or P15 low for a 1 bit each followed by both
+// high, least significant bit first, and a 0 stop bit
+func sendSGB(packets ...[]byte) {
+	pulse := func(lines byte) {
+		MMU.WriteByte(0xFF00, lines)
+		MMU.WriteByte(0xFF00, 0x30)
+	}
+	for _, data := range packets {
+		var packet [16]byte
+		copy(packet[:], data)
+		pulse(0x00)
+		for _, value := range packet {
+			for bit := uint(0); bit < 8; bit++ {
+				if value>>bit&0x01 != 0 {
+					pulse(0x10)
+				} else {
+					pulse(0x20)
+				}
+			}
+		}
+		pulse(0x20)
+	}
+}
+
+// sgbCommand returns the first byte of a single packet command
+func sgbCommand(command byte) byte {
+	return command<<3 | 0x01
+}
+
+// pixelAt returns the RGB of a pixel composed by SGB.Compose
+func pixelAt(pixels []byte, width int, x int, y int) []byte {
+	i := (y*width + x) * 4
+	return pixels[i : i+3]
+}
+
+// TestSGBPalettes sets all four palettes with PAL01 and PAL23, expecting
+// colour 0 shared by every palette and the frame coloured through palette 0
+func TestSGBPalettes(t *testing.T) {
+	loadSGB(t)
+	sendSGB(
+		[]byte{sgbCommand(sgbPAL01),
+			0x1F, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00,
+			0x04, 0x00, 0x05, 0x00, 0x06, 0x00},
+		[]byte{sgbCommand(sgbPAL23),
+			0xE0, 0x03, 0x07, 0x00, 0x08, 0x00, 0x09, 0x80,
+			0x0A, 0x00, 0x0B, 0x00, 0x0C, 0x00},
+	)
+	// bit 15 of a colour is ignored
+	expected := [4][4]uint16{
+		{0x03E0, 0x0001, 0x0002, 0x0003},
+		{0x03E0, 0x0004, 0x0005, 0x0006},
+		{0x03E0, 0x0007, 0x0008, 0x0009},
+		{0x03E0, 0x000A, 0x000B, 0x000C},
+	}
+	if SGB.palettes != expected {
+		t.Errorf("SGB: palettes %04X, expected %04X", SGB.palettes, expected)
+	}
+
+	var frame [ScreenHeight][ScreenWidth]uint16
+	frame[0][0] = 2
+	pixels, width, height := SGB.Compose(&frame)
+	if width != ScreenWidth || height != ScreenHeight {
+		t.Fatalf("SGB: composed %dx%d without a border, expected %dx%d", width, height, ScreenWidth, ScreenHeight)
+	}
+	if pixel := pixelAt(pixels, width, 0, 0); !bytes.Equal(pixel, rgb15(0x0002)) {
+		t.Errorf("SGB: shade 2 composed as %v, expected palette 0 colour 2", pixel)
+	}
+	if pixel := pixelAt(pixels, width, 1, 0); !bytes.Equal(pixel, rgb15(0x03E0)) {
+		t.Errorf("SGB: shade 0 composed as %v, expected the shared colour 0", pixel)
+	}
+}
+
+// TestSGBAttributes sends each ATTR command and checks the palette it selects
+// for a few attribute map cells
+func TestSGBAttributes(t *testing.T) {
+	type cell struct {
+		x, y    int
+		palette byte
+	}
+	tests := []struct {
+		name   string
+		packet []byte
+		cells  []cell
+	}{
+		{
+			// one block from 2,3 to 5,6: inside 1, border 2, outside 3
+			"ATTR_BLK",
+			[]byte{sgbCommand(sgbATTRBLK), 0x01, 0x07, 0x39, 2, 3, 5, 6},
+			[]cell{{3, 4, 1}, {4, 5, 1}, {2, 3, 2}, {5, 4, 2}, {0, 0, 3}, {6, 6, 3}},
+		},
+		{
+			// row 4 with palette 1, then column 7 with palette 2
+			"ATTR_LIN",
+			[]byte{sgbCommand(sgbATTRLIN), 0x02, 0x80 | 1<<5 | 4, 2<<5 | 7},
+			[]cell{{0, 4, 1}, {19, 4, 1}, {7, 0, 2}, {7, 4, 2}, {0, 0, 0}},
+		},
+		{
+			// divided horizontally at row 9: 1 above, 3 on it and 2 below
+			"ATTR_DIV",
+			[]byte{sgbCommand(sgbATTRDIV), 0x40 | 3<<4 | 1<<2 | 2, 9},
+			[]cell{{0, 0, 1}, {19, 8, 1}, {5, 9, 3}, {0, 10, 2}, {19, 17, 2}},
+		},
+		{
+			// four cells from 18,0 left to right, wrapping to the next row
+			"ATTR_CHR",
+			[]byte{sgbCommand(sgbATTRCHR), 18, 0, 4, 0, 0, 0x6D},
+			[]cell{{18, 0, 1}, {19, 0, 2}, {0, 1, 3}, {1, 1, 1}, {2, 1, 0}},
+		},
+	}
+	for _, test := range tests {
+		loadSGB(t)
+		sendSGB(test.packet)
+		for _, cell := range test.cells {
+			if palette := SGB.attributes[cell.y][cell.x]; palette != cell.palette {
+				t.Errorf("SGB: %s: cell %d,%d uses palette %d, expected %d",
+					test.name, cell.x, cell.y, palette, cell.palette)
+			}
+		}
+	}
+}
+
+// TestSGBMask expects MASK_EN to black out the screen, fill it with colour 0
+// and freeze the last frame until the mask is cancelled
+func TestSGBMask(t *testing.T) {
+	loadSGB(t)
+	sendSGB([]byte{sgbCommand(sgbPAL01), 0x1F, 0x00, 0xE0, 0x03, 0x00, 0x7C, 0xFF, 0x7F})
+	var frame [ScreenHeight][ScreenWidth]uint16
+	frame[0][0] = 1
+	GPU.frameLock.Lock()
+	GPU.frame = frame
+	GPU.frameLock.Unlock()
+
+	tests := []struct {
+		name   string
+		mask   byte
+		colour uint16
+	}{
+		{"black", sgbMaskBlack, 0x0000},
+		{"colour 0", sgbMaskColour, 0x001F},
+		{"freeze", sgbMaskFreeze, 0x03E0},
+		{"cancel", sgbMaskCancel, 0x7C00},
+	}
+	for _, test := range tests {
+		sendSGB([]byte{sgbCommand(sgbMASKEN), test.mask})
+		// the game keeps drawing, a frozen screen keeps showing shade 1
+		frame[0][0] = 2
+		pixels, width, _ := SGB.Compose(&frame)
+		if pixel := pixelAt(pixels, width, 0, 0); !bytes.Equal(pixel, rgb15(test.colour)) {
+			t.Errorf("SGB: %s mask composed %v, expected colour %04X", test.name, pixel, test.colour)
+		}
+		frame[0][0] = 1
+	}
+}
+
+// TestSGBBorder transfers border tiles with CHR_TRN and the border map and
+// palettes with PCT_TRN through VRAM, expecting the border around the screen
+func TestSGBBorder(t *testing.T) {
+	loadSGB(t)
+	Settings.SGBBorder = true
+	// transfer places 4KB of tile data in VRAM, shown through the first 256 map entries
+	transfer := func(data []byte) {
+		copy(vRAM[:0x1000], data)
+		for i := 0; i < 0x100; i++ {
+			vRAM[0x1800+(i/20)*32+i%20] = byte(i)
+		}
+		SGB.VBlank()
+	}
+
+	// SNES 4bpp tile 1 with every pixel colour 1
+	tiles := make([]byte, 0x1000)
+	for row := 0; row < 8; row++ {
+		tiles[32+row*2] = 0xFF
+	}
+	sendSGB([]byte{sgbCommand(sgbCHRTRN), 0x00})
+	transfer(tiles)
+
+	// every map entry shows tile 1 with palette 4, whose colour 1 is blue
+	border := make([]byte, 0x1000)
+	for i := 0; i < 32*28; i++ {
+		border[i*2], border[i*2+1] = 0x01, 4<<2
+	}
+	border[0x802], border[0x803] = 0x00, 0x7C
+	sendSGB([]byte{sgbCommand(sgbPCTTRN)})
+	transfer(border)
+
+	var frame [ScreenHeight][ScreenWidth]uint16
+	pixels, width, height := SGB.Compose(&frame)
+	if width != SGBBorderWidth || height != SGBBorderHeight {
+		t.Fatalf("SGB: composed %dx%d with a border, expected %dx%d", width, height, SGBBorderWidth, SGBBorderHeight)
+	}
+	for _, pixel := range []struct {
+		x, y   int
+		colour uint16
+	}{
+		{0, 0, 0x7C00},
+		{255, 223, 0x7C00},
+		{sgbScreenX - 1, sgbScreenY, 0x7C00},
+		{sgbScreenX, sgbScreenY, SGB.palettes[0][0]},
+	} {
+		if rgb := pixelAt(pixels, width, pixel.x, pixel.y); !bytes.Equal(rgb, rgb15(pixel.colour)) {
+			t.Errorf("SGB: pixel %d,%d is %v, expected colour %04X", pixel.x, pixel.y, rgb, pixel.colour)
+		}
+	}
+
+	Settings.SGBBorder = false
+	if _, width, _ := SGB.Compose(&frame); width != ScreenWidth {
+		t.Errorf("SGB: composed %d pixels wide with the border disabled, expected %d", width, ScreenWidth)
+	}
+}
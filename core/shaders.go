@@ -0,0 +1,154 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// ShaderPresetDefault is the name of the preset shipped in the shaders/ directory
+const ShaderPresetDefault = "default"
+
+// ShaderPresetBuiltin is the name of the preset compiled into the binary,
+// used whenever a preset from disk is missing or fails to build
+const ShaderPresetBuiltin = "builtin"
+
+const shaderVertexFile = "vertex.glsl"
+const shaderFragmentFile = "fragment.glsl"
+
+// ShaderDirs are the directories searched for shader presets, in order.
+// A directory holding vertex.glsl and fragment.glsl is a preset itself,
+// and every sub-directory holding both files is a preset named after it.
+var ShaderDirs = []string{
+	"shaders",
+	path.Join(UserHome, ".freemegb", "shaders"),
+}
+
+// ShaderPresetType is the structure that describes a vertex/fragment shader pair on disk
+type ShaderPresetType struct {
+	Name         string
+	VertexPath   string
+	FragmentPath string
+}
+
+// ModTime returns the latest modification time of the preset's shader files
+func (preset *ShaderPresetType) ModTime() time.Time {
+	var latest time.Time
+	for _, file := range []string{preset.VertexPath, preset.FragmentPath} {
+		info, err := os.Stat(file)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Sources reads the vertex and fragment shader sources of the preset
+func (preset *ShaderPresetType) Sources() (string, string, error) {
+	if preset.Name == ShaderPresetBuiltin {
+		return VertexSource, FragmentSource, nil
+	}
+	vertex, err := ioutil.ReadFile(preset.VertexPath)
+	if err != nil {
+		return "", "", err
+	}
+	fragment, err := ioutil.ReadFile(preset.FragmentPath)
+	if err != nil {
+		return "", "", err
+	}
+	return string(vertex), string(fragment), nil
+}
+
+// FindShaderPresets scans ShaderDirs and returns every preset found,
+// the builtin preset first. Presets in later directories override
+// earlier ones of the same name.
+func FindShaderPresets() []ShaderPresetType {
+	found := map[string]ShaderPresetType{}
+	for i, dir := range ShaderDirs {
+		name := ShaderPresetDefault
+		if i > 0 {
+			name = "user"
+		}
+		if preset, ok := shaderPresetAt(name, dir); ok {
+			found[name] = preset
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			if preset, ok := shaderPresetAt(entry.Name(), path.Join(dir, entry.Name())); ok {
+				found[entry.Name()] = preset
+			}
+		}
+	}
+
+	presets := []ShaderPresetType{{Name: ShaderPresetBuiltin}}
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		presets = append(presets, found[name])
+	}
+	return presets
+}
+
+// FindShaderPreset returns the preset with the given name, or the builtin preset
+func FindShaderPreset(name string) ShaderPresetType {
+	for _, preset := range FindShaderPresets() {
+		if preset.Name == name {
+			return preset
+		}
+	}
+	return ShaderPresetType{Name: ShaderPresetBuiltin}
+}
+
+func shaderPresetAt(name string, dir string) (ShaderPresetType, bool) {
+	preset := ShaderPresetType{
+		Name:         name,
+		VertexPath:   path.Join(dir, shaderVertexFile),
+		FragmentPath: path.Join(dir, shaderFragmentFile),
+	}
+	if _, err := os.Stat(preset.VertexPath); err != nil {
+		return preset, false
+	}
+	if _, err := os.Stat(preset.FragmentPath); err != nil {
+		return preset, false
+	}
+	return preset, true
+}
+
+// WatchShaders polls the selected preset's files and calls onChange
+// whenever they are modified or another preset is selected.
+// Closing stop ends the watch.
+func WatchShaders(stop chan bool, onChange func()) {
+	name := Settings.Shader
+	preset := FindShaderPreset(name)
+	modified := preset.ModTime()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if Settings.Shader != name {
+				name = Settings.Shader
+				preset = FindShaderPreset(name)
+				modified = preset.ModTime()
+				onChange()
+			} else if latest := preset.ModTime(); latest.After(modified) {
+				modified = latest
+				Logger.Logf(LogTypes.INFO, "SHADER: %s changed on disk, reloading\n", preset.Name)
+				onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestShaderPresets finds presets in two shader directories, the second
+// overriding the first, and falls back to the builtin preset
+func TestShaderPresets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Shaders: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	shaderDirs := ShaderDirs
+	ShaderDirs = []string{filepath.Join(dir, "shaders"), filepath.Join(dir, "home")}
+	defer func() { ShaderDirs = shaderDirs }()
+
+	files := map[string]string{
+		"shaders/vertex.glsl":        "default vertex",
+		"shaders/fragment.glsl":      "default fragment",
+		"shaders/crt/vertex.glsl":    "crt vertex",
+		"shaders/crt/fragment.glsl":  "crt fragment",
+		"shaders/broken/vertex.glsl": "no fragment shader",
+		"home/vertex.glsl":           "user vertex",
+		"home/fragment.glsl":         "user fragment",
+		"home/crt/vertex.glsl":       "home crt vertex",
+		"home/crt/fragment.glsl":     "home crt fragment",
+	}
+	for name, source := range files {
+		location := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+			t.Fatalf("Shaders: %v", err)
+		}
+		if err := ioutil.WriteFile(location, []byte(source), 0644); err != nil {
+			t.Fatalf("Shaders: %v", err)
+		}
+	}
+
+	var names []string
+	for _, preset := range FindShaderPresets() {
+		names = append(names, preset.Name)
+	}
+	expected := []string{ShaderPresetBuiltin, "crt", ShaderPresetDefault, "user"}
+	if len(names) != len(expected) {
+		t.Fatalf("Shaders: found %v, expected %v", names, expected)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("Shaders: found %v, expected %v", names, expected)
+		}
+	}
+
+	for _, test := range []struct {
+		name, preset, vertex, fragment string
+	}{
+		{"crt", "crt", "home crt vertex", "home crt fragment"},
+		{ShaderPresetDefault, ShaderPresetDefault, "default vertex", "default fragment"},
+		{"broken", ShaderPresetBuiltin, VertexSource, FragmentSource},
+		{"missing", ShaderPresetBuiltin, VertexSource, FragmentSource},
+	} {
+		preset := FindShaderPreset(test.name)
+		if preset.Name != test.preset {
+			t.Errorf("Shaders: %q found %q, expected %q", test.name, preset.Name, test.preset)
+			continue
+		}
+		vertex, fragment, err := preset.Sources()
+		if err != nil {
+			t.Errorf("Shaders: %q: %v", test.name, err)
+		} else if vertex != test.vertex || fragment != test.fragment {
+			t.Errorf("Shaders: %q read %q and %q, expected %q and %q",
+				test.name, vertex, fragment, test.vertex, test.fragment)
+		}
+	}
+}
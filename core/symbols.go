@@ -0,0 +1,206 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol is a label from a symbol file
+type Symbol struct {
+	Name    string
+	Bank    int
+	Address uint16
+}
+
+// SymbolTable holds the labels of a ROM, read from a .sym file written by
+// RGBDS (rgblink -n) or no$gmb, one "bank:address label" per line:
+//
+//	00:0150 Start
+//	00:0153 Start.loop
+//
+// Local labels are named after their parent, as RGBDS writes them.
+type SymbolTable struct {
+	// symbols are sorted by address
+	symbols []Symbol
+	byName  map[string]Symbol
+}
+
+// Symbols is the exported object used in the system
+//
+// Symbols is exported so the debugger can show labels and find them by name
+var Symbols = ParseSymbols(nil)
+
+// ParseSymbols reads the labels of a symbol file, skipping comments and lines
+// that are not labels
+func ParseSymbols(data []byte) *SymbolTable {
+	table := &SymbolTable{byName: map[string]Symbol{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		location := strings.SplitN(fields[0], ":", 2)
+		if len(location) != 2 {
+			continue
+		}
+		bank, err := strconv.ParseUint(location[0], 16, 16)
+		if err != nil {
+			continue
+		}
+		address, err := strconv.ParseUint(location[1], 16, 16)
+		if err != nil {
+			continue
+		}
+		symbol := Symbol{Name: fields[1], Bank: int(bank), Address: uint16(address)}
+		table.symbols = append(table.symbols, symbol)
+		table.byName[symbol.Name] = symbol
+	}
+	sort.SliceStable(table.symbols, func(i, j int) bool {
+		return table.symbols[i].Address < table.symbols[j].Address
+	})
+	return table
+}
+
+// ReadSymbols reads a symbol file
+func ReadSymbols(location string) (*SymbolTable, error) {
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSymbols(data), nil
+}
+
+// SymbolsLocation returns the .sym file beside a ROM, or beside the archive it
+// was read from named after the entry, "" when there is none
+func SymbolsLocation(location, entry string) string {
+	candidates := []string{strings.TrimSuffix(location, filepath.Ext(location)) + ".sym"}
+	if entry != "" {
+		name := path.Base(entry)
+		candidates = append(candidates, filepath.Join(filepath.Dir(location),
+			strings.TrimSuffix(name, path.Ext(name))+".sym"))
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// LoadSymbols replaces Symbols with the symbol file beside a ROM, or with no
+// labels when there is none
+func LoadSymbols(location, entry string) {
+	Symbols = ParseSymbols(nil)
+	symbols := SymbolsLocation(location, entry)
+	if symbols == "" {
+		return
+	}
+	table, err := ReadSymbols(symbols)
+	if err != nil {
+		Logger.Log(LogTypes.ERROR, "SYMBOLS: Error loading", err)
+		return
+	}
+	Symbols = table
+	Logger.Logf(LogTypes.INFO, "SYMBOLS: %d labels in %s\n", table.Len(), symbols)
+}
+
+// Len returns the number of labels
+func (table *SymbolTable) Len() int {
+	return len(table.symbols)
+}
+
+// Lookup finds a label by name
+func (table *SymbolTable) Lookup(name string) (Symbol, bool) {
+	symbol, ok := table.byName[name]
+	return symbol, ok
+}
+
+// At returns the labels at an address of a ROM bank, bank 0 for
+// addresses outside 0x4000-0x7FFF
+func (table *SymbolTable) At(bank int, address uint16) []Symbol {
+	var symbols []Symbol
+	i := sort.Search(len(table.symbols), func(i int) bool {
+		return table.symbols[i].Address >= address
+	})
+	for ; i < len(table.symbols) && table.symbols[i].Address == address; i++ {
+		if address < 0x4000 || address > 0x7FFF || table.symbols[i].Bank == bank {
+			symbols = append(symbols, table.symbols[i])
+		}
+	}
+	return symbols
+}
+
+// Resolve finds the address of a label mapped into memory, or parses a
+// hexadecimal address written as 0x0150, $0150 or 0150
+func (table *SymbolTable) Resolve(name string) (uint16, bool) {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ":")
+	if symbol, ok := table.Lookup(name); ok {
+		// a label in a bank the CPU cannot see would resolve to another bank's code
+		return symbol.Address, symbolMapped(symbol)
+	}
+	digits := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(name, "0x"), "0X"), "$")
+	address, err := strconv.ParseUint(digits, 16, 16)
+	return uint16(address), err == nil && digits != ""
+}
+
+// Nearest finds the label at or before an address, in the same memory region
+// and mapped into it
+func (table *SymbolTable) Nearest(address uint16) (Symbol, bool) {
+	i := sort.Search(len(table.symbols), func(i int) bool {
+		return table.symbols[i].Address > address
+	})
+	for i--; i >= 0; i-- {
+		symbol := table.symbols[i]
+		if symbolRegion(symbol.Address) != symbolRegion(address) {
+			break
+		}
+		if symbolMapped(symbol) {
+			return symbol, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// Label names an address as label or label+offset, "" when no label is near
+func (table *SymbolTable) Label(address uint16) string {
+	symbol, ok := table.Nearest(address)
+	if !ok {
+		return ""
+	}
+	if symbol.Address == address {
+		return symbol.Name
+	}
+	return fmt.Sprintf("%s+0x%X", symbol.Name, address-symbol.Address)
+}
+
+// symbolRegions are the starts of the memory regions, a label never names an
+// address in the next one
+var symbolRegions = []uint16{0x4000, 0x8000, 0xA000, 0xC000, 0xE000, 0xFE00, 0xFF00, 0xFF80}
+
+func symbolRegion(address uint16) int {
+	return sort.Search(len(symbolRegions), func(i int) bool {
+		return symbolRegions[i] > address
+	})
+}
+
+// symbolMapped reports whether a label is in the memory the CPU sees, only
+// bank 1 is mapped at 0x4000-0x7FFF without a memory bank controller
+func symbolMapped(symbol Symbol) bool {
+	if symbol.Address >= 0x4000 && symbol.Address <= 0x7FFF {
+		return symbol.Bank <= 1
+	}
+	return true
+}
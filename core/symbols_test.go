@@ -0,0 +1,104 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSymbols = `; File generated by rgblink
+00:0150 Start
+00:0153 Start.loop
+01:4000 Bank1
+02:4000 Bank2
+00:c000 wBuffer
+not a label
+zz:0150 Broken
+`
+
+// TestSymbols names addresses after the nearest label in their region and bank
+func TestSymbols(t *testing.T) {
+	table := ParseSymbols([]byte(testSymbols))
+	if table.Len() != 5 {
+		t.Fatalf("Symbols: read %d labels, expected 5", table.Len())
+	}
+	labels := map[uint16]string{
+		0x0100: "",
+		0x0150: "Start",
+		0x0154: "Start.loop+0x1",
+		0x3FFF: "Start.loop+0x3EAC",
+		0x4002: "Bank1+0x2",
+		0x8000: "",
+		0xC010: "wBuffer+0x10",
+		0xE000: "",
+	}
+	for address, expected := range labels {
+		if label := table.Label(address); label != expected {
+			t.Errorf("Symbols: 0x%04X is %q, expected %q", address, label, expected)
+		}
+	}
+
+	resolved := map[string]int{
+		"Start.loop": 0x153,
+		"Bank1":      0x4000,
+		"Bank2":      -1,
+		"Start:":     0x150,
+		"0x0200":     0x200,
+		"$C000":      0xC000,
+		"ff80":       0xFF80,
+		"Missing":    -1,
+		"0x10000":    -1,
+		"$":          -1,
+	}
+	for name, expected := range resolved {
+		address, ok := table.Resolve(name)
+		if expected < 0 && ok || expected >= 0 && (!ok || int(address) != expected) {
+			t.Errorf("Symbols: %q resolved to 0x%04X %v, expected 0x%04X", name, address, ok, expected)
+		}
+	}
+
+	if symbols := table.At(2, 0x4000); len(symbols) != 1 || symbols[0].Name != "Bank2" {
+		t.Errorf("Symbols: bank 2 at 0x4000 has %v, expected Bank2", symbols)
+	}
+}
+
+// TestSymbolsBesideROM loads the symbols beside a ROM and shows them in the disassembly
+func TestSymbolsBesideROM(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	defer func() { Symbols = ParseSymbols(nil) }()
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Symbols: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if SymbolsLocation(filepath.Join(dir, "game.gb"), "") != "" {
+		t.Errorf("Symbols: found a symbol file where there is none")
+	}
+	location := filepath.Join(dir, "game.sym")
+	if err := ioutil.WriteFile(location, []byte(testSymbols), 0644); err != nil {
+		t.Fatalf("Symbols: %v", err)
+	}
+	if found := SymbolsLocation(filepath.Join(dir, "game.gb"), ""); found != location {
+		t.Errorf("Symbols: found %q beside the ROM, expected %q", found, location)
+	}
+	if found := SymbolsLocation(filepath.Join(dir, "games.zip"), "roms/game.gb"); found != location {
+		t.Errorf("Symbols: found %q beside the archive, expected %q", found, location)
+	}
+
+	LoadSymbols(filepath.Join(dir, "game.gb"), "")
+	ROM.BuildModel()
+	for i, row := range ROM.model {
+		if row.([]string)[0] != "0x0150" {
+			continue
+		}
+		if i < 1 || ROM.model[i-1].([]string)[0] != "Start:" {
+			t.Errorf("Symbols: no Start: row before 0x0150")
+		}
+		if ROM.model[i+1].([]string)[0] != "Start.loop:" || ROM.model[i+2].([]string)[0] != "0x0153" {
+			t.Errorf("Symbols: no Start.loop: row before 0x0153")
+		}
+		return
+	}
+	t.Errorf("Symbols: 0x0150 missing from the disassembly")
+}
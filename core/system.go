@@ -1,8 +1,6 @@
 package core
 
 import (
-	"io/ioutil"
-
 	"fmt"
 	"time"
 
@@ -32,61 +30,120 @@ type SystemType struct {
 	ROM: &ROM,
 }
 
-func (system *SystemType) LoadROM(location string, romListStore *gtk.ListStore,
-	romTreeView *gtk.TreeView, romProgressBar *gtk.ProgressBar,
-	menuDebug *gtk.MenuItem, menuRun *gtk.MenuItem) {
-	Logger.Log(LogTypes.INFO, "ROM: Loading")
-	before := time.Now()
-	rom, err := ioutil.ReadFile(location)
-	if err == nil {
-		ROM.data = rom
-		ROM.BuildModel()
+// Hardware models as stored in Settings.Model
+const (
+	ModelAuto = "auto"
+	ModelDMG  = "dmg"
+	ModelMGB  = "mgb"
+	ModelCGB  = "cgb"
+	ModelSGB  = "sgb"
+)
 
-		ROM.romName = ROM.GetName()
-		Logger.Log(LogTypes.INFO, "Found ROM: "+ROM.romName)
+// SelectModel resolves the hardware model for the loaded ROM from Settings
+// and the header's CGB and SGB flags, and enables MGB, CGB or SGB mode accordingly
+func (system *SystemType) SelectModel() string {
+	model := Settings.Model
+	if override, ok := Settings.ROMModels[ROM.GetKey()]; ok {
+		model = override
+	}
+	if model != ModelDMG && model != ModelMGB && model != ModelCGB && model != ModelSGB {
+		model = ModelDMG
+		if ROM.IsCGB() {
+			model = ModelCGB
+		} else if ROM.IsSGB() {
+			model = ModelSGB
+		}
+	}
+	MGB = model == ModelMGB
+	CGB.Enabled = model == ModelCGB
+	SGB.Enabled = model == ModelSGB
+	Logger.Log(LogTypes.INFO, "HARDWARE MODEL: "+model)
+	return model
+}
+
+// OpenROM reads and verifies a ROM file and selects the palette and hardware model for it.
+// For a zip archive entry names the ROM inside it, "" loads the first one.
+// A ROM that fails to load is returned as a *ROMError and leaves the current ROM in place.
+func (system *SystemType) OpenROM(location string, entry string) error {
+	rom, err := ReadROMFile(location, entry)
+	symbols := Symbols
+	if err != nil {
+		err = &ROMError{Err: err}
+	} else {
+		// loaded first, the disassembly built by Load shows the labels
+		LoadSymbols(location, entry)
+		err = ROM.Load(rom)
+	}
+	if err != nil {
+		Symbols = symbols
+		err.(*ROMError).Location = location
+		Logger.Log(LogTypes.ERROR, "ROM: Error loading", err)
+		return err
+	}
 
-		ROM.romType = ROM.GetType()
-		Logger.Log(LogTypes.INFO, "ROM TYPE: "+ROM.romType)
+	for _, issue := range ROM.header.CompatibilityCheck(len(rom)) {
+		if issue.Fatal {
+			Logger.Log(LogTypes.ERROR, "COMPATIBILITY: "+issue.Message)
+		} else {
+			Logger.Log(LogTypes.WARNING, "COMPATIBILITY: "+issue.Message)
+		}
+	}
 
-		ROM.romSize = ROM.GetROMSize()
-		Logger.Log(LogTypes.INFO, "ROM SIZE: "+fmt.Sprintf("%dKB", ROM.romSize))
+	Logger.Log(LogTypes.INFO, "Found ROM: "+ROM.romName)
+	Logger.Log(LogTypes.INFO, "ROM TYPE: "+ROM.romType)
+	Logger.Log(LogTypes.INFO, "ROM SIZE: "+fmt.Sprintf("%dKB", ROM.romSize))
+	Logger.Log(LogTypes.INFO, "ROM RAM SIZE: "+fmt.Sprintf("%dKB", ROM.romRAMSize))
 
-		ROM.romRAMSize = ROM.GetRAMSize()
-		Logger.Log(LogTypes.INFO, "ROM RAM SIZE: "+fmt.Sprintf("%dKB", ROM.romRAMSize))
+	system.GPU.SetPalette(FindPalette(ROMPalette()))
+	system.SelectModel()
+	ROMref = ROM.data
+	Rewind.Clear()
+	return nil
+}
 
-		menuDebug.SetSensitive(false)
-		menuRun.SetSensitive(false)
-		romTreeView.SetModel(nil)
-		romListStore.Clear()
-		romModelLength := len(ROM.model)
-		percentStep := int(float64(0.01) * float64(romModelLength))
-		for i := 0; i < romModelLength; i++ {
-			row := ROM.model[i].([]string)
-			iter := romListStore.Append()
-			err := romListStore.Set(iter,
-				[]int{0, 1},
-				[]interface{}{row[0], row[1]})
-			if err != nil {
-				Logger.Log(LogTypes.ERROR, err)
-			}
-			if i%percentStep == 0 {
-				romProgressBar.SetFraction(float64(i) / float64(romModelLength))
-			} else if i == romModelLength-1 {
-				romProgressBar.SetFraction(1)
-			}
-		}
-		romTreeView.SetModel(romListStore)
-		ROMref = ROM.data
-	} else {
-		Logger.Log(LogTypes.ERROR, "ROM: Error loading")
+// LoadROM opens a ROM file, fills the ROM view and resets the CPU.
+// Errors are those of OpenROM.
+func (system *SystemType) LoadROM(location string, entry string, romListStore *gtk.ListStore,
+	romTreeView *gtk.TreeView, romProgressBar *gtk.ProgressBar,
+	menuDebug *gtk.MenuItem, menuRun *gtk.MenuItem) error {
+	Logger.Log(LogTypes.INFO, "ROM: Loading")
+	before := time.Now()
+	if err := system.OpenROM(location, entry); err != nil {
 		after := time.Now()
 		Logger.Log(LogTypes.INFO, after.Sub(before))
-		return
+		return err
+	}
+
+	menuDebug.SetSensitive(false)
+	menuRun.SetSensitive(false)
+	romTreeView.SetModel(nil)
+	romListStore.Clear()
+	romModelLength := len(ROM.model)
+	percentStep := int(float64(0.01) * float64(romModelLength))
+	if percentStep == 0 {
+		percentStep = 1
+	}
+	for i := 0; i < romModelLength; i++ {
+		row := ROM.model[i].([]string)
+		iter := romListStore.Append()
+		err := romListStore.Set(iter,
+			[]int{0, 1},
+			[]interface{}{row[0], row[1]})
+		if err != nil {
+			Logger.Log(LogTypes.ERROR, err)
+		}
+		if i%percentStep == 0 {
+			romProgressBar.SetFraction(float64(i) / float64(romModelLength))
+		} else if i == romModelLength-1 {
+			romProgressBar.SetFraction(1)
+		}
 	}
+	romTreeView.SetModel(romListStore)
 	after := time.Now()
 	Logger.Logf(LogTypes.COMPLETED, "ROM: %s Loaded in %.2fs", ROM.romName, after.Sub(before).Seconds())
 	menuDebug.SetSensitive(true)
 	menuRun.SetSensitive(true)
 	system.CPU.Reset()
 	Logger.Logf(LogTypes.INFO, "CPU Initialized")
+	return nil
 }
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+)
+
+// TestROMDirEnv names the directory holding the test ROMs, testdata/roms by default.
+//
+// ROMs are recognised by their path:
+//
+//	blargg/...   pass or fail from the text written to the serial port
+//	mooneye/...  pass or fail from the registers at the LD B, B breakpoint
+//	*acid2*      compared with the reference screenshot next to the ROM, named like it with .png
+const TestROMDirEnv = "FREEMEGB_TEST_ROMS"
+
+// test ROM detection methods and how many frames each may run
+const (
+	testROMSerial     = "serial"
+	testROMFibonacci  = "fibonacci"
+	testROMScreenshot = "screenshot"
+)
+
+var testROMFrames = map[string]int{
+	testROMSerial:     4000,
+	testROMFibonacci:  600,
+	testROMScreenshot: 60,
+}
+
+// mooneyePass are B, C, D, E, H and L when a Mooneye test passes, they are all 0x42 on failure
+var mooneyePass = [6]byte{3, 5, 8, 13, 21, 34}
+
+type testROMResult struct {
+	name   string
+	method string
+	passed bool
+	detail string
+}
+
+// TestROMs runs the test ROMs found in the test ROM directory and prints a compatibility table
+func TestROMs(t *testing.T) {
+	dir := os.Getenv(TestROMDirEnv)
+	if dir == "" {
+		dir = filepath.Join("testdata", "roms")
+	}
+	roms := findTestROMs(dir)
+	if len(roms) == 0 {
+		t.Skipf("no test ROMs in %s, set %s to run them", dir, TestROMDirEnv)
+	}
+
+	Logger.Quiet = true
+	defer func() { Logger.Quiet = false }()
+
+	var results []testROMResult
+	for _, location := range roms {
+		name, _ := filepath.Rel(dir, location)
+		result := runTestROM(location)
+		result.name = filepath.ToSlash(name)
+		results = append(results, result)
+		t.Run(result.name, func(t *testing.T) {
+			if !result.passed {
+				t.Errorf("%s: %s", result.method, result.detail)
+			}
+		})
+	}
+	printCompatibility(results)
+}
+
+// findTestROMs lists the ROMs under dir that have a detection method, sorted by path
+func findTestROMs(dir string) []string {
+	var roms []string
+	filepath.Walk(dir, func(location string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && hasExtension(location, ROMExtensions) && testROMMethod(location) != "" {
+			roms = append(roms, location)
+		}
+		return nil
+	})
+	sort.Strings(roms)
+	return roms
+}
+
+func testROMMethod(location string) string {
+	location = strings.ToLower(filepath.ToSlash(location))
+	switch {
+	case strings.Contains(location, "acid2"):
+		return testROMScreenshot
+	case strings.Contains(location, "blargg/"):
+		return testROMSerial
+	case strings.Contains(location, "mooneye/"):
+		return testROMFibonacci
+	}
+	return ""
+}
+
+// runTestROM loads and runs a test ROM, judging it by its detection method
+func runTestROM(location string) testROMResult {
+	result := testROMResult{method: testROMMethod(location)}
+	if err := System.OpenROM(location, ""); err != nil {
+		result.detail = err.Error()
+		return result
+	}
+	if result.method == testROMScreenshot {
+		// the reference screenshots use the grey palette
+		GPU.SetPalette(Palettes[0])
+	}
+	CPU.Reset()
+	Serial.Peer = &SerialPrinter
+
+	var stop func(InstructionType) bool
+	switch result.method {
+	case testROMSerial:
+		lastCheck := uint64(0)
+		stop = func(instruction InstructionType) bool {
+			if CPU.CYCLES-lastCheck < FrameCycles {
+				return false
+			}
+			lastCheck = CPU.CYCLES
+			output := SerialPrinter.Output()
+			return strings.Contains(output, "Passed") || strings.Contains(output, "Failed")
+		}
+	case testROMFibonacci:
+		stop = func(instruction InstructionType) bool {
+			// Mooneye tests signal completion with LD B, B
+			return instruction.Opcode == 0x40
+		}
+	}
+	stopped, err := System.runFrames(testROMFrames[result.method], stop)
+	if err != nil {
+		result.detail = err.Error()
+		return result
+	}
+
+	switch result.method {
+	case testROMSerial:
+		output := strings.TrimSpace(SerialPrinter.Output())
+		result.passed = strings.Contains(output, "Passed")
+		result.detail = lastLine(output)
+		if !stopped {
+			result.detail = "timed out, serial output: " + result.detail
+		}
+	case testROMFibonacci:
+		if !stopped {
+			result.detail = "timed out before LD B, B"
+			break
+		}
+		registers := CPU.REGISTERS
+		got := [6]byte{byte(registers.BC >> 8), byte(registers.BC), byte(registers.DE >> 8),
+			byte(registers.DE), byte(registers.HL >> 8), byte(registers.HL)}
+		result.passed = got == mooneyePass
+		result.detail = fmt.Sprintf("B C D E H L = % X", got[:])
+	case testROMScreenshot:
+		pixels, width, height := GPU.Frame()
+		expected, err := referenceScreenshot(strings.TrimSuffix(location, filepath.Ext(location)) + ".png")
+		if err != nil {
+			result.detail = err.Error()
+			break
+		}
+		got := screenshotHash(pixels, width, height)
+		result.passed = got == expected
+		result.detail = fmt.Sprintf("screenshot %s, reference %s", got, expected)
+	}
+	return result
+}
+
+// screenshotHash hashes the RGB values of a frame of packed RGBA pixels
+func screenshotHash(pixels []byte, width int, height int) string {
+	hash := sha1.New()
+	for i := 0; i+3 < len(pixels) && i < width*height*4; i += 4 {
+		hash.Write(pixels[i : i+3])
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// referenceScreenshot hashes a reference PNG the same way as screenshotHash
+func referenceScreenshot(location string) (string, error) {
+	file, err := os.Open(location)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	picture, err := png.Decode(file)
+	if err != nil {
+		return "", err
+	}
+	bounds := picture.Bounds()
+	rgba := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			rgba.Set(x, y, picture.At(x, y))
+		}
+	}
+	return screenshotHash(rgba.Pix, bounds.Dx(), bounds.Dy()), nil
+}
+
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// printCompatibility prints the results as a table with a pass count
+func printCompatibility(results []testROMResult) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ROM\tMETHOD\tRESULT\tDETAIL")
+	passed := 0
+	for _, result := range results {
+		status := "FAIL"
+		if result.passed {
+			status = "PASS"
+			passed++
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.name, result.method, status, result.detail)
+	}
+	table.Flush()
+	fmt.Printf("%d/%d test ROMs passed\n", passed, len(results))
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+)
+
+// Errors returned when tracing cannot start
+var (
+	ErrTraceRunning = errors.New("a trace is already being written")
+)
+
+// traceBufferSize is the buffer between the trace and its file, traces run to
+// millions of lines so they are written in large blocks
+const traceBufferSize = 1 << 20
+
+// TraceType writes the state before each instruction to a file, one line each,
+// in the Gameboy Doctor format so it can be compared with other emulators:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// With Cycles set the cycle counter is appended as CY:<decimal>, which the
+// Gameboy Doctor ignores but other tools compare.
+type TraceType struct {
+	// Cycles appends the cycle counter to each line
+	Cycles bool
+	// StubLY makes LY read 0x90, as Gameboy Doctor logs are made with it
+	StubLY bool
+
+	file   *os.File
+	writer *bufio.Writer
+	line   []byte
+}
+
+// Trace is the exported object used in the system
+//
+// Trace is exported so the UI and the headless runner can start tracing
+var Trace = TraceType{}
+
+// Start creates the trace file and traces every instruction from now on
+func (trace *TraceType) Start(location string) error {
+	if trace.file != nil {
+		return ErrTraceRunning
+	}
+	file, err := os.Create(location)
+	if err != nil {
+		return err
+	}
+	trace.file = file
+	trace.writer = bufio.NewWriterSize(file, traceBufferSize)
+	Logger.Log(LogTypes.INFO, "TRACE: writing "+location)
+	return nil
+}
+
+// Enabled reports whether a trace is being written
+func (trace *TraceType) Enabled() bool {
+	return trace.file != nil
+}
+
+// Stop flushes and closes the trace file
+func (trace *TraceType) Stop() error {
+	if trace.file == nil {
+		return nil
+	}
+	err := trace.writer.Flush()
+	if closeErr := trace.file.Close(); err == nil {
+		err = closeErr
+	}
+	trace.file = nil
+	trace.writer = nil
+	return err
+}
+
+// Record writes the line for the instruction at PC, before it executes
+func (trace *TraceType) Record() {
+	if trace.file == nil {
+		return
+	}
+	registers := CPU.REGISTERS
+	line := trace.line[:0]
+	line = appendTraceByte(line, "A:", byte(registers.AF>>8))
+	line = appendTraceByte(line, " F:", byte(registers.AF))
+	line = appendTraceByte(line, " B:", byte(registers.BC>>8))
+	line = appendTraceByte(line, " C:", byte(registers.BC))
+	line = appendTraceByte(line, " D:", byte(registers.DE>>8))
+	line = appendTraceByte(line, " E:", byte(registers.DE))
+	line = appendTraceByte(line, " H:", byte(registers.HL>>8))
+	line = appendTraceByte(line, " L:", byte(registers.HL))
+	line = appendTraceByte(line, " SP:", byte(registers.SP>>8))
+	line = appendHex(line, byte(registers.SP))
+	line = appendTraceByte(line, " PC:", byte(registers.PC>>8))
+	line = appendHex(line, byte(registers.PC))
+	line = appendTraceByte(line, " PCMEM:", MMU.ReadByte(registers.PC))
+	for i := uint16(1); i < 4; i++ {
+		line = appendTraceByte(line, ",", MMU.ReadByte(registers.PC+i))
+	}
+	if trace.Cycles {
+		line = append(line, " CY:"...)
+		line = strconv.AppendUint(line, CPU.CYCLES, 10)
+	}
+	line = append(line, '\n')
+	trace.line = line
+	if _, err := trace.writer.Write(line); err != nil {
+		Logger.Log(LogTypes.ERROR, "TRACE: Error writing, stopping", err)
+		trace.Stop()
+	}
+}
+
+const hexDigits = "0123456789ABCDEF"
+
+func appendTraceByte(line []byte, label string, value byte) []byte {
+	return appendHex(append(line, label...), value)
+}
+
+func appendHex(line []byte, value byte) []byte {
+	return append(line, hexDigits[value>>4], hexDigits[value&0x0F])
+}
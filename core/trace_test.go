@@ -0,0 +1,54 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTrace traces the start of saveStateProgram in the Gameboy Doctor format
+func TestTrace(t *testing.T) {
+	loadProgram(t, saveStateProgram)
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, "trace.log")
+
+	if err := Trace.Start(location); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	instructions := 0
+	_, err = System.runFrames(1, func(InstructionType) bool {
+		instructions++
+		return instructions > 3
+	})
+	if stopErr := Trace.Stop(); err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	expected := []string{
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:18,4E,00,00",
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0150 PCMEM:21,00,80,22",
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:80 L:00 SP:FFFE PC:0153 PCMEM:22,3C,E0,43",
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Trace: %d lines, expected %d:\n%s", len(lines), len(expected), data)
+	}
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Errorf("Trace: line %d is\n%s\nexpected\n%s", i+1, line, expected[i])
+		}
+	}
+}
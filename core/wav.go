@@ -0,0 +1,87 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+)
+
+// wavHeaderSize is the size of the RIFF/WAVE header written before the samples
+const wavHeaderSize = 44
+
+// WAVSinkType is a SampleSink recording 16-bit stereo PCM to a WAV file,
+// used when running without a sound device
+type WAVSinkType struct {
+	file    *os.File
+	writer  *bufio.Writer
+	rate    int
+	written uint32
+}
+
+// NewWAVSink creates the WAV file at location, recording at the current APU sample rate
+func NewWAVSink(location string) (*WAVSinkType, error) {
+	file, err := os.Create(location)
+	if err != nil {
+		return nil, err
+	}
+	sink := &WAVSinkType{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		rate:   APU.SampleRate,
+	}
+	// the header is rewritten with the final sizes on Close
+	if err := sink.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *WAVSinkType) writeHeader() error {
+	header := make([]byte, wavHeaderSize)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 36+sink.written)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], 2) // channels
+	binary.LittleEndian.PutUint32(header[24:], uint32(sink.rate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sink.rate*4))
+	binary.LittleEndian.PutUint16(header[32:], 4)  // block align
+	binary.LittleEndian.PutUint16(header[34:], 16) // bits per sample
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], sink.written)
+	_, err := sink.writer.Write(header)
+	return err
+}
+
+// WriteSamples appends interleaved stereo samples to the file
+func (sink *WAVSinkType) WriteSamples(samples []int16) {
+	if err := binary.Write(sink.writer, binary.LittleEndian, samples); err != nil {
+		Logger.Log(LogTypes.ERROR, "WAV:", err)
+		return
+	}
+	sink.written += uint32(len(samples) * 2)
+}
+
+// Close writes the final sizes into the header and closes the file
+func (sink *WAVSinkType) Close() error {
+	if err := sink.writer.Flush(); err != nil {
+		sink.file.Close()
+		return err
+	}
+	if _, err := sink.file.Seek(0, 0); err != nil {
+		sink.file.Close()
+		return err
+	}
+	sink.writer.Reset(sink.file)
+	if err := sink.writeHeader(); err != nil {
+		sink.file.Close()
+		return err
+	}
+	if err := sink.writer.Flush(); err != nil {
+		sink.file.Close()
+		return err
+	}
+	return sink.file.Close()
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWAVSink records samples and expects a 16-bit stereo PCM file whose
+// header carries the final sizes
+func TestWAVSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freemegb")
+	if err != nil {
+		t.Fatalf("WAV: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, "test.wav")
+
+	rate := APU.SampleRate
+	APU.SampleRate = 32768
+	sink, err := NewWAVSink(location)
+	APU.SampleRate = rate
+	if err != nil {
+		t.Fatalf("WAV: %v", err)
+	}
+	samples := []int16{1, -1, 0x1234, -0x1234, 32767, -32768}
+	sink.WriteSamples(samples[:2])
+	sink.WriteSamples(samples[2:])
+	if err := sink.Close(); err != nil {
+		t.Fatalf("WAV: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		t.Fatalf("WAV: %v", err)
+	}
+	if len(data) != wavHeaderSize+len(samples)*2 {
+		t.Fatalf("WAV: file is %d bytes, expected %d", len(data), wavHeaderSize+len(samples)*2)
+	}
+	for _, field := range []struct {
+		name     string
+		offset   int
+		value    uint32
+		expected uint32
+	}{
+		{"RIFF size", 4, binary.LittleEndian.Uint32(data[4:]), 36 + 12},
+		{"format", 20, uint32(binary.LittleEndian.Uint16(data[20:])), 1},
+		{"channels", 22, uint32(binary.LittleEndian.Uint16(data[22:])), 2},
+		{"sample rate", 24, binary.LittleEndian.Uint32(data[24:]), 32768},
+		{"byte rate", 28, binary.LittleEndian.Uint32(data[28:]), 32768 * 4},
+		{"bits per sample", 34, uint32(binary.LittleEndian.Uint16(data[34:])), 16},
+		{"data size", 40, binary.LittleEndian.Uint32(data[40:]), 12},
+	} {
+		if field.value != field.expected {
+			t.Errorf("WAV: %s at %d is %d, expected %d", field.name, field.offset, field.value, field.expected)
+		}
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+		t.Errorf("WAV: missing RIFF, WAVEfmt or data tags")
+	}
+	for i, sample := range samples {
+		if value := int16(binary.LittleEndian.Uint16(data[wavHeaderSize+i*2:])); value != sample {
+			t.Errorf("WAV: sample %d is %d, expected %d", i, value, sample)
+		}
+	}
+}
@@ -5,14 +5,18 @@
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/ioncloud64/freemegb/core"
 
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
 )
@@ -43,6 +47,17 @@ func main() {
 	core.Init()
 	var System = core.System
 
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		code := RunCommand(&System, os.Args[2:])
+		core.LogFile.Close()
+		os.Exit(code)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dap" {
+		code := DAPCommand(&System, os.Args[2:])
+		core.LogFile.Close()
+		os.Exit(code)
+	}
+
 	defer core.LogFile.Close()
 
 	UI(&System)
@@ -67,6 +82,9 @@ func UI(System *core.SystemType) {
 		obj, err := builder.GetObject("MainWindow")
 		UIErrorCheck(err)
 
+		win, err := IsWindow(obj)
+		UIErrorCheck(err)
+
 		cssProvider, err := gtk.CssProviderNew()
 		cssProvider.LoadFromPath("ui/style.css")
 		UIErrorCheck(err)
@@ -95,14 +113,12 @@ func UI(System *core.SystemType) {
 			emulatorWindow, err := IsWindow(obj)
 			UIErrorCheck(err)
 
-			gtkglarea, err := b.GetObject("GLArea")
+			screenBoxObj, err := b.GetObject("ScreenBox")
 			UIErrorCheck(err)
 
-			glarea, err := IsGLArea(gtkglarea)
+			screenBox, err := IsBox(screenBoxObj)
 			UIErrorCheck(err)
 
-			glarea.SetRequiredVersion(4, 6)
-
 			registerTree, err := builder.GetObject("registerTreeStore")
 			UIErrorCheck(err)
 
@@ -119,9 +135,8 @@ func UI(System *core.SystemType) {
 
 			go System.CPU.Run(true, registerTreeStore, registerListStore)
 
-			glarea.Connect("realize", System.GPU.Init)
-			glarea.Connect("render", System.GPU.Run)
-			glarea.Connect("unrealize", System.GPU.Destroy)
+			core.AttachRenderer(screenBox)
+			ConnectEmulatorKeys(emulatorWindow)
 
 			emulatorWindow.Show()
 		})
@@ -145,6 +160,357 @@ func UI(System *core.SystemType) {
 			System.CPU.KEEP_STEP = true
 		})
 
+		// Reverse debugging, stopping at the instruction reached like a breakpoint
+		reverseRegisterTreeObj, err := builder.GetObject("registerTreeStore")
+		UIErrorCheck(err)
+
+		reverseRegisterTree, err := IsTreeView(reverseRegisterTreeObj)
+		UIErrorCheck(err)
+
+		reverseRegisterListObj, err := builder.GetObject("registerListStore")
+		UIErrorCheck(err)
+
+		reverseRegisterList, err := IsListStore(reverseRegisterListObj)
+		UIErrorCheck(err)
+
+		reverse := func(move func() bool) {
+			moved, movie := false, false
+			System.CPU.Call(func() {
+				// a movie would no longer match its inputs, as with save states and rewinding
+				if movie = core.Movie.Active(); movie {
+					return
+				}
+				System.CPU.DEBUG = true
+				System.CPU.STEP = true
+				System.CPU.KEEP_STEP = true
+				moved = move()
+			})
+			if movie {
+				core.Logger.Log(core.LogTypes.WARNING, "REVERSE: stop the movie before stepping back")
+				return
+			}
+			if !moved {
+				core.Logger.Log(core.LogTypes.WARNING, "REVERSE: reached the start of the recorded history")
+			}
+			System.CPU.REGISTERS.UpdateRegisterTable(reverseRegisterTree, reverseRegisterList)
+		}
+
+		menuDebugStepBackObj, err := builder.GetObject("menuDebugStepBack")
+		UIErrorCheck(err)
+
+		menuDebugStepBack, err := IsMenuItem(menuDebugStepBackObj)
+		UIErrorCheck(err)
+
+		menuDebugStepBack.Connect("activate", func() {
+			reverse(core.Reverse.StepBack)
+		})
+
+		menuDebugReverseContinueObj, err := builder.GetObject("menuDebugReverseContinue")
+		UIErrorCheck(err)
+
+		menuDebugReverseContinue, err := IsMenuItem(menuDebugReverseContinueObj)
+		UIErrorCheck(err)
+
+		menuDebugReverseContinue.Connect("activate", func() {
+			reverse(core.Reverse.ReverseContinue)
+		})
+
+		// Instruction trace in the Gameboy Doctor format
+		menuDebugTraceObj, err := builder.GetObject("menuDebugTrace")
+		UIErrorCheck(err)
+
+		menuDebugTrace, err := IsCheckMenuItem(menuDebugTraceObj)
+		UIErrorCheck(err)
+
+		menuDebugTrace.Connect("toggled", func() {
+			if !menuDebugTrace.GetActive() {
+				var err error
+				System.CPU.Call(func() {
+					err = core.Trace.Stop()
+				})
+				if err != nil {
+					core.Logger.Log(core.LogTypes.ERROR, "TRACE: Error closing", err)
+				}
+				return
+			}
+			if core.Trace.Enabled() {
+				return
+			}
+			dialog, err := gtk.FileChooserDialogNewWith2Buttons("Trace to File", win,
+				gtk.FILE_CHOOSER_ACTION_SAVE, "Cancel", gtk.RESPONSE_CANCEL, "Trace", gtk.RESPONSE_ACCEPT)
+			UIErrorCheck(err)
+			dialog.SetDoOverwriteConfirmation(true)
+			dialog.SetCurrentName("trace.log")
+			location := ""
+			if dialog.Run() == gtk.RESPONSE_ACCEPT {
+				location = dialog.GetFilename()
+			}
+			dialog.Destroy()
+
+			if location != "" {
+				System.CPU.Call(func() {
+					err = core.Trace.Start(location)
+				})
+				if err != nil {
+					core.Logger.Log(core.LogTypes.ERROR, "TRACE: Error starting", err)
+				}
+			}
+			if location == "" || err != nil {
+				menuDebugTrace.SetActive(false)
+			}
+		})
+
+		// Breakpoints by label or address, also toggled from the ROM view
+		var refreshBreakpoints func()
+		toggleBreakpoint := func(text string) {
+			address, ok := core.Symbols.Resolve(text)
+			if !ok {
+				core.Logger.Log(core.LogTypes.WARNING, "BREAKPOINT: unknown label or address", text)
+				return
+			}
+			System.CPU.Call(func() {
+				if System.CPU.BREAKPOINTS[address] {
+					delete(System.CPU.BREAKPOINTS, address)
+				} else {
+					System.CPU.BREAKPOINTS[address] = true
+				}
+			})
+			if refreshBreakpoints != nil {
+				refreshBreakpoints()
+			}
+		}
+
+		menuDebugBreakpointsObj, err := builder.GetObject("menuDebugBreakpoints")
+		UIErrorCheck(err)
+
+		menuDebugBreakpoints, err := IsMenuItem(menuDebugBreakpointsObj)
+		UIErrorCheck(err)
+
+		menuDebugBreakpoints.Connect("activate", func() {
+			b, err := gtk.BuilderNewFromFile("ui/BreakpointsWindow.glade")
+			UIErrorCheck(err)
+
+			obj, err := b.GetObject("BreakpointsWindow")
+			UIErrorCheck(err)
+
+			breakpointsWindow, err := IsWindow(obj)
+			UIErrorCheck(err)
+			breakpointsWindow.SetTransientFor(win)
+
+			breakpointListStoreObj, err := b.GetObject("breakpointListStore")
+			UIErrorCheck(err)
+
+			breakpointListStore, err := IsListStore(breakpointListStoreObj)
+			UIErrorCheck(err)
+
+			treeBreakpointsObj, err := b.GetObject("treeBreakpoints")
+			UIErrorCheck(err)
+
+			treeBreakpoints, err := IsTreeView(treeBreakpointsObj)
+			UIErrorCheck(err)
+
+			entryBreakpointObj, err := b.GetObject("entryBreakpoint")
+			UIErrorCheck(err)
+
+			entryBreakpoint, err := IsEntry(entryBreakpointObj)
+			UIErrorCheck(err)
+
+			buttonAddBreakpointObj, err := b.GetObject("buttonAddBreakpoint")
+			UIErrorCheck(err)
+
+			buttonAddBreakpoint, err := IsButton(buttonAddBreakpointObj)
+			UIErrorCheck(err)
+
+			buttonRemoveBreakpointObj, err := b.GetObject("buttonRemoveBreakpoint")
+			UIErrorCheck(err)
+
+			buttonRemoveBreakpoint, err := IsButton(buttonRemoveBreakpointObj)
+			UIErrorCheck(err)
+
+			// each breakpoint is listed with the label it is in
+			refresh := func() {
+				var addresses []int
+				System.CPU.Call(func() {
+					for address, enabled := range System.CPU.BREAKPOINTS {
+						if enabled {
+							addresses = append(addresses, int(address))
+						}
+					}
+				})
+				sort.Ints(addresses)
+				breakpointListStore.Clear()
+				for _, address := range addresses {
+					iter := breakpointListStore.Append()
+					err := breakpointListStore.Set(iter,
+						[]int{0, 1},
+						[]interface{}{fmt.Sprintf("0x%04X", address), core.Symbols.Label(uint16(address))})
+					if err != nil {
+						core.Logger.Log(core.LogTypes.ERROR, err)
+					}
+				}
+			}
+			refreshBreakpoints = refresh
+			breakpointsWindow.Connect("destroy", func() {
+				refreshBreakpoints = nil
+			})
+
+			add := func() {
+				text, err := entryBreakpoint.GetText()
+				if err != nil || strings.TrimSpace(text) == "" {
+					return
+				}
+				address, ok := core.Symbols.Resolve(text)
+				if !ok {
+					dialog := gtk.MessageDialogNew(breakpointsWindow, gtk.DIALOG_MODAL|gtk.DIALOG_DESTROY_WITH_PARENT,
+						gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE, "%s", "Could not add the breakpoint")
+					dialog.FormatSecondaryText("%q is not a label of the symbol file or an address.", text)
+					dialog.Run()
+					dialog.Destroy()
+					return
+				}
+				System.CPU.Call(func() {
+					System.CPU.BREAKPOINTS[address] = true
+				})
+				entryBreakpoint.SetText("")
+				refresh()
+			}
+			entryBreakpoint.Connect("activate", add)
+			buttonAddBreakpoint.Connect("clicked", add)
+
+			buttonRemoveBreakpoint.Connect("clicked", func() {
+				selection, err := treeBreakpoints.GetSelection()
+				UIErrorCheck(err)
+				_, iter, ok := selection.GetSelected()
+				if !ok {
+					return
+				}
+				value, err := breakpointListStore.GetValue(iter, 0)
+				UIErrorCheck(err)
+				text, err := value.GetString()
+				UIErrorCheck(err)
+				toggleBreakpoint(text)
+			})
+
+			refresh()
+			breakpointsWindow.Show()
+		})
+
+		// GDB remote serial protocol server on localhost
+		menuDebugGDBObj, err := builder.GetObject("menuDebugGDB")
+		UIErrorCheck(err)
+
+		menuDebugGDB, err := IsCheckMenuItem(menuDebugGDBObj)
+		UIErrorCheck(err)
+
+		menuDebugGDB.Connect("toggled", func() {
+			if !menuDebugGDB.GetActive() {
+				core.GDBServer.Stop()
+				return
+			}
+			if core.GDBServer.Running() {
+				return
+			}
+			if err := core.GDBServer.Start(core.Settings.GDBPort); err != nil {
+				core.Logger.Log(core.LogTypes.ERROR, "GDB: Error starting", err)
+				menuDebugGDB.SetActive(false)
+			}
+		})
+
+		// Audio debug window
+		menuDebugAudioObj, err := builder.GetObject("menuDebugAudio")
+		UIErrorCheck(err)
+
+		menuDebugAudio, err := IsMenuItem(menuDebugAudioObj)
+		UIErrorCheck(err)
+
+		menuDebugAudio.Connect("activate", func() {
+			b, err := gtk.BuilderNewFromFile("ui/AudioWindow.glade")
+			UIErrorCheck(err)
+
+			obj, err := b.GetObject("AudioWindow")
+			UIErrorCheck(err)
+
+			audioWindow, err := IsWindow(obj)
+			UIErrorCheck(err)
+
+			labelMasterObj, err := b.GetObject("labelMaster")
+			UIErrorCheck(err)
+
+			labelMaster, err := IsLabel(labelMasterObj)
+			UIErrorCheck(err)
+
+			var scopes []*gtk.DrawingArea
+			var infos []*gtk.Label
+			for i := 0; i < 4; i++ {
+				channel := i
+
+				checkMuteObj, err := b.GetObject(fmt.Sprintf("checkMute%d", channel+1))
+				UIErrorCheck(err)
+
+				checkMute, err := IsCheckButton(checkMuteObj)
+				UIErrorCheck(err)
+
+				checkMute.SetActive(core.APU.Muted[channel])
+				checkMute.Connect("toggled", func() {
+					core.APU.Muted[channel] = checkMute.GetActive()
+				})
+
+				checkSoloObj, err := b.GetObject(fmt.Sprintf("checkSolo%d", channel+1))
+				UIErrorCheck(err)
+
+				checkSolo, err := IsCheckButton(checkSoloObj)
+				UIErrorCheck(err)
+
+				checkSolo.SetActive(core.APU.Solo[channel])
+				checkSolo.Connect("toggled", func() {
+					core.APU.Solo[channel] = checkSolo.GetActive()
+				})
+
+				scopeObj, err := b.GetObject(fmt.Sprintf("drawingScope%d", channel+1))
+				UIErrorCheck(err)
+
+				scope, err := IsDrawingArea(scopeObj)
+				UIErrorCheck(err)
+
+				scope.Connect("draw", func(drawingArea *gtk.DrawingArea, context *cairo.Context) {
+					DrawScope(drawingArea, context, core.APU.Scope(channel), core.APU.Audible(channel))
+				})
+				scopes = append(scopes, scope)
+
+				infoObj, err := b.GetObject(fmt.Sprintf("labelInfo%d", channel+1))
+				UIErrorCheck(err)
+
+				info, err := IsLabel(infoObj)
+				UIErrorCheck(err)
+				infos = append(infos, info)
+			}
+
+			// Refresh the oscilloscopes and registers 20 times a second while the window is open
+			refresh := glib.TimeoutAdd(50, func() bool {
+				// snapshot the registers between instructions, the CPU thread keeps changing them
+				var channels [4]core.APUChannelInfoType
+				var master string
+				System.CPU.Call(func() {
+					for i := range channels {
+						channels[i] = core.APU.ChannelInfo(i)
+					}
+					master = fmt.Sprintf("NR50 %02X  NR51 %02X  NR52 %02X",
+						core.MMU.ReadByte(0xFF24), core.MMU.ReadByte(0xFF25), core.MMU.ReadByte(0xFF26))
+				})
+				for i, scope := range scopes {
+					scope.QueueDraw()
+					infos[i].SetText(DescribeChannel(channels[i]))
+				}
+				labelMaster.SetText(master)
+				return true
+			})
+			audioWindow.Connect("destroy", func() {
+				glib.SourceRemove(refresh)
+			})
+
+			audioWindow.ShowAll()
+		})
+
 		// Debug Pause/Resume
 		//TODO - FINISH UP
 		menuDebugPauseResumeObj, err := builder.GetObject("menuDebugPauseResume")
@@ -218,6 +584,159 @@ func UI(System *core.SystemType) {
 			System.CPU.Reset()
 		})
 
+		// Volume
+		menuVolumeUpObj, err := builder.GetObject("menuVolumeUp")
+		UIErrorCheck(err)
+
+		menuVolumeUp, err := IsMenuItem(menuVolumeUpObj)
+		UIErrorCheck(err)
+
+		menuVolumeUp.Connect("activate", func() {
+			core.Audio.SetVolume(core.Audio.Volume() + 10)
+			core.Logger.Logf(core.LogTypes.INFO, "AUDIO: volume %d%%\n", core.Audio.Volume())
+		})
+
+		menuVolumeDownObj, err := builder.GetObject("menuVolumeDown")
+		UIErrorCheck(err)
+
+		menuVolumeDown, err := IsMenuItem(menuVolumeDownObj)
+		UIErrorCheck(err)
+
+		menuVolumeDown.Connect("activate", func() {
+			core.Audio.SetVolume(core.Audio.Volume() - 10)
+			core.Logger.Logf(core.LogTypes.INFO, "AUDIO: volume %d%%\n", core.Audio.Volume())
+		})
+
+		// Mute
+		menuMuteObj, err := builder.GetObject("menuMute")
+		UIErrorCheck(err)
+
+		menuMute, err := IsCheckMenuItem(menuMuteObj)
+		UIErrorCheck(err)
+
+		menuMute.SetActive(core.Settings.Muted)
+		menuMute.Connect("toggled", func() {
+			core.Audio.SetMuted(menuMute.GetActive())
+		})
+
+		// Save states
+		var saveSlots, loadSlots []*gtk.MenuItem
+		for slot := 1; slot <= core.SaveStateSlots; slot++ {
+			slot := slot
+
+			menuSaveSlotObj, err := builder.GetObject(fmt.Sprintf("menuSaveSlot%d", slot))
+			UIErrorCheck(err)
+
+			menuSaveSlot, err := IsMenuItem(menuSaveSlotObj)
+			UIErrorCheck(err)
+
+			menuSaveSlot.Connect("activate", func() {
+				if err := core.SaveStateSlot(slot); err != nil {
+					core.Logger.Logf(core.LogTypes.ERROR, "SAVE STATE: slot %d: %v\n", slot, err)
+					ShowStateError(win, "Could not save state", err)
+				}
+			})
+			saveSlots = append(saveSlots, menuSaveSlot)
+
+			menuLoadSlotObj, err := builder.GetObject(fmt.Sprintf("menuLoadSlot%d", slot))
+			UIErrorCheck(err)
+
+			menuLoadSlot, err := IsMenuItem(menuLoadSlotObj)
+			UIErrorCheck(err)
+
+			menuLoadSlot.Connect("activate", func() {
+				if err := core.LoadStateSlot(slot); err != nil {
+					core.Logger.Logf(core.LogTypes.ERROR, "SAVE STATE: slot %d: %v\n", slot, err)
+					ShowStateError(win, "Could not load state", err)
+				}
+			})
+			loadSlots = append(loadSlots, menuLoadSlot)
+		}
+
+		menuSaveStateSlotsObj, err := builder.GetObject("menuSaveStateSlots")
+		UIErrorCheck(err)
+
+		menuSaveStateSlots, err := IsMenu(menuSaveStateSlotsObj)
+		UIErrorCheck(err)
+
+		menuSaveStateSlots.Connect("show", func() {
+			UpdateStateSlots(saveSlots, "Ctrl+Alt+", false)
+		})
+
+		menuLoadStateSlotsObj, err := builder.GetObject("menuLoadStateSlots")
+		UIErrorCheck(err)
+
+		menuLoadStateSlots, err := IsMenu(menuLoadStateSlotsObj)
+		UIErrorCheck(err)
+
+		menuLoadStateSlots.Connect("show", func() {
+			UpdateStateSlots(loadSlots, "Ctrl+", true)
+		})
+
+		// Movies
+		movieItem := func(name string, activate func()) {
+			obj, err := builder.GetObject(name)
+			UIErrorCheck(err)
+
+			item, err := IsMenuItem(obj)
+			UIErrorCheck(err)
+
+			item.Connect("activate", activate)
+		}
+		recordMovie := func(fromPowerOn bool) {
+			var err error
+			System.CPU.Call(func() {
+				err = core.Movie.Record(fromPowerOn)
+			})
+			if err != nil {
+				core.Logger.Log(core.LogTypes.ERROR, "MOVIE: Error recording", err)
+				ShowMovieError(win, "Could not record movie", err)
+			}
+		}
+		core.Movie.OnEnd = func() {
+			core.Notify("Movie finished playing")
+		}
+
+		movieItem("menuMovieRecordPowerOn", func() {
+			recordMovie(true)
+		})
+		movieItem("menuMovieRecord", func() {
+			recordMovie(false)
+		})
+		movieItem("menuMovieStop", func() {
+			var movie *core.MovieFile
+			var err error
+			System.CPU.Call(func() {
+				movie, err = core.Movie.Stop()
+			})
+			if err != nil {
+				// stopping playback leaves nothing to save
+				return
+			}
+			if location, ok := ChooseMovieFile(win, gtk.FILE_CHOOSER_ACTION_SAVE); ok {
+				if err := movie.Save(location); err != nil {
+					core.Logger.Log(core.LogTypes.ERROR, "MOVIE: Error saving", err)
+					ShowMovieError(win, "Could not save movie", err)
+				}
+			}
+		})
+		movieItem("menuMoviePlay", func() {
+			location, ok := ChooseMovieFile(win, gtk.FILE_CHOOSER_ACTION_OPEN)
+			if !ok {
+				return
+			}
+			movie, err := core.ReadMovie(location)
+			if err == nil {
+				System.CPU.Call(func() {
+					err = core.Movie.Play(movie)
+				})
+			}
+			if err != nil {
+				core.Logger.Log(core.LogTypes.ERROR, "MOVIE: Error playing", err)
+				ShowMovieError(win, "Could not play movie", err)
+			}
+		})
+
 		// Console
 		consoleObj, err := builder.GetObject("textViewConsole")
 		UIErrorCheck(err)
@@ -249,14 +768,12 @@ func UI(System *core.SystemType) {
 			emulatorWindow, err := IsWindow(obj)
 			UIErrorCheck(err)
 
-			gtkglarea, err := b.GetObject("GLArea")
+			screenBoxObj, err := b.GetObject("ScreenBox")
 			UIErrorCheck(err)
 
-			glarea, err := IsGLArea(gtkglarea)
+			screenBox, err := IsBox(screenBoxObj)
 			UIErrorCheck(err)
 
-			glarea.SetRequiredVersion(4, 6)
-
 			registerTree, err := builder.GetObject("registerTreeStore")
 			UIErrorCheck(err)
 
@@ -269,11 +786,12 @@ func UI(System *core.SystemType) {
 			registerListStore, err := IsListStore(registerList)
 			UIErrorCheck(err)
 
+			core.Audio.Start()
+			core.StartLinkCable()
 			go System.CPU.Run(false, registerTreeStore, registerListStore)
 
-			glarea.Connect("realize", System.GPU.Init)
-			glarea.Connect("render", System.GPU.Run)
-			glarea.Connect("unrealize", System.GPU.Destroy)
+			core.AttachRenderer(screenBox)
+			ConnectEmulatorKeys(emulatorWindow)
 
 			emulatorWindow.Show()
 		})
@@ -317,6 +835,277 @@ func UI(System *core.SystemType) {
 			settingsWindow, err := IsWindow(obj)
 			UIErrorCheck(err)
 
+			// Shader preset selector
+			comboShaderObj, err := builder.GetObject("comboShader")
+			UIErrorCheck(err)
+
+			comboShader, err := IsComboBoxText(comboShaderObj)
+			UIErrorCheck(err)
+
+			for _, preset := range core.FindShaderPresets() {
+				comboShader.Append(preset.Name, preset.Name)
+			}
+			if !comboShader.SetActiveID(core.Settings.Shader) {
+				comboShader.SetActiveID(core.ShaderPresetBuiltin)
+			}
+
+			comboShader.Connect("changed", func() {
+				core.Settings.Shader = comboShader.GetActiveID()
+				core.Settings.Save()
+			})
+
+			// Renderer selector, applied the next time the emulator window opens
+			comboRendererObj, err := builder.GetObject("comboRenderer")
+			UIErrorCheck(err)
+
+			comboRenderer, err := IsComboBoxText(comboRendererObj)
+			UIErrorCheck(err)
+
+			comboRenderer.SetActiveID(core.Settings.Renderer)
+			comboRenderer.Connect("changed", func() {
+				core.Settings.Renderer = comboRenderer.GetActiveID()
+				core.Settings.Save()
+			})
+
+			// Palette selectors, the default palette and an override for the loaded ROM
+			comboPaletteObj, err := builder.GetObject("comboPalette")
+			UIErrorCheck(err)
+
+			comboPalette, err := IsComboBoxText(comboPaletteObj)
+			UIErrorCheck(err)
+
+			comboROMPaletteObj, err := builder.GetObject("comboROMPalette")
+			UIErrorCheck(err)
+
+			comboROMPalette, err := IsComboBoxText(comboROMPaletteObj)
+			UIErrorCheck(err)
+
+			// filling stops the "changed" handlers from saving while the lists are rebuilt
+			filling := false
+			fillPalettes := func() {
+				filling = true
+				defer func() { filling = false }()
+				comboPalette.RemoveAll()
+				comboROMPalette.RemoveAll()
+				comboROMPalette.Append("", "Use default palette")
+				for _, palette := range core.FindPalettes() {
+					comboPalette.Append(palette.Name, palette.Name)
+					comboROMPalette.Append(palette.Name, palette.Name)
+				}
+				comboPalette.SetActiveID(core.Settings.Palette)
+				comboROMPalette.SetActiveID(core.Settings.ROMPalettes[core.ROM.GetKey()])
+			}
+			fillPalettes()
+			comboROMPalette.SetSensitive(core.ROM.GetKey() != "")
+
+			comboPalette.Connect("changed", func() {
+				if name := comboPalette.GetActiveID(); !filling && name != core.Settings.Palette {
+					core.Settings.Palette = name
+					core.Settings.Save()
+					System.GPU.SetPalette(core.FindPalette(core.ROMPalette()))
+				}
+			})
+			comboROMPalette.Connect("changed", func() {
+				if name := comboROMPalette.GetActiveID(); !filling && name != core.Settings.ROMPalettes[core.ROM.GetKey()] {
+					core.SetROMPalette(name)
+				}
+			})
+
+			importPaletteObj, err := builder.GetObject("buttonImportPalette")
+			UIErrorCheck(err)
+
+			importPalette, err := IsButton(importPaletteObj)
+			UIErrorCheck(err)
+
+			importPalette.Connect("clicked", func() {
+				dialog, err := gtk.FileChooserDialogNewWith2Buttons("Import Palette", settingsWindow,
+					gtk.FILE_CHOOSER_ACTION_OPEN, "Cancel", gtk.RESPONSE_CANCEL, "Import", gtk.RESPONSE_ACCEPT)
+				UIErrorCheck(err)
+
+				filter, err := gtk.FileFilterNew()
+				UIErrorCheck(err)
+				filter.SetName("Palette files")
+				filter.AddPattern("*.pal")
+				filter.AddPattern("*.txt")
+				dialog.AddFilter(filter)
+
+				if dialog.Run() == gtk.RESPONSE_ACCEPT {
+					if _, err := core.ImportPalette(dialog.GetFilename()); err != nil {
+						core.Logger.Log(core.LogTypes.ERROR, "PALETTE: Error importing", err)
+					}
+					fillPalettes()
+				}
+				dialog.Destroy()
+			})
+
+			// Hardware model selectors, applied the next time the ROM is loaded
+			comboModelObj, err := builder.GetObject("comboModel")
+			UIErrorCheck(err)
+
+			comboModel, err := IsComboBoxText(comboModelObj)
+			UIErrorCheck(err)
+
+			comboModel.SetActiveID(core.Settings.Model)
+			comboModel.Connect("changed", func() {
+				core.Settings.Model = comboModel.GetActiveID()
+				core.Settings.Save()
+			})
+
+			comboROMModelObj, err := builder.GetObject("comboROMModel")
+			UIErrorCheck(err)
+
+			comboROMModel, err := IsComboBoxText(comboROMModelObj)
+			UIErrorCheck(err)
+
+			comboROMModel.SetActiveID(core.Settings.ROMModels[core.ROM.GetKey()])
+			comboROMModel.SetSensitive(core.ROM.GetKey() != "")
+			comboROMModel.Connect("changed", func() {
+				if model := comboROMModel.GetActiveID(); model == "" {
+					delete(core.Settings.ROMModels, core.ROM.GetKey())
+				} else {
+					core.Settings.ROMModels[core.ROM.GetKey()] = model
+				}
+				core.Settings.Save()
+			})
+
+			checkSGBBorderObj, err := builder.GetObject("checkSGBBorder")
+			UIErrorCheck(err)
+
+			checkSGBBorder, err := IsCheckButton(checkSGBBorderObj)
+			UIErrorCheck(err)
+
+			checkSGBBorder.SetActive(core.Settings.SGBBorder)
+			checkSGBBorder.Connect("toggled", func() {
+				core.Settings.SGBBorder = checkSGBBorder.GetActive()
+				core.Settings.Save()
+			})
+
+			// Link cable, applied the next time the emulator runs
+			comboLinkModeObj, err := builder.GetObject("comboLinkMode")
+			UIErrorCheck(err)
+
+			comboLinkMode, err := IsComboBoxText(comboLinkModeObj)
+			UIErrorCheck(err)
+
+			if !comboLinkMode.SetActiveID(core.Settings.LinkMode) {
+				comboLinkMode.SetActiveID(core.LinkModeOff)
+			}
+			comboLinkMode.Connect("changed", func() {
+				core.Settings.LinkMode = comboLinkMode.GetActiveID()
+				core.Settings.Save()
+			})
+
+			entryLinkHostObj, err := builder.GetObject("entryLinkHost")
+			UIErrorCheck(err)
+
+			entryLinkHost, err := IsEntry(entryLinkHostObj)
+			UIErrorCheck(err)
+
+			entryLinkHost.SetText(core.Settings.LinkHost)
+			entryLinkHost.Connect("changed", func() {
+				host, err := entryLinkHost.GetText()
+				if err != nil {
+					return
+				}
+				core.Settings.LinkHost = strings.TrimSpace(host)
+				core.Settings.Save()
+			})
+
+			spinLinkPortObj, err := builder.GetObject("spinLinkPort")
+			UIErrorCheck(err)
+
+			spinLinkPort, err := IsSpinButton(spinLinkPortObj)
+			UIErrorCheck(err)
+
+			spinLinkPort.SetValue(float64(core.Settings.LinkPort))
+			spinLinkPort.Connect("value-changed", func() {
+				core.Settings.LinkPort = spinLinkPort.GetValueAsInt()
+				core.Settings.Save()
+			})
+
+			// Boot ROMs, run on the next reset when set
+			fileBootROMObj, err := builder.GetObject("fileBootROM")
+			UIErrorCheck(err)
+
+			fileBootROM, err := IsFileChooserButton(fileBootROMObj)
+			UIErrorCheck(err)
+
+			if core.Settings.BootROM != "" {
+				fileBootROM.SetFilename(core.Settings.BootROM)
+			}
+			fileBootROM.Connect("file-set", func() {
+				core.Settings.BootROM = fileBootROM.GetFilename()
+				core.Settings.Save()
+			})
+
+			fileBootROMCGBObj, err := builder.GetObject("fileBootROMCGB")
+			UIErrorCheck(err)
+
+			fileBootROMCGB, err := IsFileChooserButton(fileBootROMCGBObj)
+			UIErrorCheck(err)
+
+			if core.Settings.BootROMCGB != "" {
+				fileBootROMCGB.SetFilename(core.Settings.BootROMCGB)
+			}
+			fileBootROMCGB.Connect("file-set", func() {
+				core.Settings.BootROMCGB = fileBootROMCGB.GetFilename()
+				core.Settings.Save()
+			})
+
+			buttonClearBootROMsObj, err := builder.GetObject("buttonClearBootROMs")
+			UIErrorCheck(err)
+
+			buttonClearBootROMs, err := IsButton(buttonClearBootROMsObj)
+			UIErrorCheck(err)
+
+			buttonClearBootROMs.Connect("clicked", func() {
+				fileBootROM.UnselectAll()
+				fileBootROMCGB.UnselectAll()
+				core.Settings.BootROM = ""
+				core.Settings.BootROMCGB = ""
+				core.Settings.Save()
+			})
+
+			// Rewind buffer
+			spinRewindBudgetObj, err := builder.GetObject("spinRewindBudget")
+			UIErrorCheck(err)
+
+			spinRewindBudget, err := IsSpinButton(spinRewindBudgetObj)
+			UIErrorCheck(err)
+
+			spinRewindBudget.SetValue(float64(core.Settings.RewindBudget))
+			spinRewindBudget.Connect("value-changed", func() {
+				core.Settings.RewindBudget = spinRewindBudget.GetValueAsInt()
+				core.Rewind.Configure(core.Settings.RewindInterval, core.Settings.RewindBudget)
+				core.Settings.Save()
+			})
+
+			spinRewindIntervalObj, err := builder.GetObject("spinRewindInterval")
+			UIErrorCheck(err)
+
+			spinRewindInterval, err := IsSpinButton(spinRewindIntervalObj)
+			UIErrorCheck(err)
+
+			spinRewindInterval.SetValue(float64(core.Settings.RewindInterval))
+			spinRewindInterval.Connect("value-changed", func() {
+				core.Settings.RewindInterval = spinRewindInterval.GetValueAsInt()
+				core.Rewind.Configure(core.Settings.RewindInterval, core.Settings.RewindBudget)
+				core.Settings.Save()
+			})
+
+			// GDB server, used the next time it starts
+			spinGDBPortObj, err := builder.GetObject("spinGDBPort")
+			UIErrorCheck(err)
+
+			spinGDBPort, err := IsSpinButton(spinGDBPortObj)
+			UIErrorCheck(err)
+
+			spinGDBPort.SetValue(float64(core.Settings.GDBPort))
+			spinGDBPort.Connect("value-changed", func() {
+				core.Settings.GDBPort = spinGDBPort.GetValueAsInt()
+				core.Settings.Save()
+			})
+
 			settingsWindow.Show()
 		})
 
@@ -332,12 +1121,51 @@ func UI(System *core.SystemType) {
 		romTreeStore, err := IsTreeView(romTree)
 		UIErrorCheck(err)
 
+		// double clicking an instruction or label toggles a breakpoint on it
+		romTreeStore.Connect("row-activated", func(treeView *gtk.TreeView, path *gtk.TreePath, column *gtk.TreeViewColumn) {
+			iter, err := romListStore.GetIter(path)
+			if err != nil {
+				return
+			}
+			value, err := romListStore.GetValue(iter, 0)
+			UIErrorCheck(err)
+			text, err := value.GetString()
+			UIErrorCheck(err)
+			toggleBreakpoint(text)
+		})
+
 		romProgress, err := builder.GetObject("romProgressBar")
 		UIErrorCheck(err)
 
 		romProgressBar, err := IsProgressBar(romProgress)
 		UIErrorCheck(err)
 
+		// loadROM asks which ROM to use when an archive holds several, then loads it
+		// off the UI thread, reporting a failure in an error dialog
+		loadROM := func(location string) {
+			entry := ""
+			if core.IsArchive(location) {
+				roms, err := core.ArchiveROMs(location)
+				if err != nil {
+					ShowROMError(win, &core.ROMError{Location: location, Err: err})
+					return
+				}
+				if len(roms) > 1 {
+					var ok bool
+					if entry, ok = ChooseArchiveROM(win, roms); !ok {
+						return
+					}
+				}
+			}
+			go func() {
+				if err := System.LoadROM(location, entry, romListStore, romTreeStore, romProgressBar, menuDebug, menuRun); err != nil {
+					glib.IdleAdd(func() {
+						ShowROMError(win, err)
+					})
+				}
+			}()
+		}
+
 		// Setup Open Menu Item
 		// Builds ROM Dialog details
 		// Sets up the buttons for the ROM Dialog
@@ -381,7 +1209,7 @@ func UI(System *core.SystemType) {
 				core.Logger.Log(core.LogTypes.INFO, ROMfile)
 				romFileChooserDialog.Close()
 				// Do not block UI execution
-				go System.LoadROM(string(ROMfile), romListStore, romTreeStore, romProgressBar, menuDebug, menuRun)
+				loadROM(string(ROMfile))
 			} else if result == gtk.RESPONSE_CANCEL {
 				core.Logger.Log(core.LogTypes.INFO, "Cancelling")
 				romFileChooserDialog.Close()
@@ -402,7 +1230,158 @@ func UI(System *core.SystemType) {
 			if runtime.GOOS == "windows" {
 				romLoc = romLoc[1:]
 			}
-			go System.LoadROM(romLoc, romListStore, romTreeStore, romProgressBar, menuDebug, menuRun)
+			loadROM(romLoc)
+		})
+
+		// ROM information
+		menuROMInfoObj, err := builder.GetObject("menuROMInfo")
+		UIErrorCheck(err)
+
+		menuROMInfo, err := IsMenuItem(menuROMInfoObj)
+		UIErrorCheck(err)
+
+		menuROMInfo.Connect("activate", func() {
+			b, err := gtk.BuilderNewFromFile("ui/RomInfoWindow.glade")
+			UIErrorCheck(err)
+
+			obj, err := b.GetObject("RomInfoWindow")
+			UIErrorCheck(err)
+
+			romInfoWindow, err := IsWindow(obj)
+			UIErrorCheck(err)
+
+			gridObj, err := b.GetObject("gridROMInfo")
+			UIErrorCheck(err)
+
+			grid, err := IsGrid(gridObj)
+			UIErrorCheck(err)
+
+			labelCompatibilityObj, err := b.GetObject("labelCompatibility")
+			UIErrorCheck(err)
+
+			labelCompatibility, err := IsLabel(labelCompatibilityObj)
+			UIErrorCheck(err)
+
+			if core.ROM.GetKey() == "" {
+				labelCompatibility.SetText("No ROM loaded")
+				romInfoWindow.Show()
+				return
+			}
+
+			header := core.ROM.Header()
+			valid := map[bool]string{true: "OK", false: "Mismatch"}
+			rows := [][2]string{
+				{"Title", header.Title},
+				{"Manufacturer code", header.Manufacturer},
+				{"Licensee", header.Licensee()},
+				{"Cartridge type", fmt.Sprintf("%s (0x%02X)", header.TypeName(), header.CartridgeType)},
+				{"ROM size", fmt.Sprintf("%dKB", header.ROMSize())},
+				{"RAM size", fmt.Sprintf("%dKB", header.RAMSize())},
+				{"Game Boy Color", header.CGBSupport()},
+				{"Super Game Boy", fmt.Sprintf("%t", header.SupportsSGB())},
+				{"Destination", header.DestinationName()},
+				{"Version", fmt.Sprintf("%d", header.Version)},
+				{"Nintendo logo", valid[header.LogoValid]},
+				{"Header checksum", fmt.Sprintf("0x%02X %s", header.HeaderChecksum, valid[header.HeaderChecksumValid])},
+				{"Global checksum", fmt.Sprintf("0x%04X %s", header.GlobalChecksum, valid[header.GlobalChecksumValid])},
+			}
+			for i, row := range rows {
+				name, err := gtk.LabelNew(row[0])
+				UIErrorCheck(err)
+				name.SetXAlign(0)
+
+				value, err := gtk.LabelNew(row[1])
+				UIErrorCheck(err)
+				value.SetXAlign(0)
+				value.SetSelectable(true)
+
+				grid.Attach(name, 0, i, 1, 1)
+				grid.Attach(value, 1, i, 1, 1)
+			}
+			grid.ShowAll()
+
+			issues := core.ROM.Header().CompatibilityCheck(len(core.ROMref))
+			if len(issues) == 0 {
+				labelCompatibility.SetText("No problems found")
+			} else {
+				var text []string
+				for _, issue := range issues {
+					if issue.Fatal {
+						text = append(text, "Error: "+issue.Message)
+					} else {
+						text = append(text, "Warning: "+issue.Message)
+					}
+				}
+				labelCompatibility.SetText(strings.Join(text, "\n"))
+			}
+
+			romInfoWindow.Show()
+		})
+
+		// Printer gallery
+		menuPrinterGalleryObj, err := builder.GetObject("menuPrinterGallery")
+		UIErrorCheck(err)
+
+		menuPrinterGallery, err := IsMenuItem(menuPrinterGalleryObj)
+		UIErrorCheck(err)
+
+		menuPrinterGallery.Connect("activate", func() {
+			b, err := gtk.BuilderNewFromFile("ui/PrinterGalleryWindow.glade")
+			UIErrorCheck(err)
+
+			obj, err := b.GetObject("PrinterGalleryWindow")
+			UIErrorCheck(err)
+
+			galleryWindow, err := IsWindow(obj)
+			UIErrorCheck(err)
+
+			flowPrintsObj, err := b.GetObject("flowPrints")
+			UIErrorCheck(err)
+
+			flowPrints, err := IsFlowBox(flowPrintsObj)
+			UIErrorCheck(err)
+
+			labelPrintDirObj, err := b.GetObject("labelPrintDir")
+			UIErrorCheck(err)
+
+			labelPrintDir, err := IsLabel(labelPrintDirObj)
+			UIErrorCheck(err)
+
+			buttonRefreshObj, err := b.GetObject("buttonRefreshPrints")
+			UIErrorCheck(err)
+
+			buttonRefresh, err := IsButton(buttonRefreshObj)
+			UIErrorCheck(err)
+
+			refresh := func() {
+				for child := flowPrints.GetChildAtIndex(0); child != nil; child = flowPrints.GetChildAtIndex(0) {
+					child.Destroy()
+				}
+				prints := core.FindPrints()
+				labelPrintDir.SetText(fmt.Sprintf("%d prints in %s", len(prints), core.PrintDir))
+				for _, location := range prints {
+					pixbuf, err := gdk.PixbufNewFromFile(location)
+					if err != nil {
+						core.Logger.Log(core.LogTypes.ERROR, "PRINTER:", err)
+						continue
+					}
+					// printouts are 160 pixels wide, doubled without smoothing
+					pixbuf, err = pixbuf.ScaleSimple(pixbuf.GetWidth()*2, pixbuf.GetHeight()*2, gdk.INTERP_NEAREST)
+					if err != nil {
+						core.Logger.Log(core.LogTypes.ERROR, "PRINTER:", err)
+						continue
+					}
+					image, err := gtk.ImageNewFromPixbuf(pixbuf)
+					UIErrorCheck(err)
+					image.SetTooltipText(location)
+					image.Show()
+					flowPrints.Insert(image, -1)
+				}
+			}
+			refresh()
+			buttonRefresh.Connect("clicked", refresh)
+
+			galleryWindow.Show()
 		})
 
 		menuQuit, err := builder.GetObject("menuQuit")
@@ -415,9 +1394,6 @@ func UI(System *core.SystemType) {
 			app.Quit()
 		})
 
-		win, err := IsWindow(obj)
-		UIErrorCheck(err)
-
 		consoleStyleContext, err := console.GetStyleContext()
 		UIErrorCheck(err)
 		consoleStyleContext.AddProvider(cssProvider, uint(gtk.STYLE_PROVIDER_PRIORITY_USER))
@@ -436,6 +1412,275 @@ func UI(System *core.SystemType) {
 	app.Run(os.Args[1:])
 }
 
+// JoypadKeys maps keys to the joypad buttons they press in the emulator window.
+var JoypadKeys = map[uint]byte{
+	gdk.KEY_Right:   core.ButtonRight,
+	gdk.KEY_Left:    core.ButtonLeft,
+	gdk.KEY_Up:      core.ButtonUp,
+	gdk.KEY_Down:    core.ButtonDown,
+	gdk.KEY_x:       core.ButtonA,
+	gdk.KEY_z:       core.ButtonB,
+	gdk.KEY_Shift_R: core.ButtonSelect,
+	gdk.KEY_Return:  core.ButtonStart,
+}
+
+// ConnectEmulatorKeys presses the joypad buttons and rewinds the emulator
+// while Backspace is held in its window.
+func ConnectEmulatorKeys(window *gtk.Window) {
+	window.Connect("key-press-event", func(win *gtk.Window, ev *gdk.Event) bool {
+		key := gdk.EventKeyNewFromEvent(ev).KeyVal()
+		if button, ok := JoypadKeys[key]; ok {
+			core.Joypad.Press(button)
+			return true
+		}
+		if key != gdk.KEY_BackSpace {
+			return false
+		}
+		core.Rewind.Rewinding = true
+		return true
+	})
+	window.Connect("key-release-event", func(win *gtk.Window, ev *gdk.Event) bool {
+		key := gdk.EventKeyNewFromEvent(ev).KeyVal()
+		if button, ok := JoypadKeys[key]; ok {
+			core.Joypad.Release(button)
+			return true
+		}
+		if key != gdk.KEY_BackSpace {
+			return false
+		}
+		core.Rewind.Rewinding = false
+		return true
+	})
+	window.Connect("focus-out-event", func() bool {
+		for _, button := range JoypadKeys {
+			core.Joypad.Release(button)
+		}
+		core.Rewind.Rewinding = false
+		return false
+	})
+}
+
+// ShowROMError tells the user why a ROM could not be loaded.
+func ShowROMError(parent *gtk.Window, err error) {
+	reason := "The file could not be read."
+	switch {
+	case errors.Is(err, core.ErrROMTooSmall):
+		reason = "The file is too small to be a Game Boy ROM."
+	case errors.Is(err, core.ErrHeaderChecksum):
+		reason = "The cartridge header checksum does not match, the header is corrupt."
+	case errors.Is(err, core.ErrUnsupportedMapper):
+		reason = "The cartridge type is not supported."
+	case errors.Is(err, core.ErrROMSizeMismatch):
+		reason = "The file size does not match the ROM size in the cartridge header."
+	case errors.Is(err, core.ErrNoROMInArchive):
+		reason = "The archive does not contain a .gb or .gbc ROM."
+	case errors.Is(err, core.ErrUnsupportedArchive):
+		reason = "The archive format is not supported."
+	case errors.Is(err, core.ErrArchiveFileTooLarge):
+		reason = "The archived file is too large to be a Game Boy ROM."
+	}
+	dialog := gtk.MessageDialogNew(parent, gtk.DIALOG_MODAL|gtk.DIALOG_DESTROY_WITH_PARENT,
+		gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE, "%s", "Could not load ROM")
+	dialog.FormatSecondaryText("%s\n\n%s", reason, err)
+	dialog.Run()
+	dialog.Destroy()
+}
+
+// ShowStateError tells the user why a save state could not be saved or loaded.
+func ShowStateError(parent *gtk.Window, title string, err error) {
+	reason := "The save state file could not be read or written."
+	switch {
+	case errors.Is(err, core.ErrSaveStateNoROM):
+		reason = "Load a ROM before using save states."
+	case errors.Is(err, core.ErrSaveStateROM):
+		reason = "The save state was made with a different ROM."
+	case errors.Is(err, core.ErrNotSaveState):
+		reason = "The file is not a save state, or it is corrupt."
+	case errors.Is(err, core.ErrSaveStateMovie):
+		reason = "Stop the movie before loading a save state."
+	case os.IsNotExist(err):
+		reason = "The slot is empty."
+	}
+	dialog := gtk.MessageDialogNew(parent, gtk.DIALOG_MODAL|gtk.DIALOG_DESTROY_WITH_PARENT,
+		gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE, "%s", title)
+	dialog.FormatSecondaryText("%s\n\n%s", reason, err)
+	dialog.Run()
+	dialog.Destroy()
+}
+
+// UpdateStateSlots shows the time and thumbnail of each save state slot in its menu item.
+// Empty slots cannot be loaded.
+func UpdateStateSlots(items []*gtk.MenuItem, hotkey string, load bool) {
+	for i, item := range items {
+		slot := i + 1
+		text := fmt.Sprintf("Slot %d (%s%d)\nEmpty", slot, hotkey, slot)
+		var thumbnail *gdk.Pixbuf
+		info, err := core.ReadSaveStateInfo(core.SaveStateLocation(slot))
+		if err == nil {
+			text = fmt.Sprintf("Slot %d (%s%d)\n%s", slot, hotkey, slot, info.Saved.Format("2006-01-02 15:04:05"))
+			if loader, err := gdk.PixbufLoaderNew(); err == nil && len(info.Thumbnail) > 0 {
+				if pixbuf, err := loader.WriteAndReturnPixbuf(info.Thumbnail); err == nil {
+					thumbnail, _ = pixbuf.ScaleSimple(pixbuf.GetWidth()/2, pixbuf.GetHeight()/2, gdk.INTERP_BILINEAR)
+				}
+			}
+		}
+		if load {
+			item.SetSensitive(err == nil)
+		}
+
+		box, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 6)
+		UIErrorCheck(err)
+		if thumbnail != nil {
+			image, err := gtk.ImageNewFromPixbuf(thumbnail)
+			UIErrorCheck(err)
+			box.PackStart(image, false, false, 0)
+		}
+		label, err := gtk.LabelNew(text)
+		UIErrorCheck(err)
+		label.SetXAlign(0)
+		box.PackStart(label, false, false, 0)
+
+		if child, err := item.GetChild(); err == nil && child != nil {
+			item.Remove(child)
+		}
+		item.Add(box)
+		item.ShowAll()
+	}
+}
+
+// ShowMovieError tells the user why a movie could not be recorded, saved or played.
+func ShowMovieError(parent *gtk.Window, title string, err error) {
+	reason := "The movie file could not be read or written."
+	switch {
+	case errors.Is(err, core.ErrMovieNoROM):
+		reason = "Load a ROM before using movies."
+	case errors.Is(err, core.ErrMovieROM):
+		reason = "The movie was recorded with a different ROM."
+	case errors.Is(err, core.ErrMovieBootROM):
+		reason = "The movie was recorded with a different boot ROM, select it in the settings."
+	case errors.Is(err, core.ErrNotMovie):
+		reason = "The file is not a movie, or it is corrupt."
+	}
+	dialog := gtk.MessageDialogNew(parent, gtk.DIALOG_MODAL|gtk.DIALOG_DESTROY_WITH_PARENT,
+		gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE, "%s", title)
+	dialog.FormatSecondaryText("%s\n\n%s", reason, err)
+	dialog.Run()
+	dialog.Destroy()
+}
+
+// ChooseMovieFile asks for a movie file to open or save.
+func ChooseMovieFile(parent *gtk.Window, action gtk.FileChooserAction) (string, bool) {
+	title, button := "Play Movie", "Play"
+	if action == gtk.FILE_CHOOSER_ACTION_SAVE {
+		title, button = "Save Movie", "Save"
+	}
+	dialog, err := gtk.FileChooserDialogNewWith2Buttons(title, parent,
+		action, "Cancel", gtk.RESPONSE_CANCEL, button, gtk.RESPONSE_ACCEPT)
+	UIErrorCheck(err)
+	defer dialog.Destroy()
+
+	filter, err := gtk.FileFilterNew()
+	UIErrorCheck(err)
+	filter.SetName("Movies")
+	filter.AddPattern("*" + core.MovieExtension)
+	dialog.AddFilter(filter)
+	if action == gtk.FILE_CHOOSER_ACTION_SAVE {
+		dialog.SetDoOverwriteConfirmation(true)
+		dialog.SetCurrentName(core.ROM.GetName() + core.MovieExtension)
+	}
+
+	if dialog.Run() != gtk.RESPONSE_ACCEPT {
+		return "", false
+	}
+	return dialog.GetFilename(), true
+}
+
+// ChooseArchiveROM asks which of the ROMs in an archive to load.
+func ChooseArchiveROM(parent *gtk.Window, roms []string) (string, bool) {
+	builder, err := gtk.BuilderNewFromFile("ui/RomArchiveDialog.glade")
+	UIErrorCheck(err)
+
+	obj, err := builder.GetObject("RomArchiveDialog")
+	UIErrorCheck(err)
+
+	dialog, err := IsDialog(obj)
+	UIErrorCheck(err)
+	defer dialog.Destroy()
+	dialog.SetTransientFor(parent)
+
+	comboObj, err := builder.GetObject("comboArchiveROM")
+	UIErrorCheck(err)
+
+	combo, err := IsComboBoxText(comboObj)
+	UIErrorCheck(err)
+
+	for _, rom := range roms {
+		combo.AppendText(rom)
+	}
+	combo.SetActive(0)
+
+	if dialog.Run() != gtk.RESPONSE_ACCEPT {
+		return "", false
+	}
+	return combo.GetActiveText(), true
+}
+
+// DescribeChannel formats a channel's decoded registers for the Audio debug window.
+func DescribeChannel(info core.APUChannelInfoType) string {
+	registers := ""
+	for _, register := range info.Registers {
+		registers += fmt.Sprintf("%02X ", register)
+	}
+	state := "off"
+	if info.Enabled {
+		state = "on"
+	}
+	panning := ""
+	if info.Left {
+		panning += "L"
+	}
+	if info.Right {
+		panning += "R"
+	}
+	if panning == "" {
+		panning = "-"
+	}
+	text := fmt.Sprintf("NRx0-4: %s\n%s  %.1f Hz  volume %d  envelope %s  pan %s", registers, state,
+		info.Frequency, info.Volume, info.Envelope, panning)
+	if info.Duty != "" {
+		text += "  duty " + info.Duty
+	}
+	if info.LengthEnabled {
+		text += fmt.Sprintf("  length %d", info.Length)
+	}
+	return text
+}
+
+// DrawScope draws a channel's recent 4-bit outputs as an oscilloscope trace.
+func DrawScope(drawingArea *gtk.DrawingArea, context *cairo.Context, history []byte, audible bool) {
+	width := float64(drawingArea.GetAllocatedWidth())
+	height := float64(drawingArea.GetAllocatedHeight())
+
+	context.SetSourceRGB(0, 0, 0)
+	context.Paint()
+	if audible {
+		context.SetSourceRGB(0.2, 0.9, 0.3)
+	} else {
+		context.SetSourceRGB(0.4, 0.4, 0.4)
+	}
+	context.SetLineWidth(1)
+	for i, output := range history {
+		x := float64(i) * width / float64(len(history))
+		y := height - 2 - float64(output)*(height-4)/15
+		if i == 0 {
+			context.MoveTo(x, y)
+		} else {
+			context.LineTo(x, y)
+		}
+	}
+	context.Stroke()
+}
+
 // IsWindow converts a GObject to a GTK Window.
 func IsWindow(obj glib.IObject) (*gtk.Window, error) {
 	// Make type assertion (as per gtk.go).
@@ -445,6 +1690,15 @@ func IsWindow(obj glib.IObject) (*gtk.Window, error) {
 	return nil, errors.New("not a *gtk.Window")
 }
 
+// IsMenu converts a GObject to a GTK Menu.
+func IsMenu(obj glib.IObject) (*gtk.Menu, error) {
+	// Make type assertion (as per gtk.go).
+	if menu, ok := obj.(*gtk.Menu); ok {
+		return menu, nil
+	}
+	return nil, errors.New("not a *gtk.Menu")
+}
+
 // IsMenuItem converts a GObject to a GTK MenuItem.
 func IsMenuItem(obj glib.IObject) (*gtk.MenuItem, error) {
 	// Make type assertion (as per gtk.go).
@@ -454,6 +1708,87 @@ func IsMenuItem(obj glib.IObject) (*gtk.MenuItem, error) {
 	return nil, errors.New("not a *gtk.MenuItem")
 }
 
+// IsCheckMenuItem converts a GObject to a GTK CheckMenuItem.
+func IsCheckMenuItem(obj glib.IObject) (*gtk.CheckMenuItem, error) {
+	// Make type assertion (as per gtk.go).
+	if item, ok := obj.(*gtk.CheckMenuItem); ok {
+		return item, nil
+	}
+	return nil, errors.New("not a *gtk.CheckMenuItem")
+}
+
+// IsLabel converts a GObject to a GTK Label.
+func IsLabel(obj glib.IObject) (*gtk.Label, error) {
+	// Make type assertion (as per gtk.go).
+	if label, ok := obj.(*gtk.Label); ok {
+		return label, nil
+	}
+	return nil, errors.New("not a *gtk.Label")
+}
+
+// IsDrawingArea converts a GObject to a GTK DrawingArea.
+func IsDrawingArea(obj glib.IObject) (*gtk.DrawingArea, error) {
+	// Make type assertion (as per gtk.go).
+	if drawingArea, ok := obj.(*gtk.DrawingArea); ok {
+		return drawingArea, nil
+	}
+	return nil, errors.New("not a *gtk.DrawingArea")
+}
+
+// IsEntry converts a GObject to a GTK Entry.
+func IsEntry(obj glib.IObject) (*gtk.Entry, error) {
+	// Make type assertion (as per gtk.go).
+	if entry, ok := obj.(*gtk.Entry); ok {
+		return entry, nil
+	}
+	return nil, errors.New("not a *gtk.Entry")
+}
+
+// IsSpinButton converts a GObject to a GTK SpinButton.
+func IsSpinButton(obj glib.IObject) (*gtk.SpinButton, error) {
+	// Make type assertion (as per gtk.go).
+	if spinButton, ok := obj.(*gtk.SpinButton); ok {
+		return spinButton, nil
+	}
+	return nil, errors.New("not a *gtk.SpinButton")
+}
+
+// IsFileChooserButton converts a GObject to a GTK FileChooserButton.
+func IsFileChooserButton(obj glib.IObject) (*gtk.FileChooserButton, error) {
+	// Make type assertion (as per gtk.go).
+	if button, ok := obj.(*gtk.FileChooserButton); ok {
+		return button, nil
+	}
+	return nil, errors.New("not a *gtk.FileChooserButton")
+}
+
+// IsGrid converts a GObject to a GTK Grid.
+func IsGrid(obj glib.IObject) (*gtk.Grid, error) {
+	// Make type assertion (as per gtk.go).
+	if grid, ok := obj.(*gtk.Grid); ok {
+		return grid, nil
+	}
+	return nil, errors.New("not a *gtk.Grid")
+}
+
+// IsFlowBox converts a GObject to a GTK FlowBox.
+func IsFlowBox(obj glib.IObject) (*gtk.FlowBox, error) {
+	// Make type assertion (as per gtk.go).
+	if flowBox, ok := obj.(*gtk.FlowBox); ok {
+		return flowBox, nil
+	}
+	return nil, errors.New("not a *gtk.FlowBox")
+}
+
+// IsDialog converts a GObject to a GTK Dialog.
+func IsDialog(obj glib.IObject) (*gtk.Dialog, error) {
+	// Make type assertion (as per gtk.go).
+	if dialog, ok := obj.(*gtk.Dialog); ok {
+		return dialog, nil
+	}
+	return nil, errors.New("not a *gtk.Dialog")
+}
+
 // IsFileChooserDialog converts a GObject to a GTK FileChooserDialog.
 func IsFileChooserDialog(obj glib.IObject) (*gtk.FileChooserDialog, error) {
 	// Make type assertion (as per gtk.go).
@@ -517,13 +1852,31 @@ func IsTextView(obj glib.IObject) (*gtk.TextView, error) {
 	return nil, errors.New("not a *gtk.TextView")
 }
 
-// IsGLArea converts a GObject to a GTK GLArea.
-func IsGLArea(obj glib.IObject) (*gtk.GLArea, error) {
+// IsBox converts a GObject to a GTK Box.
+func IsBox(obj glib.IObject) (*gtk.Box, error) {
+	// Make type assertion (as per gtk.go).
+	if item, ok := obj.(*gtk.Box); ok {
+		return item, nil
+	}
+	return nil, errors.New("not a *gtk.Box")
+}
+
+// IsComboBoxText converts a GObject to a GTK ComboBoxText.
+func IsComboBoxText(obj glib.IObject) (*gtk.ComboBoxText, error) {
+	// Make type assertion (as per gtk.go).
+	if item, ok := obj.(*gtk.ComboBoxText); ok {
+		return item, nil
+	}
+	return nil, errors.New("not a *gtk.ComboBoxText")
+}
+
+// IsCheckButton converts a GObject to a GTK CheckButton.
+func IsCheckButton(obj glib.IObject) (*gtk.CheckButton, error) {
 	// Make type assertion (as per gtk.go).
-	if item, ok := obj.(*gtk.GLArea); ok {
+	if item, ok := obj.(*gtk.CheckButton); ok {
 		return item, nil
 	}
-	return nil, errors.New("not a *gtk.GLArea")
+	return nil, errors.New("not a *gtk.CheckButton")
 }
 
 // UIErrorCheck checks a previous Is* function for any UI errors.
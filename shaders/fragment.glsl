@@ -1,7 +1,9 @@
 #version 150 core
+in vec2 fragTexCoord;
 out vec4 outColor;
+uniform sampler2D screen;
 
 void main()
 {
-    outColor = vec4(1.0, 1.0, 1.0, 1.0);
+    outColor = texture(screen, fragTexCoord);
 }
\ No newline at end of file